@@ -8,6 +8,8 @@ import (
 	"github.com/go-pg/pg"
 	"gopkg.in/go-playground/validator.v9"
 	"reflect"
+	"sync"
+	"time"
 )
 
 var (
@@ -30,12 +32,36 @@ type Application struct {
 	paginationStrategies *PaginationStrategies
 	maxPageSize          int
 	validate             *validator.Validate
+	errorHandler         ErrorHandlerFunc
+
+	expireLeaderCheckInterval time.Duration
+	expireBatchSize           int
+	expireBatchPause          time.Duration
+
+	migrationOptions *internal.MigrationOptions
 
 	// running indicates whether the Application
 	// is currently able to handle requests.
 	running bool
 
 	resourceExpirers *resourceExpirers
+
+	// expireStrategies contains the ExpireStrategies
+	// set for Resources.
+	expireStrategies map[*Resource]ExpireStrategy
+	// expireStrategiesMutex is the mutex protecting expireStrategies
+	expireStrategiesMutex *sync.Mutex
+
+	// expireHooks contains the OnExpireFuncs
+	// registered for Resources.
+	expireHooks map[*Resource][]OnExpireFunc
+	// expireHooksMutex is the mutex protecting expireHooks
+	expireHooksMutex *sync.Mutex
+
+	// realtimes contains all running Realtime instances.
+	realtimes map[*Realtime]struct{}
+	// realtimesMutex is the mutex protecting realtimes
+	realtimesMutex *sync.Mutex
 }
 
 // NewApplication returns a new Application
@@ -54,6 +80,26 @@ func NewApplication(options Options) *Application {
 		paginationStrategies: o.PaginationStrategies,
 		maxPageSize:          o.MaxPageSize,
 		validate:             o.Validate,
+		errorHandler:         o.ErrorHandler,
+
+		expireLeaderCheckInterval: o.ExpireLeaderCheckInterval,
+		expireBatchSize:           o.ExpireBatchSize,
+		expireBatchPause:          o.ExpireBatchPause,
+
+		migrationOptions: &internal.MigrationOptions{
+			AllowDestructive: o.AllowDestructiveMigrations,
+			Verify:           o.MigrationMode == MigrateVerify,
+			Skip:             o.MigrationMode == MigrateSkip,
+		},
+
+		expireStrategies:      make(map[*Resource]ExpireStrategy),
+		expireStrategiesMutex: &sync.Mutex{},
+
+		expireHooks:      make(map[*Resource][]OnExpireFunc),
+		expireHooksMutex: &sync.Mutex{},
+
+		realtimes:      make(map[*Realtime]struct{}),
+		realtimesMutex: &sync.Mutex{},
 	}
 }
 
@@ -86,10 +132,11 @@ func (app *Application) RegisterResource(model interface{}) (*Resource, error) {
 		return resource, nil
 	}
 
+	var registered []*Resource
 	for _, schema := range app.registry {
 		if _, ok := app.resources[schema]; !ok {
 			resource := &Resource{schema: schema}
-			err := resource.Initialize(app.DB())
+			err := resource.initialize(app.DB(), app.migrationOptions)
 			if err != nil {
 				return nil, fmt.Errorf(`error registering resource "%s": %s`, resource.JSONAPIName(), err.Error())
 			}
@@ -104,12 +151,107 @@ func (app *Application) RegisterResource(model interface{}) (*Resource, error) {
 			}
 
 			app.resources[schema] = resource
+			registered = append(registered, resource)
+		}
+	}
+
+	// create foreign keys once the tables
+	// of all related resources exist
+	for _, resource := range registered {
+		if err := resource.schema.CreateForeignKeys(app.DB(), app.migrationOptions); err != nil {
+			return nil, fmt.Errorf(`error registering resource "%s": %s`, resource.JSONAPIName(), err.Error())
+		}
+	}
+
+	// install realtime triggers for the
+	// new resources on running Realtime instances
+	for _, r := range app.runningRealtimes() {
+		if err := r.resourcesRegistered(registered); err != nil {
+			return nil, err
 		}
 	}
 
 	return app.resources[s], nil
 }
 
+// SetExpireStrategy sets the ExpireStrategy used
+// to expire the records of a Resource with an expire field.
+// It may be changed while the Application is running.
+func (app *Application) SetExpireStrategy(resource *Resource, strategy ExpireStrategy) {
+	app.expireStrategiesMutex.Lock()
+	app.expireStrategies[resource] = strategy
+	app.expireStrategiesMutex.Unlock()
+}
+
+// ExpireStrategy returns the ExpireStrategy
+// used to expire the records of a Resource.
+// Defaults to ExpireDelete.
+func (app *Application) ExpireStrategy(resource *Resource) ExpireStrategy {
+	app.expireStrategiesMutex.Lock()
+	defer app.expireStrategiesMutex.Unlock()
+
+	if strategy, ok := app.expireStrategies[resource]; ok {
+		return strategy
+	}
+	return ExpireDelete()
+}
+
+// ExpireStats returns the expiration statistics of all
+// Resources with an expire field by Resource.
+// Returns nil if the Application is not running.
+func (app *Application) ExpireStats() map[*Resource]*ExpireStats {
+	if !app.running {
+		return nil
+	}
+	return app.resourceExpirers.stats()
+}
+
+// OnExpireFunc is a function receiving
+// the Resource Model Instances that expired.
+type OnExpireFunc func(instances []interface{})
+
+// OnExpire registers a function to be invoked with the
+// Resource Model Instances of a Resource that expired,
+// after the ExpireStrategy's changes were committed.
+func (app *Application) OnExpire(resource *Resource, fn OnExpireFunc) {
+	app.expireHooksMutex.Lock()
+	app.expireHooks[resource] = append(app.expireHooks[resource], fn)
+	app.expireHooksMutex.Unlock()
+}
+
+// expired invokes the OnExpireFuncs registered for a Resource
+// with the Resource Model Instances that expired.
+func (app *Application) expired(resource *Resource, instances []interface{}) {
+	if len(instances) == 0 {
+		return
+	}
+
+	app.expireHooksMutex.Lock()
+	hooks := app.expireHooks[resource]
+	app.expireHooksMutex.Unlock()
+
+	for _, fn := range hooks {
+		app.runExpireHook(resource, fn, instances)
+	}
+}
+
+// runExpireHook invokes an OnExpireFunc,
+// passing panics to the ErrorHandler.
+func (app *Application) runExpireHook(resource *Resource, fn OnExpireFunc, instances []interface{}) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			app.handleError(fmt.Errorf(`panic in expire hook of resource "%s": %v`,
+				resource.JSONAPIName(), rec))
+		}
+	}()
+	fn(instances)
+}
+
+// handleError passes an error to the ErrorHandler.
+func (app *Application) handleError(err error) {
+	app.errorHandler(err)
+}
+
 // MustRegisterResource calls RegisterResource,
 // panicking if it encounters an error.
 func (app *Application) MustRegisterResource(model interface{}) *Resource {
@@ -142,3 +284,29 @@ func (app *Application) Run(ctx context.Context) {
 	// wait until context is done
 	<-ctx.Done()
 }
+
+// addRealtime adds a running Realtime instance.
+func (app *Application) addRealtime(r *Realtime) {
+	app.realtimesMutex.Lock()
+	app.realtimes[r] = struct{}{}
+	app.realtimesMutex.Unlock()
+}
+
+// removeRealtime removes a Realtime instance that stopped running.
+func (app *Application) removeRealtime(r *Realtime) {
+	app.realtimesMutex.Lock()
+	delete(app.realtimes, r)
+	app.realtimesMutex.Unlock()
+}
+
+// runningRealtimes returns all running Realtime instances.
+func (app *Application) runningRealtimes() []*Realtime {
+	app.realtimesMutex.Lock()
+	defer app.realtimesMutex.Unlock()
+
+	var realtimes []*Realtime
+	for r := range app.realtimes {
+		realtimes = append(realtimes, r)
+	}
+	return realtimes
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/google/jsonapi"
+	"github.com/json-iterator/go"
 	"github.com/satori/go.uuid"
 	"gopkg.in/go-playground/validator.v9"
 	"net/http"
@@ -21,6 +22,9 @@ type ApiError struct {
 	status int
 	code   string
 	detail string
+	// pointer is the JSON Pointer to the
+	// request document member causing the error
+	pointer string
 }
 
 // Error satisfies the error interface.
@@ -33,8 +37,31 @@ func (e *ApiError) Status() int {
 	return e.status
 }
 
+// SourcePointer returns the JSON Pointer to the request document
+// member causing the error, e.g. "/data/attributes/name".
+// Returns an empty string if the error has no source.
+func (e *ApiError) SourcePointer() string {
+	return e.pointer
+}
+
+// errorSource is the source member of a JSON API error object.
+type errorSource struct {
+	Pointer string `json:"pointer"`
+}
+
+// errorObject is a JSON API error object
+// including the source member.
+type errorObject struct {
+	*jsonapi.ErrorObject
+	Source *errorSource `json:"source,omitempty"`
+}
+
 // Payload satisfies the Response interface.
 func (e *ApiError) Payload() (string, error) {
+	if e.pointer != "" {
+		return e.payloadWithSource()
+	}
+
 	buf := new(bytes.Buffer)
 	err := jsonapi.MarshalErrors(buf, []*jsonapi.ErrorObject{e.ToErrorObject()})
 	if err != nil {
@@ -43,6 +70,22 @@ func (e *ApiError) Payload() (string, error) {
 	return buf.String(), nil
 }
 
+// payloadWithSource returns the error payload
+// including the source member, which is not
+// supported by jsonapi.ErrorObject.
+func (e *ApiError) payloadWithSource() (string, error) {
+	object := &errorObject{
+		ErrorObject: e.ToErrorObject(),
+		Source:      &errorSource{Pointer: e.pointer},
+	}
+
+	b, err := jsoniter.ConfigCompatibleWithStandardLibrary.Marshal(map[string][]*errorObject{"errors": {object}})
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
 // ToErrorObject converts the ApiError to a jsonapi.ErrorObject.
 func (e *ApiError) ToErrorObject() *jsonapi.ErrorObject {
 	u, err := uuid.NewV4()
@@ -68,6 +111,18 @@ func NewApiError(status int, code string, detail string) *ApiError {
 	}
 }
 
+// NewApiErrorWithPointer returns a new ApiError from a status code,
+// error code, error detail string and the JSON Pointer
+// to the request document member causing the error.
+func NewApiErrorWithPointer(status int, code string, detail string, pointer string) *ApiError {
+	return &ApiError{
+		status:  status,
+		code:    code,
+		detail:  detail,
+		pointer: pointer,
+	}
+}
+
 // NewErrorResponse returns a Response containing
 // an error payload according to the JSON API spec.
 // See http://jsonapi.org/format/#errors
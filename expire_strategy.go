@@ -0,0 +1,236 @@
+package jargo
+
+import (
+	"errors"
+	"fmt"
+	"github.com/crushedpixel/jargo/internal"
+	"github.com/go-pg/pg"
+	"github.com/go-pg/pg/orm"
+	"reflect"
+	"strings"
+)
+
+var errInvalidArchiveTable = errors.New("archive table name may only consist of [0-9,a-z,A-Z$_]")
+
+// An ExpireStrategy determines what happens to a Resource's
+// records once the value of their expire field is reached.
+//
+// ExpireStrategies are set per Resource using Application.SetExpireStrategy.
+// The default ExpireStrategy is ExpireDelete.
+type ExpireStrategy interface {
+	// condition returns an SQL condition records have to fulfill
+	// to be expired in addition to their expiration time being reached,
+	// allowing strategies that don't delete records to exclude
+	// records that were already expired.
+	// Returns an empty string if there is no such condition.
+	condition(e *resourceExpirer) string
+	// expire expires all records matching the where condition,
+	// returning the Resource Model Instances that expired.
+	expire(e *resourceExpirer, where string) ([]interface{}, error)
+}
+
+// ExpireDelete returns an ExpireStrategy deleting expired records.
+func ExpireDelete() ExpireStrategy {
+	return &deleteStrategy{}
+}
+
+type deleteStrategy struct{}
+
+func (s *deleteStrategy) condition(*resourceExpirer) string {
+	return ""
+}
+
+func (s *deleteStrategy) expire(e *resourceExpirer, where string) ([]interface{}, error) {
+	return e.queryInstances(e.app.DB(), fmt.Sprintf(`DELETE FROM "%s" AS "%s" WHERE %s RETURNING "%s".*`,
+		e.table, e.alias, where, e.alias))
+}
+
+// ExpireSoftDelete returns an ExpireStrategy setting
+// the timestamp column with the given name to the current time
+// for expired records, if it isn't set yet.
+func ExpireSoftDelete(column string) ExpireStrategy {
+	return &softDeleteStrategy{column: escapePGColumn(column)}
+}
+
+type softDeleteStrategy struct {
+	// SQL-safe column name
+	column string
+}
+
+func (s *softDeleteStrategy) condition(e *resourceExpirer) string {
+	return fmt.Sprintf(`"%s".%s IS NULL`, e.alias, s.column)
+}
+
+func (s *softDeleteStrategy) expire(e *resourceExpirer, where string) ([]interface{}, error) {
+	return e.queryInstances(e.app.DB(), fmt.Sprintf(`UPDATE "%s" AS "%s" SET %s = NOW() WHERE %s RETURNING "%s".*`,
+		e.table, e.alias, s.column, where, e.alias))
+}
+
+// ExpireMark returns an ExpireStrategy setting the column
+// with the given name to value for expired records,
+// e.g. to mark invoices as overdue.
+func ExpireMark(column string, value interface{}) ExpireStrategy {
+	return &markStrategy{
+		column: escapePGColumn(column),
+		value:  value,
+	}
+}
+
+type markStrategy struct {
+	// SQL-safe column name
+	column string
+	value  interface{}
+}
+
+// formattedValue returns the value formatted for use in queries.
+func (s *markStrategy) formattedValue(e *resourceExpirer) string {
+	return string(e.app.DB().FormatQuery(nil, "?", s.value))
+}
+
+func (s *markStrategy) condition(e *resourceExpirer) string {
+	return fmt.Sprintf(`"%s".%s IS DISTINCT FROM %s`, e.alias, s.column, s.formattedValue(e))
+}
+
+func (s *markStrategy) expire(e *resourceExpirer, where string) ([]interface{}, error) {
+	return e.queryInstances(e.app.DB(), fmt.Sprintf(`UPDATE "%s" AS "%s" SET %s = %s WHERE %s RETURNING "%s".*`,
+		e.table, e.alias, s.column, s.formattedValue(e), where, e.alias))
+}
+
+// archiveQuery moves all expired records into
+// the archive table, returning the moved records.
+const archiveQuery = `
+WITH expired AS (DELETE FROM "%s" AS "%s" WHERE %s RETURNING "%s".*),
+archived AS (INSERT INTO "%s" (%s) SELECT %s FROM expired)
+SELECT * FROM expired
+`
+
+// ExpireArchive returns an ExpireStrategy moving expired records
+// into the archive table with the given name.
+// If the archive table doesn't exist, it is created
+// with the same columns as the Resource's table.
+// Columns added to the Resource's table are added to the archive table
+// before archiving records, while columns that were removed
+// are kept in the archive table, but made nullable.
+func ExpireArchive(table string) ExpireStrategy {
+	if !internal.IsValidSQLName(table) {
+		panic(errInvalidArchiveTable)
+	}
+	return &archiveStrategy{table: table}
+}
+
+type archiveStrategy struct {
+	table string
+}
+
+func (s *archiveStrategy) condition(*resourceExpirer) string {
+	return ""
+}
+
+func (s *archiveStrategy) expire(e *resourceExpirer, where string) ([]interface{}, error) {
+	var instances []interface{}
+	err := e.app.DB().RunInTransaction(func(tx *pg.Tx) error {
+		// migrate the archive table along with the resource's table,
+		// so records are archived using an explicit column list
+		columns, err := internal.MigrateArchiveTable(tx, e.table, s.table)
+		if err != nil {
+			return fmt.Errorf(`error migrating archive table "%s": %s`, s.table, err.Error())
+		}
+		var quoted []string
+		for _, c := range columns {
+			quoted = append(quoted, fmt.Sprintf(`"%s"`, c))
+		}
+		list := strings.Join(quoted, ", ")
+
+		instances, err = e.queryInstances(tx, fmt.Sprintf(archiveQuery,
+			e.table, e.alias, where, e.alias,
+			s.table, list, list))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return instances, nil
+}
+
+// ExpireFunc is a function handling expired records.
+// It receives the expired Resource Model Instances and is called
+// inside the transaction the records were selected in.
+// It must update or delete all of the records using tx so they are
+// no longer considered expired. If any of the records is still expired
+// afterwards, the transaction is rolled back and the expiration is
+// retried after backing off, as if the ExpireFunc returned an error.
+type ExpireFunc func(tx *pg.Tx, instances []interface{}) error
+
+// ExpireCustom returns an ExpireStrategy passing
+// expired records to an ExpireFunc.
+func ExpireCustom(fn ExpireFunc) ExpireStrategy {
+	return &customStrategy{fn: fn}
+}
+
+type customStrategy struct {
+	fn ExpireFunc
+}
+
+func (s *customStrategy) condition(*resourceExpirer) string {
+	return ""
+}
+
+func (s *customStrategy) expire(e *resourceExpirer, where string) ([]interface{}, error) {
+	var instances []interface{}
+	err := e.app.DB().RunInTransaction(func(tx *pg.Tx) error {
+		// lock the selected records to prevent
+		// them from being expired concurrently
+		var err error
+		instances, err = e.queryInstances(tx, fmt.Sprintf(
+			`SELECT "%s".* FROM "%s" AS "%s" WHERE %s FOR UPDATE SKIP LOCKED`,
+			e.alias, e.table, e.alias, where))
+		if err != nil {
+			return err
+		}
+		if len(instances) == 0 {
+			return nil
+		}
+		if err := s.fn(tx, instances); err != nil {
+			return err
+		}
+
+		// records left expired would be selected again right away
+		var ids []interface{}
+		for _, instance := range instances {
+			ids = append(ids, e.resource.schema.ParseResourceModel(instance).Id())
+		}
+		var remaining int
+		if _, err := tx.QueryOne(pg.Scan(&remaining), fmt.Sprintf(
+			`SELECT COUNT(*) FROM "%s" AS "%s" WHERE "%s"."%s" IN (?) AND %s`,
+			e.table, e.alias, e.alias, internal.IdFieldColumn, e.expiredCondition(s)), pg.In(ids)); err != nil {
+			return err
+		}
+		if remaining > 0 {
+			return fmt.Errorf("ExpireFunc left %d of %d records expired", remaining, len(instances))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return instances, nil
+}
+
+// queryInstances executes a query returning records of the
+// expirer's Resource, returning them as Resource Model Instances.
+func (e *resourceExpirer) queryInstances(db orm.DB, query string, params ...interface{}) ([]interface{}, error) {
+	collection := reflect.ValueOf(e.resource.schema.NewPGModelCollection())
+	// get pointer to slice as expected by go-pg
+	ptr := reflect.New(collection.Type())
+	ptr.Elem().Set(collection)
+
+	if _, err := db.Query(ptr.Interface(), query, params...); err != nil {
+		return nil, err
+	}
+
+	var instances []interface{}
+	for _, instance := range e.resource.schema.ParsePGModelCollection(ptr.Elem().Interface()) {
+		instances = append(instances, instance.ToResourceModel())
+	}
+	return instances, nil
+}
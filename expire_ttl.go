@@ -0,0 +1,93 @@
+package jargo
+
+import (
+	"fmt"
+	"github.com/crushedpixel/jargo/internal"
+	"github.com/go-pg/pg"
+	"github.com/go-pg/pg/orm"
+	"reflect"
+	"time"
+)
+
+// refreshGranularity is the fraction of the ttl that has to pass
+// before the expiration of a record is refreshed again. Refreshing
+// records on every access would update them (firing their triggers,
+// e.g. causing realtime events) even if their expiration barely changes.
+const refreshGranularity = 10
+
+// refreshExpirationQuery extends the expiration of all
+// records with the given ids that did not expire yet
+// and whose expiration is earlier than the given threshold,
+// returning their ids and new expiration times.
+const refreshExpirationQuery = `
+UPDATE "%s" AS "%s" SET %s = NOW() + ? * INTERVAL '1 second'
+WHERE "%s"."%s" IN (?) AND %s > NOW() AND %s < NOW() + ? * INTERVAL '1 second'
+RETURNING "%s"."%s" AS "id", %s AS "expiration"
+`
+
+// RefreshExpiration extends the expiration of Resource Model Instances
+// by the ttl of the Resource's expire field, updating both the
+// database records and the Resource Model Instances passed.
+// Records that already expired are not refreshed, neither are records
+// whose expiration would be extended by less than a tenth of the ttl,
+// to avoid updating records on every access.
+//
+// data may be a Resource Model Instance
+// or Slice of Resource Model Instances.
+// Does nothing if the Resource has no expire field
+// or its expire field has no "ttl" option.
+func (r *Resource) RefreshExpiration(db orm.DB, data interface{}) error {
+	ttl := r.schema.ExpireTTL()
+	if ttl == 0 {
+		return nil
+	}
+
+	// collect instances by id
+	instances := make(map[string]interface{})
+	var ids []interface{}
+	add := func(instance interface{}) {
+		if reflect.ValueOf(instance).IsNil() {
+			return
+		}
+		id := r.schema.ParseResourceModel(instance).Id()
+		instances[fmt.Sprint(id)] = instance
+		ids = append(ids, id)
+	}
+	if r.schema.IsResourceModelCollection(data) {
+		v := reflect.ValueOf(data)
+		for i := 0; i < v.Len(); i++ {
+			add(v.Index(i).Interface())
+		}
+	} else {
+		add(data)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	table := r.schema.Table()
+	alias := r.schema.Alias()
+	field := r.schema.ExpireField()
+	filterColumn := escapePGColumn(field.PGFilterColumn())
+
+	var expirations []struct {
+		Id         string
+		Expiration time.Time
+	}
+	_, err := db.Query(&expirations, fmt.Sprintf(refreshExpirationQuery,
+		table, alias, escapePGColumn(field.ColumnName()),
+		alias, internal.IdFieldColumn, filterColumn, filterColumn,
+		alias, internal.IdFieldColumn, filterColumn,
+	), ttl.Seconds(), pg.In(ids), (ttl - ttl/refreshGranularity).Seconds())
+	if err != nil {
+		return err
+	}
+
+	// apply new expiration times to instances
+	for _, e := range expirations {
+		if instance, ok := instances[e.Id]; ok {
+			r.schema.SetExpiration(instance, e.Expiration)
+		}
+	}
+	return nil
+}
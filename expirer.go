@@ -6,7 +6,7 @@ import (
 	"github.com/crushedpixel/jargo/internal"
 	"github.com/go-pg/pg"
 	"github.com/json-iterator/go"
-	"log"
+	"hash/fnv"
 	"sync"
 	"time"
 )
@@ -17,19 +17,77 @@ type expireNotificationPayload struct {
 	// Interval is the amount of seconds
 	// until the next expiration is reached
 	Interval float64 `json:"interval"`
+	// OldInterval is the amount of seconds until
+	// the previous expiration time of an updated record
+	// is reached. Only set for updates.
+	OldInterval *float64 `json:"oldInterval"`
 }
 
+// rescheduleTolerance is the maximum difference between
+// the previous expiration time of an updated record and the scheduled
+// expiration time for the record to be considered the one scheduled,
+// accounting for the inaccuracy of computing times from intervals.
+const rescheduleTolerance = time.Second
+
+// leaderLockQuery tries to acquire the advisory lock
+// granting leadership for a resource expirer.
+const leaderLockQuery = `SELECT pg_try_advisory_lock(?)`
+
+// leaderCheckQuery checks whether the current session
+// still holds the advisory lock granting leadership
+// for a resource expirer. The lock's bigint key is
+// split into classid and objid by postgres.
+const leaderCheckQuery = `
+SELECT EXISTS (
+  SELECT 1 FROM pg_locks
+  WHERE locktype = 'advisory' AND granted AND pid = pg_backend_pid() AND objsubid = 1
+    AND classid = ((?::bigint >> 32) & 4294967295)::oid
+    AND objid = (?::bigint & 4294967295)::oid
+)`
+
 // resourceExpirers handles multiple resource expirers.
 type resourceExpirers struct {
 	app *Application
 	ctx context.Context
+
+	// leaderDB is a database handle with a single connection,
+	// holding the advisory locks granting leadership
+	// of resource expirers for the lifetime of its session.
+	leaderDB *pg.DB
+
+	// lock protects all variables below.
+	lock *sync.Mutex
+	// expirers contains the resource expirers by Resource.
+	expirers map[*Resource]*resourceExpirer
 }
 
 func newResourceExpirers(app *Application, ctx context.Context) *resourceExpirers {
-	return &resourceExpirers{
-		app: app,
-		ctx: ctx,
+	options := *app.DB().Options()
+	options.PoolSize = 1
+	// the connection must never be closed by the pool,
+	// as that would release the advisory locks held by it.
+	// a negative idle timeout disables closing idle connections,
+	// as zero is replaced by the default timeout.
+	options.IdleTimeout = -1
+	options.MaxConnAge = 0
+
+	e := &resourceExpirers{
+		app:      app,
+		ctx:      ctx,
+		leaderDB: pg.Connect(&options),
+
+		lock:     new(sync.Mutex),
+		expirers: make(map[*Resource]*resourceExpirer),
 	}
+
+	// closing the connection releases
+	// all advisory locks held by it
+	go func() {
+		<-ctx.Done()
+		e.leaderDB.Close()
+	}()
+
+	return e
 }
 
 func (e *resourceExpirers) addExpirer(resource *Resource) {
@@ -39,13 +97,51 @@ func (e *resourceExpirers) addExpirer(resource *Resource) {
 	default:
 	}
 
-	runResourceExpirer(e.ctx, e.app, resource)
+	expirer := runResourceExpirer(e.ctx, e.app, e.leaderDB, resource)
+
+	e.lock.Lock()
+	e.expirers[resource] = expirer
+	e.lock.Unlock()
+}
+
+// stats returns the statistics of all resource expirers.
+func (e *resourceExpirers) stats() map[*Resource]*ExpireStats {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	stats := make(map[*Resource]*ExpireStats)
+	for resource, expirer := range e.expirers {
+		stats[resource] = expirer.stats()
+	}
+	return stats
+}
+
+// ExpireStats contains statistics about the
+// expiration of a Resource's records by an Application.
+type ExpireStats struct {
+	// Leader indicates whether the Application is the instance
+	// responsible for expiring the Resource's records.
+	Leader bool
+	// Expired is the number of records expired.
+	Expired uint64
+	// Batches is the number of expiration batches executed.
+	Batches uint64
+	// LastBatchDuration is the duration of the most recent batch.
+	LastBatchDuration time.Duration
+	// MaxBatchDuration is the duration of the longest batch.
+	MaxBatchDuration time.Duration
+	// TotalBatchDuration is the duration of all batches combined.
+	TotalBatchDuration time.Duration
+	// NextExpiration is the time the next expiration is scheduled at,
+	// or the zero value of time.Time if none is scheduled.
+	NextExpiration time.Time
 }
 
 // resourceExpirer is responsible for expiring
 // a Resource's records.
 type resourceExpirer struct {
-	app *Application
+	app      *Application
+	resource *Resource
 	// resource table name for queries
 	table string
 	// resource alias for queries
@@ -53,6 +149,12 @@ type resourceExpirer struct {
 	// SQL-safe expire column name for queries
 	column string
 
+	// leaderDB is the database handle
+	// to acquire leadership with.
+	leaderDB *pg.DB
+	// lockKey is the key of the advisory lock granting leadership.
+	lockKey int64
+
 	// expirationChannel is the channel
 	// to send the next expiration times into.
 	expirationChannel chan time.Time
@@ -63,15 +165,37 @@ type resourceExpirer struct {
 	nextExpiration time.Time
 	// cancel is the CancelFunc for the task scheduling the next expiration.
 	cancel context.CancelFunc
+	// leader indicates whether the expirer holds the advisory lock,
+	// making it the only expirer of all application instances
+	// to expire the resource's records.
+	leader bool
+	// failures is the number of consecutive
+	// expirations that failed with an error.
+	failures uint
+
+	// expired is the number of records expired.
+	expired uint64
+	// batches is the number of batches executed.
+	batches uint64
+	// lastBatchDuration is the duration of the most recent batch.
+	lastBatchDuration time.Duration
+	// maxBatchDuration is the duration of the longest batch.
+	maxBatchDuration time.Duration
+	// totalBatchDuration is the duration of all batches combined.
+	totalBatchDuration time.Duration
 }
 
 // runResourceExpirer creates and starts a new resourceExpirer.
-func runResourceExpirer(ctx context.Context, app *Application, resource *Resource) *resourceExpirer {
+func runResourceExpirer(ctx context.Context, app *Application, leaderDB *pg.DB, resource *Resource) *resourceExpirer {
 	e := &resourceExpirer{
-		app:    app,
-		table:  resource.schema.Table(),
-		alias:  resource.schema.Alias(),
-		column: escapePGColumn(resource.schema.ExpireField().PGFilterColumn()),
+		app:      app,
+		resource: resource,
+		table:    resource.schema.Table(),
+		alias:    resource.schema.Alias(),
+		column:   escapePGColumn(resource.schema.ExpireField().PGFilterColumn()),
+
+		leaderDB: leaderDB,
+		lockKey:  leaderLockKey(resource.schema.Table()),
 
 		expirationChannel: make(chan time.Time, 0),
 
@@ -86,12 +210,121 @@ func runResourceExpirer(ctx context.Context, app *Application, resource *Resourc
 	// start expiration loop
 	go e.expirationLoop(ctx, expireNotificationChannel)
 
-	// fetch initial expiration time from database
-	go e.fetchNextExpiration(ctx)
+	// acquire leadership, fetching the initial
+	// expiration time from the database once acquired
+	go e.leadershipLoop(ctx)
 
 	return e
 }
 
+// leaderLockKey returns the key of the advisory lock
+// granting leadership for the expirer of a table.
+func leaderLockKey(table string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(internal.ExpireNotificationChannelName(table)))
+	return int64(h.Sum64())
+}
+
+// leadershipLoop periodically tries to acquire leadership
+// until ctx is done. Once acquired, it periodically checks
+// whether leadership was lost, e.g. due to a connection loss.
+func (e *resourceExpirer) leadershipLoop(ctx context.Context) {
+	ticker := time.NewTicker(e.app.expireLeaderCheckInterval)
+	defer ticker.Stop()
+	for {
+		e.updateLeadership(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// updateLeadership acquires leadership if possible,
+// or checks whether it is still held.
+func (e *resourceExpirer) updateLeadership(ctx context.Context) {
+	e.lock.Lock()
+	leader := e.leader
+	e.lock.Unlock()
+
+	var query string
+	var params []interface{}
+	if leader {
+		query = leaderCheckQuery
+		params = []interface{}{e.lockKey, e.lockKey}
+	} else {
+		query = leaderLockQuery
+		params = []interface{}{e.lockKey}
+	}
+
+	var held bool
+	if _, err := e.leaderDB.QueryOne(pg.Scan(&held), query, params...); err != nil {
+		e.app.handleError(fmt.Errorf(`error acquiring expiration leadership of table "%s": %s`,
+			e.table, err.Error()))
+		// leadership can't be guaranteed
+		// if the connection was lost
+		held = false
+	}
+	if held == leader {
+		return
+	}
+
+	e.lock.Lock()
+	e.leader = held
+	e.lock.Unlock()
+
+	if held {
+		// fetch the next expiration time, as it
+		// was not tracked while not being the leader
+		e.fetchNextExpiration(ctx)
+	} else {
+		// stop expiring records
+		select {
+		case e.expirationChannel <- time.Time{}:
+		case <-ctx.Done():
+		}
+	}
+}
+
+// stats returns the expirer's statistics.
+func (e *resourceExpirer) stats() *ExpireStats {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return &ExpireStats{
+		Leader:             e.leader,
+		Expired:            e.expired,
+		Batches:            e.batches,
+		LastBatchDuration:  e.lastBatchDuration,
+		MaxBatchDuration:   e.maxBatchDuration,
+		TotalBatchDuration: e.totalBatchDuration,
+		NextExpiration:     e.nextExpiration,
+	}
+}
+
+// recordBatch updates the statistics after a batch was executed.
+func (e *resourceExpirer) recordBatch(expired int, duration time.Duration) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.expired += uint64(expired)
+	e.batches++
+	e.lastBatchDuration = duration
+	if duration > e.maxBatchDuration {
+		e.maxBatchDuration = duration
+	}
+	e.totalBatchDuration += duration
+}
+
+// isLeader returns whether the expirer currently holds leadership.
+func (e *resourceExpirer) isLeader() bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.leader
+}
+
 func (e *resourceExpirer) expirationLoop(ctx context.Context, notificationChannel <-chan *pg.Notification) {
 	for {
 		select {
@@ -101,15 +334,26 @@ func (e *resourceExpirer) expirationLoop(ctx context.Context, notificationChanne
 			// received a notification from the expire database trigger
 			payload := &expireNotificationPayload{}
 			if err := jsoniter.Unmarshal([]byte(notification.Payload), payload); err != nil {
-				panic(err)
+				e.app.handleError(fmt.Errorf(`invalid expiration notification payload of table "%s": %s`,
+					e.table, err.Error()))
+				continue
 			}
 			ne := targetTime(payload.Interval)
 			e.lock.Lock()
-			// if next expiration time as reported by the trigger
-			// is smaller than the known next expiration time,
-			// send it into the expiration channel.
-			if e.nextExpiration.IsZero() || ne.Before(e.nextExpiration) {
-				go func() { e.expirationChannel <- ne }()
+			// expirations are only scheduled by the leader.
+			if e.leader {
+				if e.nextExpiration.IsZero() || ne.Before(e.nextExpiration) {
+					// if next expiration time as reported by the trigger
+					// is smaller than the known next expiration time,
+					// send it into the expiration channel.
+					go func() { e.expirationChannel <- ne }()
+				} else if payload.OldInterval != nil &&
+					!targetTime(*payload.OldInterval).After(e.nextExpiration.Add(rescheduleTolerance)) {
+					// the record scheduled to expire next
+					// was moved to a later time, so the
+					// next expiration time has to be fetched again.
+					go e.fetchNextExpiration(ctx)
+				}
 			}
 			e.lock.Unlock()
 		case <-ctx.Done():
@@ -148,18 +392,131 @@ func (e *resourceExpirer) scheduleNextExpiration(ctx context.Context, expiration
 func (e *resourceExpirer) expirationTask(parentCtx, ctx context.Context, expirationTime time.Time) {
 	select {
 	case <-time.After(expirationTime.Sub(time.Now())):
-		query := fmt.Sprintf(`DELETE FROM "%s" AS "%s" WHERE %s <= NOW()`,
-			e.table, e.alias, e.column)
-		if _, err := e.app.DB().Exec(query); err != nil {
-			log.Printf("Error expiring records: %s\n", err.Error())
+		if !e.isLeader() {
+			// leadership was lost in the meantime
+			return
+		}
+
+		switch e.expireBatches(ctx) {
+		case expireStopped:
+			return
+		case expireFailed:
+			// retry after backing off, as fetching the next
+			// expiration time would return the records
+			// that failed to expire, retrying immediately
+			select {
+			case e.expirationChannel <- time.Now().Add(e.failureBackoff()):
+			case <-parentCtx.Done():
+			}
+			return
 		}
 
+		e.lock.Lock()
+		e.failures = 0
+		e.lock.Unlock()
+
 		// fetch next expiration time
 		e.fetchNextExpiration(parentCtx)
 	case <-ctx.Done():
 	}
 }
 
+// failureBackoff returns the time to wait before retrying
+// a failed expiration, starting at the Application's expire batch pause
+// and doubling with every consecutive failure up to its leader check interval.
+func (e *resourceExpirer) failureBackoff() time.Duration {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	backoff := e.app.expireBatchPause
+	for i := uint(0); i < e.failures && backoff < e.app.expireLeaderCheckInterval; i++ {
+		backoff *= 2
+	}
+	if backoff > e.app.expireLeaderCheckInterval {
+		backoff = e.app.expireLeaderCheckInterval
+	}
+	e.failures++
+	return backoff
+}
+
+// expireResult is the result of expireBatches.
+type expireResult int
+
+const (
+	// expireDone indicates that all expired records were handled.
+	expireDone expireResult = iota
+	// expireStopped indicates that ctx was done or leadership
+	// was lost before all records were expired.
+	expireStopped
+	// expireFailed indicates that the ExpireStrategy returned an error.
+	expireFailed
+)
+
+// expireBatches expires all expired records in batches
+// of the Application's expire batch size, pausing between batches.
+func (e *resourceExpirer) expireBatches(ctx context.Context) expireResult {
+	strategy := e.app.ExpireStrategy(e.resource)
+	where := e.batchCondition(e.expiredCondition(strategy))
+
+	for {
+		if !e.verifyLeadership() {
+			return expireStopped
+		}
+
+		start := time.Now()
+		expired, err := strategy.expire(e, where)
+		if err != nil {
+			e.app.handleError(fmt.Errorf(`error expiring records of table "%s": %s`, e.table, err.Error()))
+			return expireFailed
+		}
+		e.recordBatch(len(expired), time.Since(start))
+		e.app.expired(e.resource, expired)
+
+		if len(expired) < e.app.expireBatchSize {
+			// all expired records were handled
+			return expireDone
+		}
+
+		select {
+		case <-time.After(e.app.expireBatchPause):
+		case <-ctx.Done():
+			return expireStopped
+		}
+	}
+}
+
+// verifyLeadership checks whether the advisory lock granting
+// leadership is still held before expiring a batch, as it is released
+// if the leader connection was lost since leadership was last checked.
+// Returns false if leadership was lost.
+func (e *resourceExpirer) verifyLeadership() bool {
+	if !e.isLeader() {
+		return false
+	}
+
+	var held bool
+	if _, err := e.leaderDB.QueryOne(pg.Scan(&held), leaderCheckQuery, e.lockKey, e.lockKey); err != nil {
+		e.app.handleError(fmt.Errorf(`error checking expiration leadership of table "%s": %s`,
+			e.table, err.Error()))
+		held = false
+	}
+	if !held {
+		// leadership is acquired again by the leadership loop
+		e.lock.Lock()
+		e.leader = false
+		e.lock.Unlock()
+	}
+	return held
+}
+
+// batchCondition limits the records
+// matching a condition to a single batch.
+func (e *resourceExpirer) batchCondition(where string) string {
+	return fmt.Sprintf(`"%s"."%s" IN (SELECT "%s"."%s" FROM "%s" AS "%s" WHERE %s LIMIT %d FOR UPDATE SKIP LOCKED)`,
+		e.alias, internal.IdFieldColumn,
+		e.alias, internal.IdFieldColumn, e.table, e.alias, where, e.app.expireBatchSize)
+}
+
 // fetchNextExpiration fetches the time at which the next record expires.
 // Returns time.Time's zero value if there is no record to expire.
 func (e *resourceExpirer) fetchNextExpiration(ctx context.Context) {
@@ -171,13 +528,14 @@ func (e *resourceExpirer) fetchNextExpiration(ctx context.Context) {
 
 	var nextExpiration time.Time
 	// fetch amount of seconds until next expiration time is reached
-	query := fmt.Sprintf(`SELECT EXTRACT(EPOCH FROM (%s - NOW())) AS "interval" FROM "%s" AS "%s" ORDER BY %s ASC LIMIT 1`,
-		e.column, e.table, e.alias, e.column)
+	query := fmt.Sprintf(`SELECT EXTRACT(EPOCH FROM (%s - NOW())) AS "interval" FROM "%s" AS "%s" %s ORDER BY %s ASC LIMIT 1`,
+		e.column, e.table, e.alias, e.strategyWhere(e.app.ExpireStrategy(e.resource)), e.column)
 	if _, err := e.app.DB().QueryOne(mdl, query); err == nil {
 		nextExpiration = targetTime(mdl.Interval)
 	} else {
 		if err != pg.ErrNoRows {
-			log.Printf("Error fetching next expiration time: %s\n", err.Error())
+			e.app.handleError(fmt.Errorf(`error fetching next expiration time of table "%s": %s`,
+				e.table, err.Error()))
 		}
 	}
 
@@ -190,6 +548,26 @@ func (e *resourceExpirer) fetchNextExpiration(ctx context.Context) {
 	}
 }
 
+// expiredCondition returns the condition
+// records to be expired by strategy fulfill.
+func (e *resourceExpirer) expiredCondition(strategy ExpireStrategy) string {
+	where := fmt.Sprintf("%s <= NOW()", e.column)
+	if c := strategy.condition(e); c != "" {
+		where += fmt.Sprintf(" AND (%s)", c)
+	}
+	return where
+}
+
+// strategyWhere returns the WHERE clause excluding
+// records that were already expired by strategy,
+// or an empty string if there are no such records.
+func (e *resourceExpirer) strategyWhere(strategy ExpireStrategy) string {
+	if c := strategy.condition(e); c != "" {
+		return fmt.Sprintf("WHERE %s", c)
+	}
+	return ""
+}
+
 // targetTime returns the time that will be in sec seconds.
 func targetTime(sec float64) time.Time {
 	return time.Now().Add(time.Duration(float64(time.Second) * sec))
@@ -1,9 +1,12 @@
 package jargo
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/crushedpixel/jargo/internal"
+	"github.com/go-pg/pg"
 	"github.com/go-pg/pg/orm"
+	"github.com/json-iterator/go"
 	"strings"
 )
 
@@ -13,24 +16,44 @@ import (
 // to filter results by certain attributes.
 type Filters struct {
 	resource *Resource
-	filters  map[internal.SchemaField]*Filter
+	filters  map[filterTarget]*Filter
+}
+
+// filterTarget is the field filtered by, and for
+// attributes with the "type:jsonb" option, the JSON path
+// within the field's value filtered by, if any.
+type filterTarget struct {
+	field internal.SchemaField
+	// path contains the keys of the JSON path,
+	// joined by internal.JSONPathSeparator.
+	path string
 }
 
 // A Filter contains values to be filtered by,
 // each of the filter operators being connected
 // via a logical OR, and all of the values for
 // an operator being connected via a logical AND.
+//
+// Contains is only supported on attributes with the "type:jsonb" option,
+// filtering by values containing the JSON values given,
+// either as JSON string or as value to be marshalled to JSON.
+// Eq and Not values of such attributes are given the same way,
+// unless filtering by a JSON path.
+//
+// When filtering by a JSON path, values are compared to the
+// text representation of the value at the path.
 type Filter struct {
-	Eq   []interface{}
-	Not  []interface{}
-	Like []interface{}
-	Lt   []interface{}
-	Lte  []interface{}
-	Gt   []interface{}
-	Gte  []interface{}
+	Eq       []interface{}
+	Not      []interface{}
+	Like     []interface{}
+	Lt       []interface{}
+	Lte      []interface{}
+	Gt       []interface{}
+	Gte      []interface{}
+	Contains []interface{}
 }
 
-func newFilters(r *Resource, filters map[internal.SchemaField]*Filter) *Filters {
+func newFilters(r *Resource, filters map[filterTarget]*Filter) *Filters {
 	return &Filters{
 		resource: r,
 		filters:  filters,
@@ -38,36 +61,119 @@ func newFilters(r *Resource, filters map[internal.SchemaField]*Filter) *Filters
 }
 
 func (f *Filters) applyToQuery(q *orm.Query) {
-	for field, filter := range f.filters {
-		filter.applyToQuery(q, field)
+	for target, filter := range f.filters {
+		filter.applyToQuery(q, target)
 	}
 }
 
-func (f *Filter) applyToQuery(q *orm.Query, field internal.SchemaField) {
-	andWhereOr(q, field, "=", f.Eq)
-	andWhereOr(q, field, "<>", f.Not)
-	andWhereOr(q, field, "LIKE", f.Like)
-	andWhereOr(q, field, "<", f.Lt)
-	andWhereOr(q, field, "<=", f.Lte)
-	andWhereOr(q, field, ">", f.Gt)
-	andWhereOr(q, field, ">=", f.Gte)
+func (f *Filter) applyToQuery(q *orm.Query, target filterTarget) {
+	// go-pg does not escape the fields in where clauses,
+	// so we need to do it ourselves
+	column := escapePGColumn(target.field.PGFilterColumn())
+
+	if target.path != "" {
+		path := pg.Array(strings.Split(target.path, internal.JSONPathSeparator))
+		// #>> extracts the value at the path as text,
+		// #> extracts it as jsonb
+		andWhereOr(q, column+" #>> ?", "=", "?", f.Eq, path)
+		andWhereOr(q, column+" #>> ?", "<>", "?", f.Not, path)
+		andWhereOr(q, column+" #>> ?", "LIKE", "?", f.Like, path)
+		andWhereOr(q, column+" #>> ?", "<", "?", f.Lt, path)
+		andWhereOr(q, column+" #>> ?", "<=", "?", f.Lte, path)
+		andWhereOr(q, column+" #>> ?", ">", "?", f.Gt, path)
+		andWhereOr(q, column+" #>> ?", ">=", "?", f.Gte, path)
+		andWhereOr(q, column+" #> ?", "@>", "?::jsonb", f.Contains, path)
+		return
+	}
+
+	if jf, ok := target.field.(internal.JSONBField); ok && jf.JSONB() {
+		// compare values as jsonb
+		andWhereOr(q, column, "=", "?::jsonb", f.Eq)
+		andWhereOr(q, column, "<>", "?::jsonb", f.Not)
+		andWhereOr(q, column, "@>", "?::jsonb", f.Contains)
+		return
+	}
+
+	andWhereOr(q, column, "=", "?", f.Eq)
+	andWhereOr(q, column, "<>", "?", f.Not)
+	andWhereOr(q, column, "LIKE", "?", f.Like)
+	andWhereOr(q, column, "<", "?", f.Lt)
+	andWhereOr(q, column, "<=", "?", f.Lte)
+	andWhereOr(q, column, ">", "?", f.Gt)
+	andWhereOr(q, column, ">=", "?", f.Gte)
 }
 
-// generates an AND WHERE (xxx OR xxx) clause
-func andWhereOr(q *orm.Query, field internal.SchemaField, op string, values []interface{}) {
+// generates an AND WHERE (xxx OR xxx) clause,
+// comparing expr to each of the values using op,
+// formatting the values using placeholder.
+// params are the parameters of placeholders in expr.
+func andWhereOr(q *orm.Query, expr string, op string, placeholder string, values []interface{}, params ...interface{}) {
 	if len(values) > 0 {
 		q.WhereGroup(func(q *orm.Query) (*orm.Query, error) {
 			for _, val := range values {
-				// go-pg does not escape the fields in where clauses,
-				// so we need to do it ourselves
-				f := escapePGColumn(field.PGFilterColumn())
-				q = q.WhereOr(fmt.Sprintf("%s %s ?", f, op), val)
+				args := append(append([]interface{}{}, params...), val)
+				q = q.WhereOr(fmt.Sprintf("%s %s %s", expr, op, placeholder), args...)
 			}
 			return q, nil
 		})
 	}
 }
 
+// jsonValues returns the JSON representation of filter values
+// compared to jsonb values. Strings are expected to be JSON already.
+//
+// Returns an error if a value is not valid JSON
+// or can not be marshalled to JSON.
+func jsonValues(values []interface{}) ([]interface{}, error) {
+	var converted []interface{}
+	for _, val := range values {
+		var b []byte
+		switch v := val.(type) {
+		case string:
+			b = []byte(v)
+		case json.RawMessage:
+			b = v
+		default:
+			var err error
+			b, err = jsoniter.ConfigCompatibleWithStandardLibrary.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if !jsoniter.ConfigCompatibleWithStandardLibrary.Valid(b) {
+			return nil, fmt.Errorf("invalid JSON value: %s", b)
+		}
+		converted = append(converted, string(b))
+	}
+	return converted, nil
+}
+
+// jsonFilter returns a copy of a Filter on a jsonb attribute
+// with the values compared to jsonb values converted to JSON.
+// Eq and Not values are only compared to jsonb values
+// when not filtering by a JSON path.
+func jsonFilter(filter *Filter, compareJSON bool) (*Filter, error) {
+	converted := *filter
+
+	var err error
+	converted.Contains, err = jsonValues(filter.Contains)
+	if err != nil {
+		return nil, err
+	}
+	if compareJSON {
+		converted.Eq, err = jsonValues(filter.Eq)
+		if err != nil {
+			return nil, err
+		}
+		converted.Not, err = jsonValues(filter.Not)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &converted, nil
+}
+
 // ParseFilters parses creates a Filters instance
 // for the given map of filter parameters.
 // These parameters can be created manually
@@ -96,6 +202,8 @@ func (r *Resource) ParseFilters(parsed map[string]map[string][]interface{}) (*Fi
 				filter.Gt = append(filter.Gt, values...)
 			case "GTE":
 				filter.Gte = append(filter.Gte, values...)
+			case "CONTAINS":
+				filter.Contains = append(filter.Contains, values...)
 			default:
 				return nil, ErrInvalidQueryParams(fmt.Sprintf(`unknown filter operator "%s"`, op))
 			}
@@ -113,12 +221,21 @@ func (r *Resource) ParseFilters(parsed map[string]map[string][]interface{}) (*Fi
 // Filters returns a Filters instance for a map of
 // JSON API field names and Filter instances.
 //
+// Attributes with the "type:jsonb" option may be filtered
+// by a JSON path within their value, appending the path's keys
+// to the field name, separated by dots, e.g. "settings.theme".
+//
 // Returns an error if a field is not a valid
 // JSON API field name for this resource
 // or a filter operator is not supported.
 func (r *Resource) Filters(filters map[string]*Filter) (*Filters, error) {
-	f := make(map[internal.SchemaField]*Filter)
-	for fieldName, filter := range filters {
+	f := make(map[filterTarget]*Filter)
+	for name, filter := range filters {
+		fieldName, path, ok := internal.SplitJSONPath(name)
+		if !ok {
+			return nil, fmt.Errorf(`invalid JSON path in filter parameter: "%s"`, name)
+		}
+
 		// find resource field with matching jsonapi name
 		var field internal.SchemaField
 		for _, rf := range r.schema.Fields() {
@@ -128,13 +245,39 @@ func (r *Resource) Filters(filters map[string]*Filter) (*Filters, error) {
 			}
 		}
 		if field == nil {
-			return nil, fmt.Errorf(`unknown filter parameter: "%s"`, fieldName)
+			return nil, fmt.Errorf(`unknown filter parameter: "%s"`, name)
 		}
 		if !field.Filterable() {
 			return nil, fmt.Errorf(`filtering by "%s" is disabled`, fieldName)
 		}
 
-		f[field] = filter
+		jf, ok := field.(internal.JSONBField)
+		jsonb := ok && jf.JSONB()
+		if path != nil && !jsonb {
+			return nil, fmt.Errorf(`filtering by JSON path is only supported on jsonb attributes: "%s"`, name)
+		}
+		if len(filter.Contains) > 0 && !jsonb {
+			return nil, fmt.Errorf(`"contains" filter operator is only supported on jsonb attributes: "%s"`, name)
+		}
+		if path == nil && jsonb && (len(filter.Like) > 0 ||
+			len(filter.Lt) > 0 || len(filter.Lte) > 0 || len(filter.Gt) > 0 || len(filter.Gte) > 0) {
+			return nil, fmt.Errorf(`jsonb attribute "%s" only supports the "eq", "not" and "contains" filter operators`, name)
+		}
+
+		if jsonb {
+			// convert the values compared to jsonb values
+			// to JSON, without modifying the Filter passed
+			converted, err := jsonFilter(filter, path == nil)
+			if err != nil {
+				return nil, fmt.Errorf(`invalid filter value for "%s": %s`, name, err.Error())
+			}
+			filter = converted
+		}
+
+		f[filterTarget{
+			field: field,
+			path:  strings.Join(path, internal.JSONPathSeparator),
+		}] = filter
 	}
 
 	return newFilters(r, f), nil
@@ -25,6 +25,8 @@ var (
 	errAutoTimestampsWriteable   = errors.New(`"createdAt" and "updatedAt" options are only allowed on writable (non-readonly) fields`)
 	errExpireType                = errors.New(`"expire" option is only allowed on fields of type time.Time or *time.Time`)
 	errMultipleExpireFields      = errors.New(`"expire" option may not occur on multiple attributes`)
+	errTTLWithoutExpire          = errors.New(`"ttl" option may only be used in conjunction with the "expire" option`)
+	errInvalidTTL                = errors.New(`"ttl" option value has to be a positive duration, e.g. "30m"`)
 
 	autoTimestampsType = reflect.TypeOf(&time.Time{})
 )
@@ -43,6 +45,11 @@ type attrField struct {
 	notnull bool
 
 	validation string
+
+	// the values allowed by the "enum" option
+	enumValues []string
+	// the sql expression of the "check" option
+	sqlCheck string
 }
 
 func newAttrField(schema *Schema, f *reflect.StructField) SchemaField {
@@ -85,8 +92,9 @@ func newAttrField(schema *Schema, f *reflect.StructField) SchemaField {
 		case optionType:
 			field.pgType = value
 		case optionReadonly, optionNoSort, optionNoFilter,
-			optionOmitempty, optionUnique, optionDefault,
-			optionCreatedAt, optionUpdatedAt, optionExpire:
+			optionOmitempty, optionUnique, optionDefault, optionRenamedFrom,
+			optionIndex, optionIndexWhere, optionEnum, optionCheck,
+			optionCreatedAt, optionUpdatedAt, optionExpire, optionTTL:
 			// these were handled and should therefore
 			// not trigger the default handler.
 		default:
@@ -100,6 +108,11 @@ func newAttrField(schema *Schema, f *reflect.StructField) SchemaField {
 		field.pgType = "uuid"
 	}
 
+	// validate jsonb type
+	if field.pgType == jsonbType && !isJSONBType(field.fieldType) {
+		panic(errJSONBType)
+	}
+
 	createdAt := isSet(parsed.Options, optionCreatedAt)
 	updatedAt := isSet(parsed.Options, optionUpdatedAt)
 	expire := isSet(parsed.Options, optionExpire)
@@ -139,11 +152,35 @@ func newAttrField(schema *Schema, f *reflect.StructField) SchemaField {
 		panic(errExpireType)
 	}
 
+	// parse ttl option
+	var ttl time.Duration
+	if value, ok := parsed.Options[optionTTL]; ok {
+		if !expire {
+			panic(errTTLWithoutExpire)
+		}
+		var err error
+		ttl, err = time.ParseDuration(value)
+		if err != nil || ttl <= 0 {
+			panic(errInvalidTTL)
+		}
+	}
+
 	// validate sql column
-	if !isValidSQLName(field.column) {
+	if !IsValidSQLName(field.column) {
 		panic(errInvalidColumnName)
 	}
 
+	// parse enum and check options
+	if value, ok := parsed.Options[optionEnum]; ok {
+		field.enumValues = parseEnumValues(value, field.fieldType)
+	}
+	if value, ok := parsed.Options[optionCheck]; ok {
+		if value == "" {
+			panic(errEmptyCheck)
+		}
+		field.sqlCheck = value
+	}
+
 	// store "validate" struct tag
 	field.validation = f.Tag.Get(validationTag)
 
@@ -152,12 +189,12 @@ func newAttrField(schema *Schema, f *reflect.StructField) SchemaField {
 	field.pgF = field.pgAttrFields()
 
 	// wrap updatedAt and expire fields in
-	// their specific types for afterCreateTable hook
+	// their specific types for their triggers
 	if updatedAt {
 		return &updatedAtField{field}
 	}
 	if expire {
-		return &expireField{field}
+		return &expireField{field, ttl}
 	}
 
 	return field
@@ -181,14 +218,38 @@ func (f *attrField) jsonapiJoinFields() []reflect.StructField {
 }
 
 func (f *attrField) Sortable() bool {
-	// override sortable to take f.notnull flag into account
-	return f.jargoSortable && !f.isNullable()
+	// override sortable to take f.notnull flag into account.
+	// jsonb values have no meaningful order to paginate by.
+	return f.jargoSortable && !f.isNullable() && !f.JSONB()
+}
+
+// JSONB returns whether the field is stored in a jsonb column
+// using the "type:jsonb" option.
+func (f *attrField) JSONB() bool {
+	return f.pgType == jsonbType
 }
 
 func (f *attrField) isNullable() bool {
 	return isNullable(f.fieldType) && !f.notnull
 }
 
+// EnumValues returns the values allowed by the "enum" option,
+// or nil if the option is not set.
+func (f *attrField) EnumValues() []string {
+	return f.enumValues
+}
+
+func (f *attrField) checks() []string {
+	var checks []string
+	if len(f.enumValues) > 0 {
+		checks = append(checks, enumCheck(f.column, f.enumValues))
+	}
+	if f.sqlCheck != "" {
+		checks = append(checks, f.sqlCheck)
+	}
+	return checks
+}
+
 func (f *attrField) jsonapiAttrFields() []reflect.StructField {
 	if f.name == unexportedFieldName {
 		return []reflect.StructField{}
@@ -441,5 +502,30 @@ func (i *attrFieldInstance) apply(v *reflect.Value) {
 }
 
 func (i *attrFieldInstance) validate(validate *validator.Validate) error {
+	if err := i.validateEnum(); err != nil {
+		return err
+	}
 	return validate.Var(i.value, i.field.validation)
 }
+
+// validateEnum returns an error if the field has
+// the "enum" option and its value is not allowed.
+func (i *attrFieldInstance) validateEnum() error {
+	if len(i.field.enumValues) == 0 || i.value == nil {
+		return nil
+	}
+
+	v := reflect.ValueOf(i.value)
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	for _, allowed := range i.field.enumValues {
+		if v.String() == allowed {
+			return nil
+		}
+	}
+	return &EnumValueError{Field: i.field.name, Values: i.field.enumValues}
+}
@@ -21,6 +21,14 @@ type baseField struct {
 	jsonapiOmitempty bool
 
 	sqlUnique bool
+	// the name of the composite unique
+	// constraint the field is part of
+	sqlUniqueGroup string
+	// the column the field's column was previously named,
+	// which is renamed to the field's column when migrating
+	sqlRenamedFrom string
+	// the field's index declaration, if any
+	sqlIndex *fieldIndex
 
 	jsonapiF []reflect.StructField
 	pgF      []reflect.StructField
@@ -80,11 +88,44 @@ func newBaseField(schema *Schema, f *reflect.StructField) *baseField {
 	field.jargoWritable = !isSet(parsed.Options, optionReadonly)
 	field.jargoSortable = !isSet(parsed.Options, optionNoSort)
 	field.jargoFilterable = !isSet(parsed.Options, optionNoFilter)
-	field.sqlUnique = isSet(parsed.Options, optionUnique)
+	if value, ok := parsed.Options[optionUnique]; ok {
+		if value == "" {
+			field.sqlUnique = true
+		} else if IsValidSQLName(value) {
+			field.sqlUniqueGroup = value
+		} else {
+			panic(errInvalidUniqueGroup)
+		}
+	}
 	field.jsonapiOmitempty = isSet(parsed.Options, optionOmitempty)
 	if field.jsonapiOmitempty && !field.jsonapiExported {
 		panic(errJsonapiOptionOnUnexportedField)
 	}
 
+	if value, ok := parsed.Options[optionRenamedFrom]; ok {
+		if !IsValidSQLName(value) {
+			panic(errInvalidColumnName)
+		}
+		field.sqlRenamedFrom = value
+	}
+
+	field.sqlIndex = parseFieldIndex(parsed.Options)
+
 	return field
 }
+
+func (f *baseField) renamedFrom() string {
+	return f.sqlRenamedFrom
+}
+
+func (f *baseField) index() *fieldIndex {
+	return f.sqlIndex
+}
+
+func (f *baseField) unique() bool {
+	return f.sqlUnique
+}
+
+func (f *baseField) uniqueGroup() string {
+	return f.sqlUniqueGroup
+}
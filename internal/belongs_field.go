@@ -18,6 +18,10 @@ type belongsToField struct {
 	// whether the id field was
 	// changed to a pointer type
 	idFieldPointer bool
+
+	// the ON DELETE action of the
+	// relation's foreign key, if any
+	onDelete string
 }
 
 func newBelongsToField(r SchemaRegistry, schema *Schema, f *reflect.StructField) SchemaField {
@@ -31,6 +35,9 @@ func newBelongsToField(r SchemaRegistry, schema *Schema, f *reflect.StructField)
 		relationField: base,
 	}
 
+	parsed := parseJargoTag(f.Tag.Get(jargoFieldTag))
+	field.onDelete = parseOnDelete(parsed.Options, base.nullable)
+
 	// TODO: fail if there are invalid struct tag options
 
 	return field
@@ -121,22 +128,25 @@ func (f *belongsToField) pgBelongsToFields(joinField bool) []reflect.StructField
 
 // relationIdFieldType returns the type of the relation's id field.
 func (f *belongsToField) relationIdFieldType() reflect.Type {
-	var schema *Schema
+	return f.relationSchema().IdField().typ()
+}
+
+// relationSchema returns the Schema of the related resource.
+func (f *belongsToField) relationSchema() *Schema {
 	if f.schema.resourceModelType == f.relationType {
 		// if the related resource is of the same type
 		// as the resource being registered right now,
 		// it's not registered in the registry yet.
 		// therefore, get schema from field itself.
-		schema = f.schema
-	} else {
-		// ensure relation schema is registered
-		var err error
-		schema, err = f.registry.RegisterSchema(f.relationType)
-		if err != nil {
-			panic(err)
-		}
+		return f.schema
+	}
+
+	// ensure relation schema is registered
+	schema, err := f.registry.RegisterSchema(f.relationType)
+	if err != nil {
+		panic(err)
 	}
-	return schema.IdField().typ()
+	return schema
 }
 
 // relationIdFieldName returns the name of the foreign id field.
@@ -152,7 +162,7 @@ func (f *belongsToField) relationIdFieldColumn() string {
 func (f *belongsToField) createInstance() schemaFieldInstance {
 	return &belongsToFieldInstance{
 		relationFieldInstance: f.relationField.createInstance(),
-		field: f,
+		field:                 f,
 	}
 }
 
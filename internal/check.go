@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+const (
+	// checkPrefix is the prefix of the names of all check constraints
+	// managed by jargo. Check constraints of a Schema's table with this prefix
+	// that are not declared by the Schema are dropped when migrating.
+	checkPrefix = "jargo_chk_"
+	// checkConstraintType is the pg_constraint type of check constraints.
+	checkConstraintType = "c"
+
+	// enumValueSeparator separates the values of the "enum" option.
+	enumValueSeparator = "|"
+)
+
+var (
+	errEnumType       = errors.New(`"enum" option is only allowed on fields of type string or *string`)
+	errEmptyEnumValue = errors.New(`"enum" option values may not be empty`)
+	errEmptyCheck     = errors.New(`"check" option requires an sql expression`)
+)
+
+// EnumValueError is returned when validating an attribute
+// with the "enum" option whose value is not allowed.
+type EnumValueError struct {
+	Field  string
+	Values []string
+}
+
+func (e *EnumValueError) Error() string {
+	return fmt.Sprintf(`value of "%s" has to be one of: %s`, e.Field, strings.Join(e.Values, ", "))
+}
+
+// parseEnumValues parses the value of the "enum" option.
+func parseEnumValues(value string, typ reflect.Type) []string {
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.String {
+		panic(errEnumType)
+	}
+
+	values := strings.Split(value, enumValueSeparator)
+	for _, v := range values {
+		if v == "" {
+			panic(errEmptyEnumValue)
+		}
+	}
+	return values
+}
+
+// EnumField is a SchemaField restricted to a set of values
+// using the "enum" option.
+type EnumField interface {
+	// EnumValues returns the values allowed by the "enum" option,
+	// or nil if the option is not set.
+	EnumValues() []string
+}
+
+// checkedField is a SchemaField that may declare check constraints.
+type checkedField interface {
+	ColumnName() string
+	// checks returns the sql expressions
+	// of the field's check constraints.
+	checks() []string
+}
+
+// enumCheck returns the sql expression
+// restricting a column to the given values.
+func enumCheck(column string, values []string) string {
+	var quoted []string
+	for _, v := range values {
+		quoted = append(quoted, "'"+strings.Replace(v, "'", "''", -1)+"'")
+	}
+	return fmt.Sprintf(`"%s" IN (%s)`, column, strings.Join(quoted, ", "))
+}
+
+// parseChecks returns the check constraints
+// declared by the fields of a Schema, in field order,
+// registering them as constraints of their fields.
+//
+// Constraint names contain a hash of their definition,
+// causing constraints whose definition changed
+// to be dropped and recreated when migrating.
+func (s *Schema) parseChecks() []*Constraint {
+	var checks []*Constraint
+	for _, f := range s.fields {
+		cf, ok := f.(checkedField)
+		if !ok {
+			continue
+		}
+		for _, expression := range cf.checks() {
+			definition := fmt.Sprintf("CHECK (%s)", expression)
+			name := managedName(checkPrefix, s.table, cf.ColumnName(), definition)
+			s.registerConstraint(name, f)
+			checks = append(checks, &Constraint{
+				Name:       name,
+				Definition: definition,
+			})
+		}
+	}
+	return checks
+}
+
+// Checks returns the check constraints declared by the Schema's fields.
+func (s *Schema) Checks() []*Constraint {
+	return s.checks
+}
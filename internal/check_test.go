@@ -0,0 +1,54 @@
+package internal
+
+import (
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/go-playground/validator.v9"
+	"reflect"
+	"testing"
+)
+
+type checkTest struct {
+	Id     int64   `jargo:",table:check_tests"`
+	Status *string `jargo:",enum:draft|it's published"`
+	Price  int     `jargo:",check:price >= 0"`
+	Size   string  `jargo:",check:size IN ('s', 'm'),nosort"`
+}
+
+func TestParseChecks(t *testing.T) {
+	schema := registerSchema(t, checkTest{})
+
+	checks := schema.Checks()
+	assert.Len(t, checks, 3)
+	assert.Equal(t, `CHECK ("status" IN ('draft', 'it''s published'))`, checks[0].Definition)
+	assertManagedName(t, checkPrefix, "check_tests", "status", checks[0].Name)
+	assert.Equal(t, `CHECK (price >= 0)`, checks[1].Definition)
+	// commas in sql expressions don't separate options
+	assert.Equal(t, `CHECK (size IN ('s', 'm'))`, checks[2].Definition)
+
+	fields := schema.ConstraintFields(checks[1].Name)
+	assert.Len(t, fields, 1)
+	assert.Equal(t, "price", fields[0].JSONAPIName())
+}
+
+type invalidEnumTest struct {
+	Id    int64
+	Count int `jargo:",enum:1|2"`
+}
+
+func TestEnumType(t *testing.T) {
+	_, err := make(SchemaRegistry).RegisterSchema(reflect.TypeOf(invalidEnumTest{}))
+	assert.Equal(t, errEnumType, err)
+}
+
+func TestValidateEnum(t *testing.T) {
+	schema := registerSchema(t, checkTest{})
+
+	validate := validator.New()
+
+	draft := "draft"
+	assert.Nil(t, schema.ParseResourceModel(&checkTest{Status: &draft}).Validate(validate))
+	assert.Nil(t, schema.ParseResourceModel(&checkTest{}).Validate(validate))
+
+	deleted := "deleted"
+	assert.IsType(t, &EnumValueError{}, schema.ParseResourceModel(&checkTest{Status: &deleted}).Validate(validate))
+}
@@ -0,0 +1,96 @@
+package internal
+
+import (
+	"fmt"
+	"github.com/go-pg/pg/orm"
+)
+
+// Constraint contains the definition of a table constraint managed by jargo.
+type Constraint struct {
+	Name string `json:"name"`
+	// Definition is the constraint definition,
+	// e.g. "CHECK (price > 0)".
+	Definition string `json:"definition"`
+}
+
+const addConstraintQuery = `ALTER TABLE "%s" ADD CONSTRAINT "%s" %s`
+
+const dropConstraintQuery = `ALTER TABLE "%s" DROP CONSTRAINT IF EXISTS "%s"`
+
+// managedConstraintsQuery selects the names and definitions of all constraints
+// of a type whose names have a prefix on a table, identified by its quoted name.
+const managedConstraintsQuery = `
+SELECT c.conname AS "name", pg_get_constraintdef(c.oid) AS "definition"
+FROM pg_constraint c
+WHERE c.conrelid = to_regclass(?) AND c.contype = ? AND c.conname LIKE ?
+ORDER BY c.conname
+`
+
+// managedConstraints returns all constraints of a table
+// of the given pg_constraint type whose names have the given prefix.
+func managedConstraints(db orm.DB, table string, contype string, prefix string) ([]*Constraint, error) {
+	var constraints []*Constraint
+	if _, err := db.Query(&constraints, managedConstraintsQuery,
+		fmt.Sprintf(`"%s"`, table), contype, prefixPattern(prefix)); err != nil {
+		return nil, err
+	}
+	return constraints, nil
+}
+
+// constraintChanges returns the changes required to migrate the
+// existing managed constraints to the desired ones, using the given
+// change types. Constraints that are no longer declared are dropped
+// before new constraints are added.
+func (s *Schema) constraintChanges(existing []*Constraint, desired []*Constraint, add ChangeType, drop ChangeType) []*Change {
+	existingByName := make(map[string]*Constraint)
+	for _, c := range existing {
+		existingByName[c.Name] = c
+	}
+	desiredByName := make(map[string]*Constraint)
+	for _, c := range desired {
+		desiredByName[c.Name] = c
+	}
+
+	var changes []*Change
+	for _, e := range existing {
+		if _, ok := desiredByName[e.Name]; !ok {
+			changes = append(changes, &Change{
+				Type:    drop,
+				Table:   s.table,
+				Name:    e.Name,
+				SQL:     fmt.Sprintf(dropConstraintQuery, s.table, e.Name),
+				DownSQL: fmt.Sprintf(addConstraintQuery, s.table, e.Name, e.Definition),
+			})
+		}
+	}
+	for _, d := range desired {
+		if _, ok := existingByName[d.Name]; !ok {
+			changes = append(changes, &Change{
+				Type:    add,
+				Table:   s.table,
+				Name:    d.Name,
+				SQL:     fmt.Sprintf(addConstraintQuery, s.table, d.Name, d.Definition),
+				DownSQL: fmt.Sprintf(dropConstraintQuery, s.table, d.Name),
+			})
+		}
+	}
+	return changes
+}
+
+// migrateConstraints adds the desired constraints that don't exist yet
+// and drops managed constraints of the given type and prefix
+// that are no longer declared.
+func (s *Schema) migrateConstraints(db orm.DB, contype string, prefix string, desired []*Constraint) error {
+	existing, err := managedConstraints(db, s.table, contype, prefix)
+	if err != nil {
+		return err
+	}
+	// the change types are irrelevant
+	// as the changes are executed right away
+	for _, c := range s.constraintChanges(existing, desired, "", "") {
+		if _, err := db.Exec(c.SQL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
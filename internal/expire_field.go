@@ -2,7 +2,8 @@ package internal
 
 import (
 	"fmt"
-	"github.com/go-pg/pg"
+	"reflect"
+	"time"
 )
 
 // ExpireNotificationChannelName returns the expire
@@ -13,44 +14,106 @@ func ExpireNotificationChannelName(table string) string {
 
 type expireField struct {
 	*attrField
+
+	// ttl is the duration the expiration is extended by
+	// whenever a record is accessed, or 0 if it is never extended.
+	ttl time.Duration
+}
+
+// ExpireTTL returns the duration the expiration of the
+// Schema's records is extended by whenever they are accessed.
+// Returns 0 if the Schema has no expire field
+// or its expire field has no "ttl" option.
+func (s *Schema) ExpireTTL() time.Duration {
+	if f, ok := s.ExpireField().(*expireField); ok {
+		return f.ttl
+	}
+	return 0
 }
 
-// expireTriggerQuery creates a trigger function
+// SetExpiration sets the value of the expire field
+// of a Resource Model Instance.
+//
+// Panics if the Schema has no expire field.
+func (s *Schema) SetExpiration(instance interface{}, expiration time.Time) {
+	f, ok := s.ExpireField().(*expireField)
+	if !ok {
+		panic("schema has no expire field")
+	}
+
+	v := reflect.ValueOf(instance)
+	if v.IsNil() {
+		panic(errNilPointer)
+	}
+	field := v.Elem().FieldByName(f.fieldName)
+	if f.fieldType.Kind() == reflect.Ptr {
+		field.Set(reflect.ValueOf(&expiration))
+	} else {
+		field.Set(reflect.ValueOf(expiration))
+	}
+}
+
+// expireTriggerFunctionQuery creates a trigger function
 // that notifies the expire notification channel
 // whenever a row was inserted, deleted or the
 // value of its expire column changed.
-const expireTriggerQuery = `
-CREATE OR REPLACE FUNCTION jargo_expire_trigger_%s_func()
+//
+// For updates, the interval until the previous
+// expiration time is sent as well, allowing the expirer
+// to reschedule when an expiration was moved to a later time.
+const expireTriggerFunctionQuery = `
+CREATE OR REPLACE FUNCTION %s()
 RETURNS TRIGGER AS $$
 DECLARE
   interval double precision;
+  old_interval double precision;
 BEGIN
-  IF TG_OP = 'INSERT' OR (TG_OP = 'UPDATE' AND NEW."%s" != OLD."%s") THEN
+  IF TG_OP = 'INSERT' OR (TG_OP = 'UPDATE' AND NEW."%s" IS DISTINCT FROM OLD."%s") THEN
     SELECT EXTRACT(EPOCH FROM (NEW."%s" - NOW())) INTO interval;
+    IF TG_OP = 'UPDATE' THEN
+      SELECT EXTRACT(EPOCH FROM (OLD."%s" - NOW())) INTO old_interval;
+    END IF;
     PERFORM pg_notify('%s', json_build_object(
       'type', TG_OP,
-      'interval', interval
+      'interval', interval,
+      'oldInterval', old_interval
     )::text);
   END IF;
   RETURN NEW;
 END;
 $$ LANGUAGE plpgsql;
+`
 
-DROP TRIGGER IF EXISTS zzz_jargo_expire_trigger ON "%s";
+// expireTriggerQuery creates the trigger
+// executing the expire trigger function.
+const expireTriggerQuery = `
+DROP TRIGGER IF EXISTS %s ON "%s";
 
-CREATE TRIGGER zzz_jargo_expire_trigger
+CREATE TRIGGER %s
 AFTER INSERT OR UPDATE ON "%s"
-FOR EACH ROW EXECUTE PROCEDURE jargo_expire_trigger_%s_func();
+FOR EACH ROW EXECUTE PROCEDURE %s();
 `
 
-func (f *expireField) afterCreateTable(db *pg.DB) error {
-	_, err := db.Exec(fmt.Sprintf(expireTriggerQuery,
-		f.schema.table,
+func (f *expireField) triggerName() string {
+	return "zzz_jargo_expire_trigger"
+}
+
+func (f *expireField) triggerFunctionName() string {
+	return fmt.Sprintf("jargo_expire_trigger_%s_func", f.schema.table)
+}
+
+func (f *expireField) triggerFunctionQuery() string {
+	return fmt.Sprintf(expireTriggerFunctionQuery,
+		f.triggerFunctionName(),
 		f.column, f.column,
+		f.column,
 		f.column, ExpireNotificationChannelName(f.schema.table),
+	)
+}
 
-		f.schema.table,                 // DROP TRIGGER
-		f.schema.table, f.schema.table, // CREATE TRIGGER
-	))
-	return err
+func (f *expireField) triggerQuery() string {
+	return f.triggerFunctionQuery() + fmt.Sprintf(expireTriggerQuery,
+		f.triggerName(), f.schema.table, // DROP TRIGGER
+		f.triggerName(), f.schema.table, f.triggerFunctionName(), // CREATE TRIGGER
+	)
 }
@@ -0,0 +1,131 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-pg/pg"
+)
+
+const (
+	// foreignKeyPrefix is the prefix of the names of all foreign keys
+	// managed by jargo. Foreign keys of a Schema's table with this prefix
+	// that are not declared by the Schema are dropped when migrating.
+	foreignKeyPrefix = "jargo_fk_"
+	// foreignKeyConstraintType is the pg_constraint type of foreign keys.
+	foreignKeyConstraintType = "f"
+
+	onDeleteCascade  = "cascade"
+	onDeleteSetNull  = "setNull"
+	onDeleteRestrict = "restrict"
+)
+
+var (
+	errInvalidOnDelete    = errors.New(`"onDelete" option has to be one of "cascade", "setNull" or "restrict"`)
+	errSetNullNotNullable = errors.New(`"onDelete:setNull" option is only allowed on nullable belongsTo relations`)
+)
+
+// parseOnDelete parses the "onDelete" option of a belongsTo relation,
+// returning the corresponding ON DELETE action of its foreign key.
+// Returns an empty string if the option is not set.
+func parseOnDelete(options map[string]string, nullable bool) string {
+	value, ok := options[optionOnDelete]
+	if !ok {
+		return ""
+	}
+
+	switch value {
+	case onDeleteCascade:
+		return "CASCADE"
+	case onDeleteSetNull:
+		if !nullable {
+			panic(errSetNullNotNullable)
+		}
+		return "SET NULL"
+	case onDeleteRestrict:
+		return "RESTRICT"
+	default:
+		panic(errInvalidOnDelete)
+	}
+}
+
+// A Reference is the foreign key of a belongsTo relation.
+type Reference struct {
+	// Schema is the Schema containing the belongsTo relation.
+	Schema *Schema
+	// Field is the belongsTo relation field.
+	Field SchemaField
+	// Related is the Schema referenced by the relation.
+	Related *Schema
+}
+
+// registerReference registers the foreign key with the given name.
+func (s *Schema) registerReference(name string, reference *Reference) {
+	if s.references == nil {
+		s.references = make(map[string]*Reference)
+	}
+	s.references[name] = reference
+}
+
+// Reference returns the foreign key with the given name
+// of either one of the Schema's belongsTo relations,
+// or a belongsTo relation referencing the Schema.
+// Returns nil if there is no such foreign key.
+func (s *Schema) Reference(constraint string) *Reference {
+	return s.references[constraint]
+}
+
+// parseForeignKeys returns the foreign keys of the Schema's
+// belongsTo relations with the "onDelete" option, in field order,
+// registering them with both the Schema and the related Schema.
+//
+// As the related Schemas have to be registered,
+// it may only be called once the Schema's models were generated.
+func (s *Schema) parseForeignKeys() []*Constraint {
+	var foreignKeys []*Constraint
+	for _, f := range s.fields {
+		bf, ok := f.(*belongsToField)
+		if !ok || bf.onDelete == "" {
+			continue
+		}
+
+		related := bf.relationSchema()
+		column := bf.relationIdFieldColumn()
+		definition := fmt.Sprintf(`FOREIGN KEY ("%s") REFERENCES "%s" ("%s") ON DELETE %s`,
+			column, related.table, IdFieldColumn, bf.onDelete)
+		name := managedName(foreignKeyPrefix, s.table, column, definition)
+
+		reference := &Reference{
+			Schema:  s,
+			Field:   f,
+			Related: related,
+		}
+		s.registerReference(name, reference)
+		related.registerReference(name, reference)
+
+		foreignKeys = append(foreignKeys, &Constraint{
+			Name:       name,
+			Definition: definition,
+		})
+	}
+	return foreignKeys
+}
+
+// ForeignKeys returns the foreign keys declared by the Schema's fields.
+func (s *Schema) ForeignKeys() []*Constraint {
+	return s.foreignKeys
+}
+
+// CreateForeignKeys creates the foreign keys of the Schema's
+// belongsTo relations with the "onDelete" option if they don't exist yet,
+// dropping managed foreign keys that are no longer declared.
+//
+// As the referenced tables have to exist, it has to be called
+// after CreateTable was called for all related Schemas.
+// Does nothing if options specify verifying or skipping migrations,
+// as foreign keys are verified by CreateTable.
+func (s *Schema) CreateForeignKeys(db *pg.DB, options *MigrationOptions) error {
+	if options != nil && (options.Skip || options.Verify) {
+		return nil
+	}
+	return s.migrateConstraints(db, foreignKeyConstraintType, foreignKeyPrefix, s.foreignKeys)
+}
@@ -0,0 +1,49 @@
+package internal
+
+import (
+	"github.com/stretchr/testify/assert"
+	"reflect"
+	"testing"
+)
+
+type foreignKeyParent struct {
+	Id int64 `jargo:",table:fk_parents"`
+}
+
+type foreignKeyChild struct {
+	Id       int64             `jargo:",table:fk_children"`
+	Parent   *foreignKeyParent `jargo:",belongsTo,onDelete:setNull"`
+	Required foreignKeyParent  `jargo:",belongsTo,onDelete:cascade"`
+	Other    *foreignKeyParent `jargo:",belongsTo"`
+}
+
+func TestParseForeignKeys(t *testing.T) {
+	schemas := registerSchemas(t, foreignKeyChild{}, foreignKeyParent{})
+	child, parent := schemas[0], schemas[1]
+
+	foreignKeys := child.ForeignKeys()
+	assert.Len(t, foreignKeys, 2)
+	assert.Equal(t, `FOREIGN KEY ("parent_id") REFERENCES "fk_parents" ("id") ON DELETE SET NULL`,
+		foreignKeys[0].Definition)
+	assertManagedName(t, foreignKeyPrefix, "fk_children", "parent_id", foreignKeys[0].Name)
+	assert.Equal(t, `FOREIGN KEY ("required_id") REFERENCES "fk_parents" ("id") ON DELETE CASCADE`,
+		foreignKeys[1].Definition)
+
+	// references are registered with both schemas
+	reference := parent.Reference(foreignKeys[0].Name)
+	assert.NotNil(t, reference)
+	assert.Equal(t, child, reference.Schema)
+	assert.Equal(t, parent, reference.Related)
+	assert.Equal(t, "parent", reference.Field.JSONAPIName())
+	assert.Equal(t, reference, child.Reference(foreignKeys[0].Name))
+}
+
+type setNullNotNullable struct {
+	Id     int64
+	Parent foreignKeyParent `jargo:",belongsTo,onDelete:setNull"`
+}
+
+func TestOnDeleteSetNullNotNullable(t *testing.T) {
+	_, err := make(SchemaRegistry).RegisterSchema(reflect.TypeOf(setNullNotNullable{}))
+	assert.Equal(t, errSetNullNotNullable, err)
+}
@@ -61,7 +61,7 @@ func newIdField(schema *Schema, f *reflect.StructField) SchemaField {
 	idf.pgF = idf.pgIdFields()
 
 	// wrap id fields with uuid type in uuidIdField
-	// for afterCreateTable hook
+	// for the uuid extension
 	if kind == uuid {
 		return &uuidIdField{idf}
 	}
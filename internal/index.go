@@ -0,0 +1,328 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-pg/pg/orm"
+	"hash/fnv"
+	"strings"
+)
+
+const (
+	// indexPrefix is the prefix of the names of all indexes managed by jargo.
+	// Indexes of a Schema's table with this prefix
+	// that are not declared by the Schema are dropped when migrating.
+	indexPrefix = "jargo_idx_"
+	// maxIdentifierLength is the maximum length of postgres identifiers.
+	maxIdentifierLength = 63
+
+	indexOrderDesc = "desc"
+	indexOrderAsc  = "asc"
+)
+
+var (
+	errInvalidIndexName       = errors.New("index name may only consist of [0-9,a-z,A-Z$_]")
+	errInvalidIndexOrder      = errors.New(`"index" option order has to be either "asc" or "desc"`)
+	errIndexWhereWithoutIndex = errors.New(`"indexWhere" option may only be used in conjunction with the "index" option`)
+	errIndexWithoutColumn     = errors.New(`"index" option is only allowed on fields with a database column`)
+
+	errConflictingIndexWhere = func(name string) error {
+		return errors.New(fmt.Sprintf(`fields of index "%s" have conflicting "indexWhere" options`, name))
+	}
+)
+
+// fieldIndex is the index declaration of a field.
+type fieldIndex struct {
+	// name is the name of the composite index the field is part of,
+	// or an empty string if the field has its own index.
+	name string
+	// desc indicates whether the field's column
+	// is indexed in descending order.
+	desc bool
+	// where is the condition of a partial index,
+	// or an empty string.
+	where string
+}
+
+// parseFieldIndex parses the "index" and "indexWhere" options.
+// Returns nil if the "index" option is not set.
+//
+// The "index" option value has the form "name:order",
+// where both name and order may be omitted.
+func parseFieldIndex(options map[string]string) *fieldIndex {
+	value, ok := options[optionIndex]
+	if !ok {
+		if _, ok := options[optionIndexWhere]; ok {
+			panic(errIndexWhereWithoutIndex)
+		}
+		return nil
+	}
+
+	index := &fieldIndex{
+		where: options[optionIndexWhere],
+	}
+
+	spl := strings.SplitN(value, ":", 2)
+	index.name = spl[0]
+	if index.name != "" && !IsValidSQLName(index.name) {
+		panic(errInvalidIndexName)
+	}
+	if len(spl) > 1 {
+		switch spl[1] {
+		case indexOrderDesc:
+			index.desc = true
+		case indexOrderAsc:
+		default:
+			panic(errInvalidIndexOrder)
+		}
+	}
+
+	return index
+}
+
+// indexedField is a SchemaField that may declare an index.
+type indexedField interface {
+	ColumnName() string
+	Sortable() bool
+	// index returns the field's index declaration,
+	// or nil if the field is not indexed.
+	index() *fieldIndex
+	// uniqueGroup returns the name of the composite
+	// unique constraint the field is part of,
+	// or an empty string.
+	uniqueGroup() string
+}
+
+// Index contains the definition of an index managed by jargo.
+type Index struct {
+	Name string `json:"name"`
+	// Definition is the statement creating the index.
+	Definition string `json:"definition"`
+}
+
+// parseIndexes returns the indexes declared
+// by the fields of a Schema, in field order.
+//
+// Fields with an index name share a composite index,
+// with columns in field order. Fields without an index name
+// get an index of their own, named after their column.
+//
+// If all fields of an index are sortable,
+// the id column is appended in descending order,
+// matching the order results are sorted in when not sorting by id,
+// so the index can be used for sorting as well.
+//
+// Fields with a unique group share a composite unique index,
+// which is registered as unique constraint of its fields.
+//
+// Index names contain a hash of their definition,
+// causing indexes whose definition changed
+// to be dropped and recreated when migrating.
+func (s *Schema) parseIndexes() []*Index {
+	type declaration struct {
+		name     string
+		unique   bool
+		fields   []SchemaField
+		columns  []string
+		where    string
+		sortable bool
+	}
+
+	var declarations []*declaration
+	byKey := make(map[string]*declaration)
+	declare := func(name string, unique bool) *declaration {
+		key := name
+		if unique {
+			// unique groups and index names
+			// are in separate namespaces
+			key = "unique:" + name
+		}
+		d, ok := byKey[key]
+		if !ok {
+			d = &declaration{
+				name:     name,
+				unique:   unique,
+				sortable: !unique,
+			}
+			byKey[key] = d
+			declarations = append(declarations, d)
+		}
+		return d
+	}
+
+	for _, f := range s.fields {
+		ixf, ok := f.(indexedField)
+		if !ok {
+			continue
+		}
+
+		if index := ixf.index(); index != nil {
+			if ixf.ColumnName() == "" {
+				panic(errIndexWithoutColumn)
+			}
+
+			name := index.name
+			if name == "" {
+				name = ixf.ColumnName()
+			}
+			d := declare(name, false)
+			if index.where != "" && d.where != "" && index.where != d.where {
+				panic(errConflictingIndexWhere(name))
+			}
+			if d.where == "" {
+				d.where = index.where
+			}
+
+			column := fmt.Sprintf(`"%s"`, ixf.ColumnName())
+			if index.desc {
+				column += " DESC"
+			}
+			d.fields = append(d.fields, f)
+			d.columns = append(d.columns, column)
+			d.sortable = d.sortable && ixf.Sortable()
+		}
+
+		if group := ixf.uniqueGroup(); group != "" {
+			if ixf.ColumnName() == "" {
+				panic(errUniqueWithoutColumn)
+			}
+
+			d := declare(group, true)
+			d.fields = append(d.fields, f)
+			d.columns = append(d.columns, fmt.Sprintf(`"%s"`, ixf.ColumnName()))
+		}
+	}
+
+	var indexes []*Index
+	for _, d := range declarations {
+		columns := d.columns
+		if d.sortable {
+			columns = append(columns, fmt.Sprintf(`"%s" DESC`, IdFieldColumn))
+		}
+		on := fmt.Sprintf(`"%s" (%s)`, s.table, strings.Join(columns, ", "))
+		if d.where != "" {
+			on += " WHERE " + d.where
+		}
+
+		unique := ""
+		if d.unique {
+			unique = "UNIQUE "
+		}
+		name := managedName(indexPrefix, s.table, d.name, unique+on)
+		if d.unique {
+			s.registerConstraint(name, d.fields...)
+		}
+		indexes = append(indexes, &Index{
+			Name:       name,
+			Definition: fmt.Sprintf(createIndexQuery, unique, name, on),
+		})
+	}
+	return indexes
+}
+
+// managedName returns the name of a database object managed by jargo,
+// consisting of the prefix, the table name, the declared name
+// and a hash of the object's definition.
+// Names exceeding the maximum identifier length are
+// truncated before the hash, keeping names unique.
+func managedName(prefix string, table string, name string, definition string) string {
+	h := fnv.New32a()
+	h.Write([]byte(definition))
+	hash := fmt.Sprintf("_%08x", h.Sum32())
+
+	base := fmt.Sprintf("%s%s_%s", prefix, table, name)
+	if len(base)+len(hash) > maxIdentifierLength {
+		base = base[:maxIdentifierLength-len(hash)]
+	}
+	return base + hash
+}
+
+// Indexes returns the indexes declared by the Schema's fields.
+func (s *Schema) Indexes() []*Index {
+	return s.indexes
+}
+
+const createIndexQuery = `CREATE %sINDEX IF NOT EXISTS "%s" ON %s`
+
+const dropIndexQuery = `DROP INDEX IF EXISTS "%s"`
+
+// tableIndexesQuery selects the names and definitions of all
+// indexes managed by jargo on a table, identified by its quoted name.
+const tableIndexesQuery = `
+SELECT c.relname AS "name", pg_get_indexdef(i.indexrelid) AS "definition"
+FROM pg_index i
+JOIN pg_class c ON c.oid = i.indexrelid
+WHERE i.indrelid = to_regclass(?) AND c.relname LIKE ?
+ORDER BY c.relname
+`
+
+// tableIndexes returns all indexes managed by jargo on a table.
+func tableIndexes(db orm.DB, table string) ([]*Index, error) {
+	var indexes []*Index
+	if _, err := db.Query(&indexes, tableIndexesQuery,
+		fmt.Sprintf(`"%s"`, table), prefixPattern(indexPrefix)); err != nil {
+		return nil, err
+	}
+	return indexes, nil
+}
+
+// prefixPattern returns a LIKE pattern
+// matching all names with the given prefix.
+func prefixPattern(prefix string) string {
+	return strings.Replace(prefix, "_", `\_`, -1) + "%"
+}
+
+// indexChanges returns the changes required to migrate
+// the existing managed indexes to the desired indexes.
+// Indexes that are no longer declared are dropped
+// before new indexes are created.
+func (s *Schema) indexChanges(existing []*Index, desired []*Index) []*Change {
+	existingByName := make(map[string]*Index)
+	for _, i := range existing {
+		existingByName[i.Name] = i
+	}
+	desiredByName := make(map[string]*Index)
+	for _, i := range desired {
+		desiredByName[i.Name] = i
+	}
+
+	var changes []*Change
+	for _, e := range existing {
+		if _, ok := desiredByName[e.Name]; !ok {
+			changes = append(changes, &Change{
+				Type:    DropIndex,
+				Table:   s.table,
+				Name:    e.Name,
+				SQL:     fmt.Sprintf(dropIndexQuery, e.Name),
+				DownSQL: e.Definition,
+			})
+		}
+	}
+	for _, d := range desired {
+		if _, ok := existingByName[d.Name]; !ok {
+			changes = append(changes, &Change{
+				Type:    CreateIndex,
+				Table:   s.table,
+				Name:    d.Name,
+				SQL:     d.Definition,
+				DownSQL: fmt.Sprintf(dropIndexQuery, d.Name),
+			})
+		}
+	}
+	return changes
+}
+
+// migrateIndexes creates the indexes declared by the Schema's fields
+// that don't exist yet and drops managed indexes that are no longer declared.
+func (s *Schema) migrateIndexes(db orm.DB) error {
+	existing, err := tableIndexes(db, s.table)
+	if err != nil {
+		return err
+	}
+	for _, c := range s.indexChanges(existing, s.indexes) {
+		if _, err := db.Exec(c.SQL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
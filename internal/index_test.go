@@ -0,0 +1,53 @@
+package internal
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type indexTest struct {
+	Id        int64  `jargo:",table:index_tests"`
+	Name      string `jargo:",index"`
+	FirstName string `jargo:",index:full_name"`
+	LastName  string `jargo:",index:full_name:desc"`
+	Email     string `jargo:",nosort,index,indexWhere:email <> ''"`
+}
+
+func TestParseIndexes(t *testing.T) {
+	schema := registerSchema(t, indexTest{})
+
+	indexes := schema.Indexes()
+	assert.Len(t, indexes, 3)
+
+	// sortable fields are indexed together with the id column
+	assertManagedName(t, indexPrefix, "index_tests", "name", indexes[0].Name)
+	assert.Equal(t, `CREATE INDEX IF NOT EXISTS "`+indexes[0].Name+`" ON "index_tests" ("name", "id" DESC)`,
+		indexes[0].Definition)
+
+	assertManagedName(t, indexPrefix, "index_tests", "full_name", indexes[1].Name)
+	assert.Equal(t, `CREATE INDEX IF NOT EXISTS "`+indexes[1].Name+`" ON "index_tests" ("first_name", "last_name" DESC, "id" DESC)`,
+		indexes[1].Definition)
+
+	assert.Equal(t, `CREATE INDEX IF NOT EXISTS "`+indexes[2].Name+`" ON "index_tests" ("email") WHERE email <> ''`,
+		indexes[2].Definition)
+
+	// existing indexes are kept, undeclared ones are dropped
+	changes := schema.indexChanges([]*Index{
+		indexes[0],
+		{Name: "jargo_idx_index_tests_old_00000000", Definition: "CREATE INDEX jargo_idx_index_tests_old_00000000 ON public.index_tests USING btree (old)"},
+	}, indexes)
+	assert.Len(t, changes, 3)
+	assert.Equal(t, DropIndex, changes[0].Type)
+	assert.Equal(t, `DROP INDEX IF EXISTS "jargo_idx_index_tests_old_00000000"`, changes[0].SQL)
+	assert.Equal(t, "CREATE INDEX jargo_idx_index_tests_old_00000000 ON public.index_tests USING btree (old)", changes[0].DownSQL)
+	assert.Equal(t, CreateIndex, changes[1].Type)
+	assert.Equal(t, indexes[1].Name, changes[1].Name)
+	assert.Equal(t, CreateIndex, changes[2].Type)
+	assert.Equal(t, indexes[2].Name, changes[2].Name)
+}
+
+func TestManagedName(t *testing.T) {
+	name := managedName(indexPrefix, "a_very_long_table_name_exceeding_limits", "a_very_long_index_name", "definition")
+	assert.Len(t, name, maxIdentifierLength)
+	assert.NotEqual(t, name, managedName(indexPrefix, "a_very_long_table_name_exceeding_limits", "a_very_long_index_name", "other"))
+}
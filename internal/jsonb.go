@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+)
+
+const (
+	// jsonbType is the value of the "type" option
+	// storing an attribute in a jsonb column.
+	jsonbType = "jsonb"
+
+	// JSONPathSeparator separates the keys of a JSON path
+	// in filter parameters, e.g. "settings.theme".
+	JSONPathSeparator = "."
+)
+
+var (
+	errJSONBType = errors.New(`"type:jsonb" option is only allowed on fields of struct, map or json.RawMessage type`)
+
+	jsonRawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// JSONBField is a SchemaField whose value
+// may be stored in a jsonb column.
+type JSONBField interface {
+	// JSONB returns whether the field is stored in a jsonb column
+	// using the "type:jsonb" option.
+	JSONB() bool
+}
+
+// isJSONBType returns whether typ may be stored in a jsonb column,
+// i.e. whether it is a struct, map or json.RawMessage type.
+func isJSONBType(typ reflect.Type) bool {
+	// pointer types are allowed
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+
+	if typ == jsonRawMessageType {
+		return true
+	}
+	switch typ.Kind() {
+	case reflect.Struct:
+		return !isTimeField(typ)
+	case reflect.Map:
+		return true
+	default:
+		return false
+	}
+}
+
+// SplitJSONPath splits a filter field name like "settings.theme"
+// into the JSON API name of a field and the keys of a JSON path.
+// Returns a nil path if the field name contains no JSON path
+// and ok=false if any of the path's keys is empty.
+func SplitJSONPath(name string) (field string, path []string, ok bool) {
+	spl := strings.Split(name, JSONPathSeparator)
+	for _, key := range spl {
+		if key == "" {
+			return "", nil, false
+		}
+	}
+	if len(spl) == 1 {
+		return name, nil, true
+	}
+	return spl[0], spl[1:], true
+}
@@ -0,0 +1,63 @@
+package internal
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"reflect"
+	"testing"
+)
+
+type jsonbSettings struct {
+	Theme string
+}
+
+type jsonbTest struct {
+	Id       int64
+	Settings *jsonbSettings    `jargo:",type:jsonb"`
+	Labels   map[string]string `jargo:",type:jsonb"`
+	Raw      json.RawMessage   `jargo:",type:jsonb"`
+	Plain    map[string]interface{}
+}
+
+func TestJSONBFields(t *testing.T) {
+	schema := registerSchema(t, jsonbTest{})
+	for _, f := range schema.Fields() {
+		jf, ok := f.(JSONBField)
+		switch f.JSONAPIName() {
+		case "settings", "labels", "raw":
+			assert.True(t, ok && jf.JSONB())
+			// jsonb values can't be sorted by
+			assert.False(t, f.Sortable())
+		case "plain":
+			assert.True(t, ok)
+			assert.False(t, jf.JSONB())
+		}
+	}
+}
+
+type invalidJSONBTest struct {
+	Id   int64
+	Name string `jargo:",type:jsonb"`
+}
+
+func TestJSONBType(t *testing.T) {
+	_, err := make(SchemaRegistry).RegisterSchema(reflect.TypeOf(invalidJSONBTest{}))
+	assert.Equal(t, errJSONBType, err)
+}
+
+func TestSplitJSONPath(t *testing.T) {
+	field, path, ok := SplitJSONPath("settings")
+	assert.True(t, ok)
+	assert.Equal(t, "settings", field)
+	assert.Nil(t, path)
+
+	field, path, ok = SplitJSONPath("settings.theme.color")
+	assert.True(t, ok)
+	assert.Equal(t, "settings", field)
+	assert.Equal(t, []string{"theme", "color"}, path)
+
+	_, _, ok = SplitJSONPath("settings..theme")
+	assert.False(t, ok)
+	_, _, ok = SplitJSONPath("settings.")
+	assert.False(t, ok)
+}
@@ -8,6 +8,8 @@ import (
 	"gopkg.in/go-playground/validator.v9"
 	"io"
 	"reflect"
+	"sort"
+	"strings"
 )
 
 // resource model -> resource Schema
@@ -26,15 +28,36 @@ var (
 
 const realtimeTriggerQuery = `
 DROP TRIGGER IF EXISTS jargo_%s_notify ON "%s";
-CREATE TRIGGER jargo_%s_notify AFTER INSERT OR UPDATE OR DELETE ON "%s" FOR EACH ROW EXECUTE PROCEDURE %s();
+CREATE TRIGGER jargo_%s_notify AFTER INSERT OR UPDATE OR DELETE ON "%s" FOR EACH ROW EXECUTE PROCEDURE %s(%s);
 `
 
+// RealtimeTriggerFunctionName is the name of the trigger function
+// called by the triggers created by CreateRealtimeTriggers.
+const RealtimeTriggerFunctionName = "jargo_realtime_notify"
+
 type Schema struct {
 	name  string // jsonapi member name
 	table string // sql table name
 	alias string // sql table alias
 
+	// realtime indicates whether realtime
+	// is enabled for the schema by default
+	realtime bool
+
 	fields []SchemaField
+	// indexes declared by the fields
+	indexes []*Index
+	// check constraints declared by the fields
+	checks []*Constraint
+	// foreign keys declared by the fields
+	foreignKeys []*Constraint
+	// foreign keys of the Schema's belongsTo relations
+	// and of belongsTo relations referencing the Schema,
+	// by constraint name
+	references map[string]*Reference
+	// fields covered by unique and check constraints,
+	// by constraint name
+	constraints map[string][]SchemaField
 
 	resourceModelType reflect.Type
 	jsonapiModelType  reflect.Type
@@ -52,6 +75,11 @@ func (s *Schema) JSONAPIName() string {
 	return s.name
 }
 
+// Realtime returns whether the Schema has the realtime option set.
+func (s *Schema) Realtime() bool {
+	return s.realtime
+}
+
 // Table returns the Schema's table name in the database.
 func (s *Schema) Table() string {
 	return s.table
@@ -77,6 +105,27 @@ func (s *Schema) IdField() SchemaField {
 	panic("could not find id field")
 }
 
+// NormalizeId returns the string representation of an id
+// of the Schema's id field type, as used in JSON API documents.
+// Returns false if id is not a valid id.
+func (s *Schema) NormalizeId(id string) (string, bool) {
+	return NormalizeId(id, s.IdField().typ())
+}
+
+// FieldByColumn returns the Schema's field with the given database column.
+// Returns nil if there is no such field.
+func (s *Schema) FieldByColumn(column string) SchemaField {
+	if column == "" {
+		return nil
+	}
+	for _, f := range s.fields {
+		if f.ColumnName() == column {
+			return f
+		}
+	}
+	return nil
+}
+
 // ExpireField returns the Schema's expire field.
 // Returns nil if the Schema has no expire field.
 func (s *Schema) ExpireField() SchemaField {
@@ -90,13 +139,27 @@ func (s *Schema) ExpireField() SchemaField {
 
 // CreateTable creates the database table
 // for this Schema if it doesn't exist yet.
-// It also implements primitive migration efforts,
-// creating columns that don't yet exist.
-func (s *Schema) CreateTable(db *pg.DB) error {
-	// call beforeCreateTable hooks on fields
+// If the table already exists, it is migrated in place,
+// adding, dropping and altering columns as needed.
+// Indexes and check constraints declared by the fields are created
+// if they don't exist yet, and managed ones that are no longer declared are dropped.
+// If options is nil, destructive changes are not allowed.
+func (s *Schema) CreateTable(db *pg.DB, options *MigrationOptions) error {
+	if options == nil {
+		options = &MigrationOptions{}
+	}
+
+	if options.Skip {
+		return nil
+	}
+	if options.Verify {
+		return s.verifyMigration(db)
+	}
+
+	// create extensions required by fields
 	for _, f := range s.Fields() {
-		if hook, ok := f.(beforeCreateTableHook); ok {
-			if err := hook.beforeCreateTable(db); err != nil {
+		if ef, ok := f.(extensionField); ok {
+			if _, err := db.Exec(fmt.Sprintf(createExtensionQuery, ef.extension())); err != nil {
 				return err
 			}
 		}
@@ -106,7 +169,7 @@ func (s *Schema) CreateTable(db *pg.DB) error {
 	if err := db.CreateTable(s.NewPGModelInstance(), nil); err != nil {
 		if pgErr, ok := err.(pg.Error); ok && pgErr.Field('C') == "42P07" {
 			// the table already exists - perform migration
-			if err := s.performMigration(db); err != nil {
+			if err := s.performMigration(db, options); err != nil {
 				return err
 			}
 		} else {
@@ -114,10 +177,18 @@ func (s *Schema) CreateTable(db *pg.DB) error {
 		}
 	}
 
-	// call afterCreateTable hooks on fields
+	// create and drop indexes and check constraints declared by fields
+	if err := s.migrateIndexes(db); err != nil {
+		return err
+	}
+	if err := s.migrateConstraints(db, checkConstraintType, checkPrefix, s.checks); err != nil {
+		return err
+	}
+
+	// create triggers required by fields
 	for _, f := range s.Fields() {
-		if hook, ok := f.(afterCreateTableHook); ok {
-			if err := hook.afterCreateTable(db); err != nil {
+		if tf, ok := f.(triggerField); ok {
+			if _, err := db.Exec(tf.triggerQuery()); err != nil {
 				return err
 			}
 		}
@@ -126,13 +197,70 @@ func (s *Schema) CreateTable(db *pg.DB) error {
 	return nil
 }
 
+// CreateRealtimeTriggers creates the trigger calling the realtime
+// trigger function with the given name, passing the names of
+// all belongsTo relation id columns as arguments.
 func (s *Schema) CreateRealtimeTriggers(db *pg.DB, functionName string) error {
-	_, err := db.Exec(fmt.Sprintf(realtimeTriggerQuery,
-		s.table, s.table, s.table, s.table, functionName,
-	))
+	_, err := db.Exec(s.realtimeTriggerQuery(functionName))
+	return err
+}
+
+// DropRealtimeTriggers drops the trigger
+// created by CreateRealtimeTriggers, if it exists.
+func (s *Schema) DropRealtimeTriggers(db *pg.DB) error {
+	_, err := db.Exec(fmt.Sprintf(dropTriggerQuery, s.realtimeTriggerName(), s.table))
 	return err
 }
 
+// realtimeTriggerQuery returns the statement (re-)creating
+// the trigger created by CreateRealtimeTriggers.
+func (s *Schema) realtimeTriggerQuery(functionName string) string {
+	var args []string
+	for column := range s.BelongsToColumns() {
+		args = append(args, fmt.Sprintf("'%s'", column))
+	}
+	// sort arguments so the trigger definition is deterministic
+	sort.Strings(args)
+
+	return fmt.Sprintf(realtimeTriggerQuery,
+		s.table, s.table, s.table, s.table, functionName, strings.Join(args, ", "),
+	)
+}
+
+// realtimeTriggerName returns the name of
+// the trigger created by CreateRealtimeTriggers.
+func (s *Schema) realtimeTriggerName() string {
+	return fmt.Sprintf("jargo_%s_notify", s.table)
+}
+
+// RealtimeTriggerRequired returns whether the trigger created by
+// CreateRealtimeTriggers is required by the realtime options,
+// i.e. whether the Schema or a Schema it belongs to
+// has the realtime option set.
+func (s *Schema) RealtimeTriggerRequired() bool {
+	if s.realtime {
+		return true
+	}
+	for _, schema := range s.BelongsToColumns() {
+		if schema != nil && schema.realtime {
+			return true
+		}
+	}
+	return false
+}
+
+// BelongsToColumns returns the relation id columns of all of the Schema's
+// belongsTo relations, mapped to the Schema of the related resource.
+func (s *Schema) BelongsToColumns() map[string]*Schema {
+	m := make(map[string]*Schema)
+	for _, f := range s.fields {
+		if b, ok := f.(*belongsToField); ok {
+			m[b.ColumnName()] = b.registry[b.relationType]
+		}
+	}
+	return m
+}
+
 func (s *Schema) IsResourceModelCollection(data interface{}) bool {
 	typ := reflect.ValueOf(data).Type()
 	collection := false
@@ -1,7 +1,6 @@
 package internal
 
 import (
-	"github.com/go-pg/pg"
 	"gopkg.in/go-playground/validator.v9"
 	"reflect"
 )
@@ -75,14 +74,44 @@ type schemaFieldInstance interface {
 	validate(*validator.Validate) error
 }
 
-type beforeCreateTableHook interface {
-	// called on resource fields implementing beforeCreateTableHook
-	// by Resource.CreateTable() before table is created
-	beforeCreateTable(db *pg.DB) error
+type extensionField interface {
+	// extension returns the name of the database extension
+	// required by the field. it is created by
+	// Schema.CreateTable() before the table is created.
+	extension() string
 }
 
-type afterCreateTableHook interface {
-	// called on resource fields implementing afterCreateTableHook
-	// by Resource.CreateTable() after table was created
-	afterCreateTable(db *pg.DB) error
+type triggerField interface {
+	// triggerName returns the name of the trigger
+	// the field requires on the Schema's table.
+	triggerName() string
+	// triggerFunctionName returns the name of
+	// the trigger function executed by the trigger.
+	triggerFunctionName() string
+	// triggerFunctionQuery returns the query creating
+	// the trigger function, replacing an existing one.
+	triggerFunctionQuery() string
+	// triggerQuery returns the query creating the trigger
+	// and its trigger function, replacing existing ones.
+	// it is executed by Schema.CreateTable() after the table was created.
+	triggerQuery() string
+}
+
+// SourcePointer returns the JSON Pointer to a field's member
+// in a JSON API resource document, e.g. "/data/attributes/name".
+// Returns an empty string for fields not exported to JSON API.
+func SourcePointer(f SchemaField) string {
+	switch {
+	case f.JSONAPIName() == unexportedFieldName:
+		return ""
+	case f.JSONAPIName() == IdFieldJsonapiName:
+		return "/data/id"
+	}
+
+	switch f.(type) {
+	case *belongsToField, *hasField:
+		return "/data/relationships/" + f.JSONAPIName()
+	default:
+		return "/data/attributes/" + f.JSONAPIName()
+	}
 }
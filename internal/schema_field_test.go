@@ -0,0 +1,34 @@
+package internal
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type sourcePointerParent struct {
+	Id       int64
+	Children []sourcePointerChild `jargo:",has:Parent"`
+}
+
+type sourcePointerChild struct {
+	Id     int64
+	Name   string
+	Secret string               `jargo:"-"`
+	Parent *sourcePointerParent `jargo:",belongsTo"`
+}
+
+func TestSourcePointer(t *testing.T) {
+	schemas := registerSchemas(t, sourcePointerChild{}, sourcePointerParent{})
+	child, parent := schemas[0], schemas[1]
+
+	assert.Equal(t, "/data/id", SourcePointer(child.IdField()))
+	assert.Equal(t, "/data/attributes/name", SourcePointer(child.FieldByColumn("name")))
+	assert.Equal(t, "", SourcePointer(child.FieldByColumn("secret")))
+	assert.Equal(t, "/data/relationships/parent", SourcePointer(child.FieldByColumn("parent_id")))
+
+	for _, f := range parent.Fields() {
+		if f.JSONAPIName() == "children" {
+			assert.Equal(t, "/data/relationships/children", SourcePointer(f))
+		}
+	}
+}
@@ -20,6 +20,11 @@ func (i *SchemaInstance) SortValue(field SchemaField) interface{} {
 	panic("unknown schema field")
 }
 
+// Id returns the value of the schema instance's id field.
+func (i *SchemaInstance) Id() interface{} {
+	return i.SortValue(i.schema.IdField())
+}
+
 // ToResourceModel creates a new Resource Model Instance
 // from the fields of the schema instance.
 func (i *SchemaInstance) ToResourceModel() interface{} {
@@ -5,24 +5,396 @@ import (
 	"github.com/go-pg/pg"
 	"github.com/go-pg/pg/orm"
 	"reflect"
+	"strings"
 )
 
 const migrationTableSuffix = "__migration"
 
-type pgColumnInfo struct {
-	TableName struct{} `sql:"information_schema.columns"`
+// MigrationOptions configures how Schema.CreateTable
+// migrates tables that already exist.
+type MigrationOptions struct {
+	// AllowDestructive allows changes that may
+	// discard data, such as dropping columns
+	// or changing their types.
+	AllowDestructive bool
+	// Verify causes CreateTable to return an error
+	// if the table requires any changes
+	// instead of performing them.
+	Verify bool
+	// Skip causes CreateTable to neither
+	// perform nor verify any changes.
+	Skip bool
+}
+
+// ChangeType is the type of a Change.
+type ChangeType string
+
+const (
+	CreateExtension   ChangeType = "create extension"
+	CreateTable       ChangeType = "create table"
+	AddColumn         ChangeType = "add column"
+	DropColumn        ChangeType = "drop column"
+	RenameColumn      ChangeType = "rename column"
+	AlterColumnType   ChangeType = "alter column type"
+	SetColumnDefault  ChangeType = "set column default"
+	DropColumnDefault ChangeType = "drop column default"
+	SetColumnNotNull  ChangeType = "set column not null"
+	DropColumnNotNull ChangeType = "drop column not null"
+	CreateTrigger     ChangeType = "create trigger"
+	ReplaceTrigger    ChangeType = "replace trigger"
+	CreateIndex       ChangeType = "create index"
+	DropIndex         ChangeType = "drop index"
+	AddCheck          ChangeType = "add check constraint"
+	DropCheck         ChangeType = "drop check constraint"
+	AddForeignKey     ChangeType = "add foreign key"
+	DropForeignKey    ChangeType = "drop foreign key"
+	AddConstraint     ChangeType = "add constraint"
+	DropConstraint    ChangeType = "drop constraint"
+
+	// DropRealtimeTrigger changes are only planned, but never
+	// performed automatically, as the trigger may be required
+	// by realtime instances enabling the resource at runtime.
+	DropRealtimeTrigger ChangeType = "drop realtime trigger"
+)
+
+// A Change is a single change to a table
+// required to migrate it to a Schema.
+type Change struct {
+	Type  ChangeType
+	Table string
+	// Column is the name of the column affected by the change,
+	// or an empty string if the change doesn't affect a single column.
+	Column string
+	// Name is the name of the extension, trigger, index
+	// or constraint created or dropped by the change.
+	Name string
+	// SQL is the statement performing the change.
+	SQL string
+	// DownSQL is the statement reverting the change,
+	// or an empty string if it is not reverted.
+	DownSQL string
+	// Destructive indicates whether the change may discard data,
+	// fail for tables containing records
+	// or fail for tables referenced by other tables.
+	Destructive bool
+}
+
+// Column contains the definition of a table column.
+type Column struct {
+	Name string `json:"name"`
+	// Type is the formatted column type,
+	// e.g. "character varying(64)".
+	Type    string `json:"type"`
+	NotNull bool   `json:"notNull"`
+	// Default is the default expression,
+	// or an empty string if the column has no default.
+	Default string `json:"default"`
+}
+
+// TableState describes the state of a Schema's table
+// and the database objects it requires.
+type TableState struct {
+	Table string `json:"table"`
+	// Columns contains the table's columns in column order.
+	// Empty if the table doesn't exist.
+	Columns []*Column `json:"columns"`
+	// Constraints contains the definitions of the table's constraints
+	// that are not managed by jargo, e.g. the primary key.
+	Constraints []string `json:"constraints"`
+	// Triggers contains the names of the triggers
+	// required by the Schema that exist on the table.
+	Triggers []string `json:"triggers"`
+	// TriggerFunctions contains the definitions of the functions
+	// executed by the triggers, by trigger name.
+	TriggerFunctions map[string]string `json:"triggerFunctions"`
+	// Extensions contains the names of the extensions
+	// required by the Schema that exist in the database.
+	Extensions []string `json:"extensions"`
+	// Indexes contains the indexes managed by jargo on the table.
+	Indexes []*Index `json:"indexes"`
+	// Checks contains the check constraints managed by jargo on the table.
+	Checks []*Constraint `json:"checks"`
+	// ForeignKeys contains the foreign keys managed by jargo on the table.
+	ForeignKeys []*Constraint `json:"foreignKeys"`
+	// RealtimeTrigger indicates whether the realtime trigger exists on the table,
+	// or in a desired state, whether it is required by the realtime options.
+	RealtimeTrigger bool `json:"realtimeTrigger"`
+}
+
+const createExtensionQuery = `CREATE EXTENSION IF NOT EXISTS "%s"`
+
+const dropTriggerQuery = `DROP TRIGGER IF EXISTS "%s" ON "%s"`
+
+// extensionExistsQuery selects whether
+// the extension with the given name exists.
+const extensionExistsQuery = `SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = ?)`
+
+// triggerExistsQuery selects whether the trigger with the given name
+// exists on the table with the given quoted name.
+const triggerExistsQuery = `SELECT EXISTS (SELECT 1 FROM pg_trigger WHERE tgrelid = to_regclass(?) AND tgname = ?)`
+
+// triggerFunctionDefQuery selects the definition of the function executed
+// by the trigger with the given name on the table with the given quoted name.
+// Returns no rows if the trigger doesn't exist.
+const triggerFunctionDefQuery = `SELECT pg_get_functiondef(tgfoid) FROM pg_trigger WHERE tgrelid = to_regclass(?) AND tgname = ?`
+
+// functionDefQuery selects the definition
+// of the function with the given name.
+const functionDefQuery = `SELECT pg_get_functiondef(to_regproc(?))`
+
+// dropTriggerFunctionQuery drops the trigger function with the given name
+// unless it is executed by other triggers, as the functions of
+// some triggers are shared between tables.
+const dropTriggerFunctionQuery = `
+DO $$ BEGIN
+  IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgfoid = to_regproc('%s')) THEN
+    DROP FUNCTION IF EXISTS %s();
+  END IF;
+END $$
+`
+
+// tableConstraintsQuery selects the definitions of all constraints
+// of a table, identified by its quoted name, except for
+// the constraints whose names have one of the given prefixes.
+const tableConstraintsQuery = `
+SELECT pg_get_constraintdef(c.oid) AS "definition"
+FROM pg_constraint c
+WHERE c.conrelid = to_regclass(?) AND c.conname NOT LIKE ? AND c.conname NOT LIKE ?
+ORDER BY c.contype DESC, c.conname
+`
+
+// addTableConstraintQuery adds a constraint to a table,
+// letting postgres generate its name like it does
+// for the constraints in CREATE TABLE statements.
+const addTableConstraintQuery = `ALTER TABLE "%s" ADD %s`
+
+// dropTableConstraintQuery drops the constraint of the table
+// with the given name whose definition matches the given definition,
+// if there is one. Constraints are identified by their definition,
+// as their names are generated by postgres and not part of a TableState.
+const dropTableConstraintQuery = `
+DO $$ DECLARE constraint_name name; BEGIN
+  SELECT conname INTO constraint_name FROM pg_constraint
+  WHERE conrelid = to_regclass('"%s"') AND pg_get_constraintdef(oid) = '%s';
+  IF constraint_name IS NOT NULL THEN
+    EXECUTE format('ALTER TABLE "%s" DROP CONSTRAINT %%I', constraint_name);
+  END IF;
+END $$
+`
+
+// tableColumnsQuery selects the definitions of all columns
+// of a table, identified by its quoted name, in column order.
+// Returns no rows if the table does not exist.
+const tableColumnsQuery = `
+SELECT a.attname AS "name",
+  format_type(a.atttypid, a.atttypmod) AS "type",
+  a.attnotnull AS "not_null",
+  COALESCE(pg_get_expr(d.adbin, d.adrelid), '') AS "default"
+FROM pg_attribute a
+LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+WHERE a.attrelid = to_regclass(?) AND a.attnum > 0 AND NOT a.attisdropped
+ORDER BY a.attnum
+`
+
+// tableColumns returns the definitions of all columns of a table.
+func tableColumns(db orm.DB, table string) ([]*Column, error) {
+	var columns []*Column
+	if _, err := db.Query(&columns, tableColumnsQuery, fmt.Sprintf(`"%s"`, table)); err != nil {
+		return nil, err
+	}
+	return columns, nil
+}
+
+// MigrateArchiveTable creates the archive table for a table if it
+// doesn't exist yet and adds all columns of the table it is missing,
+// returning the names of the table's columns in column order.
+//
+// Columns of the archive table that no longer exist on the table
+// are kept, but made nullable so records can still be archived.
+// Returns an error if a column's type differs between the tables.
+func MigrateArchiveTable(db orm.DB, table string, archive string) ([]string, error) {
+	columns, err := tableColumns(db, table)
+	if err != nil {
+		return nil, err
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf(`table "%s" does not exist`, table)
+	}
+
+	if _, err := db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS "%s" (LIKE "%s")`, archive, table)); err != nil {
+		return nil, err
+	}
+	archived, err := tableColumns(db, archive)
+	if err != nil {
+		return nil, err
+	}
+	archivedByName := make(map[string]*Column)
+	for _, c := range archived {
+		archivedByName[c.Name] = c
+	}
+
+	var names []string
+	for _, c := range columns {
+		names = append(names, c.Name)
+		if a, ok := archivedByName[c.Name]; ok {
+			if a.Type != c.Type {
+				return nil, fmt.Errorf(`column "%s" of archive table "%s" has type %s, expected %s`,
+					c.Name, archive, a.Type, c.Type)
+			}
+			delete(archivedByName, c.Name)
+			continue
+		}
+		// added columns are nullable, as existing
+		// archived records have no value for them
+		if _, err := db.Exec(fmt.Sprintf(`ALTER TABLE "%s" ADD COLUMN "%s" %s`,
+			archive, c.Name, c.Type)); err != nil {
+			return nil, err
+		}
+	}
+
+	// archive table columns that were removed from the table
+	for _, a := range archived {
+		if _, ok := archivedByName[a.Name]; ok && a.NotNull {
+			if _, err := db.Exec(fmt.Sprintf(`ALTER TABLE "%s" ALTER COLUMN "%s" DROP NOT NULL`,
+				archive, a.Name)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return names, nil
+}
+
+// tableConstraints returns the definitions of all constraints
+// of a table that are not managed by jargo.
+func tableConstraints(db orm.DB, table string) ([]string, error) {
+	var constraints []string
+	if _, err := db.Query(&constraints, tableConstraintsQuery, fmt.Sprintf(`"%s"`, table),
+		prefixPattern(checkPrefix), prefixPattern(foreignKeyPrefix)); err != nil {
+		return nil, err
+	}
+	return constraints, nil
+}
+
+// queryExists executes a query selecting a single boolean.
+func queryExists(db orm.DB, query string, params ...interface{}) (bool, error) {
+	var exists bool
+	if _, err := db.QueryOne(pg.Scan(&exists), query, params...); err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+// CurrentState returns the current state of the Schema's table.
+func (s *Schema) CurrentState(db orm.DB) (*TableState, error) {
+	state := &TableState{Table: s.table}
 
-	ColumnName    string
-	IsNullable    bool
-	DataType      string
-	ColumnDefault string
+	var err error
+	if state.Columns, err = tableColumns(db, s.table); err != nil {
+		return nil, err
+	}
+	if state.Constraints, err = tableConstraints(db, s.table); err != nil {
+		return nil, err
+	}
+	if state.Indexes, err = tableIndexes(db, s.table); err != nil {
+		return nil, err
+	}
+	if state.Checks, err = managedConstraints(db, s.table, checkConstraintType, checkPrefix); err != nil {
+		return nil, err
+	}
+	if state.ForeignKeys, err = managedConstraints(db, s.table, foreignKeyConstraintType, foreignKeyPrefix); err != nil {
+		return nil, err
+	}
+
+	if state.RealtimeTrigger, err = queryExists(db, triggerExistsQuery,
+		fmt.Sprintf(`"%s"`, s.table), s.realtimeTriggerName()); err != nil {
+		return nil, err
+	}
+
+	for _, f := range s.fields {
+		if ef, ok := f.(extensionField); ok {
+			exists, err := queryExists(db, extensionExistsQuery, ef.extension())
+			if err != nil {
+				return nil, err
+			}
+			if exists {
+				state.Extensions = append(state.Extensions, ef.extension())
+			}
+		}
+		if tf, ok := f.(triggerField); ok {
+			var def string
+			_, err := db.QueryOne(pg.Scan(&def), triggerFunctionDefQuery, fmt.Sprintf(`"%s"`, s.table), tf.triggerName())
+			if err == pg.ErrNoRows {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			state.Triggers = append(state.Triggers, tf.triggerName())
+			if state.TriggerFunctions == nil {
+				state.TriggerFunctions = make(map[string]string)
+			}
+			state.TriggerFunctions[tf.triggerName()] = def
+		}
+	}
+
+	return state, nil
+}
+
+// DesiredState returns the state the Schema's table
+// is supposed to have, without changing the database.
+func (s *Schema) DesiredState(db *pg.DB) (*TableState, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// changes made while determining the state are rolled back
+	defer tx.Rollback()
+
+	return s.desiredState(tx)
 }
 
-func (s *Schema) performMigration(db *pg.DB) error {
-	// to be able to check if the table columns of the model
-	// are different than the existing table's columns,
-	// we need to create a temporary table for the model
-	// and compare its columns with the existing table's columns.
+// desiredState returns the state the Schema's table is supposed to have.
+//
+// To let go-pg determine the column definitions,
+// a temporary table is created for the Schema's pg model
+// and dropped again after reading its columns.
+// Extensions that don't exist yet are created using tx,
+// as they may be required to create the temporary table.
+func (s *Schema) desiredState(tx *pg.Tx) (*TableState, error) {
+	state := &TableState{
+		Table:       s.table,
+		Indexes:     s.indexes,
+		Checks:      s.checks,
+		ForeignKeys: s.foreignKeys,
+
+		RealtimeTrigger: s.RealtimeTriggerRequired(),
+	}
+
+	for _, f := range s.fields {
+		if ef, ok := f.(extensionField); ok {
+			if _, err := tx.Exec(fmt.Sprintf(createExtensionQuery, ef.extension())); err != nil {
+				return nil, err
+			}
+			state.Extensions = append(state.Extensions, ef.extension())
+		}
+		if tf, ok := f.(triggerField); ok {
+			// the trigger function is replaced using tx
+			// to let postgres determine its definition
+			if _, err := tx.Exec(tf.triggerFunctionQuery()); err != nil {
+				return nil, err
+			}
+			var def string
+			if _, err := tx.QueryOne(pg.Scan(&def), functionDefQuery, tf.triggerFunctionName()); err != nil {
+				return nil, err
+			}
+			state.Triggers = append(state.Triggers, tf.triggerName())
+			if state.TriggerFunctions == nil {
+				state.TriggerFunctions = make(map[string]string)
+			}
+			state.TriggerFunctions[tf.triggerName()] = def
+		}
+	}
 
 	// to create a table for the model under a different name,
 	// we create a version of the pg model type struct
@@ -41,68 +413,503 @@ func (s *Schema) performMigration(db *pg.DB) error {
 	}
 	tmpPgModelType := reflect.StructOf(tmpFields)
 
-	// create the temporary table. this can't be done in a transaction,
-	// as the information_schema is not updated until committing.
-	if err := db.CreateTable(reflect.New(tmpPgModelType).Interface(),
+	if err := tx.CreateTable(reflect.New(tmpPgModelType).Interface(),
 		&orm.CreateTableOptions{Temp: true}); err != nil {
-		return err
+		return nil, err
 	}
 
-	// get column information for the new table
-	var newColumns []pgColumnInfo
-	if err := db.Model(&newColumns).
-		Where("table_name = ?", tmpTableName).
-		Select(); err != nil {
-		return err
+	var err error
+	if state.Columns, err = tableColumns(tx, tmpTableName); err != nil {
+		return nil, err
+	}
+	if state.Constraints, err = tableConstraints(tx, tmpTableName); err != nil {
+		return nil, err
 	}
 
-	// get column information for the existing table
-	var oldColumns []pgColumnInfo
-	if err := db.Model(&oldColumns).
-		Where("table_name = ?", s.table).
-		Select(); err != nil {
-		return err
+	if _, err := tx.Exec(fmt.Sprintf(`DROP TABLE "%s"`, tmpTableName)); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// diff returns the changes required to migrate
+// the Schema's table from the current to the desired state.
+// If current is nil, the table is assumed not to exist.
+func (s *Schema) diff(current *TableState, desired *TableState) []*Change {
+	if current == nil {
+		current = &TableState{Table: s.table}
+	}
+
+	var changes []*Change
+
+	// extensions
+	for _, extension := range desired.Extensions {
+		if !containsString(current.Extensions, extension) {
+			changes = append(changes, &Change{
+				Type:  CreateExtension,
+				Table: s.table,
+				Name:  extension,
+				SQL:   fmt.Sprintf(createExtensionQuery, extension),
+				// extensions may be used by other
+				// tables and are therefore not dropped
+			})
+		}
+	}
+
+	// table columns
+	if len(current.Columns) == 0 {
+		changes = append(changes, &Change{
+			Type:    CreateTable,
+			Table:   s.table,
+			SQL:     createTableQuery(s.table, desired.Columns, desired.Constraints),
+			DownSQL: fmt.Sprintf(`DROP TABLE "%s"`, s.table),
+		})
+	} else {
+		dropped, added := diffConstraints(s.table, current.Constraints, desired.Constraints)
+		changes = append(changes, dropped...)
+		changes = append(changes, s.columnChanges(current.Columns, desired.Columns)...)
+		changes = append(changes, added...)
+	}
+
+	// indexes
+	changes = append(changes, s.indexChanges(current.Indexes, desired.Indexes)...)
+
+	// check constraints
+	changes = append(changes, s.constraintChanges(current.Checks, desired.Checks, AddCheck, DropCheck)...)
+
+	// foreign keys
+	changes = append(changes, s.constraintChanges(current.ForeignKeys, desired.ForeignKeys,
+		AddForeignKey, DropForeignKey)...)
+
+	// triggers
+	for _, f := range s.fields {
+		tf, ok := f.(triggerField)
+		if !ok || !containsString(desired.Triggers, tf.triggerName()) {
+			continue
+		}
+		if !containsString(current.Triggers, tf.triggerName()) {
+			changes = append(changes, &Change{
+				Type:  CreateTrigger,
+				Table: s.table,
+				Name:  tf.triggerName(),
+				SQL:   tf.triggerQuery(),
+				DownSQL: fmt.Sprintf(dropTriggerQuery, tf.triggerName(), s.table) + ";" +
+					fmt.Sprintf(dropTriggerFunctionQuery, tf.triggerFunctionName(), tf.triggerFunctionName()),
+			})
+			continue
+		}
+		// the definitions of trigger functions are unknown
+		// for snapshots recorded before they were tracked
+		def, ok := current.TriggerFunctions[tf.triggerName()]
+		if ok && def != desired.TriggerFunctions[tf.triggerName()] {
+			changes = append(changes, &Change{
+				Type:  ReplaceTrigger,
+				Table: s.table,
+				Name:  tf.triggerName(),
+				SQL:   tf.triggerQuery(),
+				// restoring the previous definition only reverts the
+				// change if the trigger function wasn't renamed
+				DownSQL: def,
+			})
+		}
+	}
+
+	// realtime triggers are created by running realtime instances,
+	// which only drop them if no other instance of the process requires them,
+	// and not at all if they stop running. triggers that aren't required
+	// by the realtime options of the Schema or the Schemas it belongs to
+	// are therefore dropped by migrations.
+	if current.RealtimeTrigger && !desired.RealtimeTrigger {
+		changes = append(changes, &Change{
+			Type:    DropRealtimeTrigger,
+			Table:   s.table,
+			Name:    s.realtimeTriggerName(),
+			SQL:     fmt.Sprintf(dropTriggerQuery, s.realtimeTriggerName(), s.table),
+			DownSQL: s.realtimeTriggerQuery(RealtimeTriggerFunctionName),
+		})
+	}
+
+	return changes
+}
+
+// containsString returns whether a slice contains a string.
+func containsString(slice []string, s string) bool {
+	for _, v := range slice {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// diffConstraints returns the changes required to migrate a table
+// with the existing constraints to the desired constraints,
+// which are compared by their definitions.
+//
+// Constraints are dropped before columns are changed
+// and added afterwards, as they may cover new columns.
+func diffConstraints(table string, existing []string, desired []string) (dropped []*Change, added []*Change) {
+	for _, e := range existing {
+		if !containsString(desired, e) {
+			dropped = append(dropped, &Change{
+				Type:    DropConstraint,
+				Table:   table,
+				SQL:     dropTableConstraintSQL(table, e),
+				DownSQL: fmt.Sprintf(addTableConstraintQuery, table, e),
+				// dropping a primary key fails
+				// if it is referenced by other tables
+				Destructive: strings.HasPrefix(e, "PRIMARY KEY"),
+			})
+		}
+	}
+	for _, d := range desired {
+		if !containsString(existing, d) {
+			added = append(added, &Change{
+				Type:    AddConstraint,
+				Table:   table,
+				SQL:     fmt.Sprintf(addTableConstraintQuery, table, d),
+				DownSQL: dropTableConstraintSQL(table, d),
+			})
+		}
+	}
+	return dropped, added
+}
+
+// dropTableConstraintSQL returns the statement dropping
+// the constraint of a table with the given definition.
+func dropTableConstraintSQL(table string, definition string) string {
+	return fmt.Sprintf(dropTableConstraintQuery, table,
+		strings.Replace(definition, "'", "''", -1), table)
+}
+
+// createTableQuery returns the statement
+// creating a table with the given columns and constraints.
+func createTableQuery(table string, columns []*Column, constraints []string) string {
+	var definitions []string
+	for _, c := range columns {
+		if isSequenceDefault(c.Default) {
+			// use serial types for columns with sequence defaults
+			serial := &Column{
+				Name:    c.Name,
+				Type:    serialType(c.Type),
+				NotNull: c.NotNull,
+			}
+			definitions = append(definitions, columnDefinition(serial))
+		} else {
+			definitions = append(definitions, columnDefinition(c))
+		}
+	}
+	definitions = append(definitions, constraints...)
+	return fmt.Sprintf(`CREATE TABLE "%s" (%s)`, table, strings.Join(definitions, ", "))
+}
+
+// serialType returns the serial type
+// corresponding to an integer type.
+func serialType(typ string) string {
+	switch typ {
+	case "smallint":
+		return "smallserial"
+	case "integer":
+		return "serial"
+	default:
+		return "bigserial"
 	}
+}
+
+// PlanMigration returns the changes required to create
+// or migrate the Schema's table without performing them.
+func (s *Schema) PlanMigration(db *pg.DB) ([]*Change, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// changes made while planning are rolled back
+	defer tx.Rollback()
+
+	current, err := s.CurrentState(tx)
+	if err != nil {
+		return nil, err
+	}
+	desired, err := s.desiredState(tx)
+	if err != nil {
+		return nil, err
+	}
+	return s.diff(current, desired), nil
+}
 
-	// compare the new table's columns with the old table's columns
-	if reflect.DeepEqual(oldColumns, newColumns) {
-		// the old and the new table are equal.
-		// delete the temporary table.
-		_, err := db.Exec(fmt.Sprintf(`DROP TABLE "%s"`, tmpTableName))
+// PlanMigrationFrom returns the changes required to migrate
+// the Schema's table from a previously recorded state.
+// If snapshot is nil, the table is assumed not to exist.
+// db is only used to determine the desired state and is not changed.
+func (s *Schema) PlanMigrationFrom(db *pg.DB, snapshot *TableState) ([]*Change, error) {
+	desired, err := s.DesiredState(db)
+	if err != nil {
+		return nil, err
+	}
+	return s.diff(snapshot, desired), nil
+}
+
+// verifyMigration returns an error if
+// the Schema's table requires any changes.
+func (s *Schema) verifyMigration(db *pg.DB) error {
+	changes, err := s.PlanMigration(db)
+	if err != nil {
 		return err
 	}
+	var statements []string
+	for _, c := range changes {
+		// realtime triggers that are no longer required
+		// don't prevent the table from being used
+		if c.Type == DropRealtimeTrigger {
+			continue
+		}
+		statements = append(statements, c.SQL)
+	}
+	if len(statements) > 0 {
+		return fmt.Errorf(`table "%s" is not up to date. pending changes: %s`,
+			s.table, strings.Join(statements, "; "))
+	}
+	return nil
+}
+
+// renamedField is a SchemaField whose column may have been renamed.
+type renamedField interface {
+	ColumnName() string
+	// renamedFrom returns the previous name of the field's column,
+	// or an empty string if it wasn't renamed.
+	renamedFrom() string
+}
+
+// columnChanges returns the changes required to migrate
+// the Schema's table with the existing columns to the desired columns.
+//
+// Columns of fields with the "renamedFrom" option are renamed
+// if the previous column exists and the new column doesn't,
+// before the remaining columns are compared.
+func (s *Schema) columnChanges(existing []*Column, desired []*Column) []*Change {
+	existingByName := make(map[string]*Column)
+	for _, c := range existing {
+		existingByName[c.Name] = c
+	}
+
+	var renames []*Change
+	renamed := make(map[string]string)
+	for _, f := range s.fields {
+		rf, ok := f.(renamedField)
+		if !ok || rf.renamedFrom() == "" || rf.ColumnName() == "" {
+			continue
+		}
+		from, to := rf.renamedFrom(), rf.ColumnName()
+		if _, ok := existingByName[from]; !ok {
+			continue
+		}
+		if _, ok := existingByName[to]; ok {
+			continue
+		}
+
+		renamed[from] = to
+		renames = append(renames, &Change{
+			Type:    RenameColumn,
+			Table:   s.table,
+			Column:  to,
+			SQL:     fmt.Sprintf(`ALTER TABLE "%s" RENAME COLUMN "%s" TO "%s"`, s.table, from, to),
+			DownSQL: fmt.Sprintf(`ALTER TABLE "%s" RENAME COLUMN "%s" TO "%s"`, s.table, to, from),
+		})
+	}
 
-	// the new table has a different schema.
-	if err := db.RunInTransaction(func(tx *pg.Tx) error {
-		// try to copy all data from the old table's columns into the new table
-		var columnNames string
-		for i, column := range oldColumns {
-			if i > 0 {
-				columnNames += ", "
+	// compare the remaining columns as if
+	// the renames were already performed
+	var renamedExisting []*Column
+	for _, c := range existing {
+		if to, ok := renamed[c.Name]; ok {
+			r := *c
+			r.Name = to
+			c = &r
+		}
+		renamedExisting = append(renamedExisting, c)
+	}
+
+	return append(renames, diffColumns(s.table, renamedExisting, desired)...)
+}
+
+// diffColumns returns the changes required to migrate
+// a table with the existing columns to the desired columns.
+//
+// Columns are dropped before new columns are added,
+// and defaults are dropped before column types are changed,
+// to ensure the default expressions remain valid.
+func diffColumns(table string, existing []*Column, desired []*Column) []*Change {
+	existingByName := make(map[string]*Column)
+	for _, c := range existing {
+		existingByName[c.Name] = c
+	}
+	desiredByName := make(map[string]*Column)
+	for _, c := range desired {
+		desiredByName[c.Name] = c
+	}
+
+	var dropped, added, altered []*Change
+	alter := fmt.Sprintf(`ALTER TABLE "%s" `, table)
+	change := func(typ ChangeType, column string, destructive bool, up string, down string) *Change {
+		return &Change{
+			Type:        typ,
+			Table:       table,
+			Column:      column,
+			SQL:         alter + up,
+			DownSQL:     alter + down,
+			Destructive: destructive,
+		}
+	}
+	setDefault := func(c *Column) string {
+		return fmt.Sprintf(`ALTER COLUMN "%s" SET DEFAULT %s`, c.Name, c.Default)
+	}
+	dropDefault := func(c *Column) string {
+		return fmt.Sprintf(`ALTER COLUMN "%s" DROP DEFAULT`, c.Name)
+	}
+	setNotNull := func(c *Column) string {
+		return fmt.Sprintf(`ALTER COLUMN "%s" SET NOT NULL`, c.Name)
+	}
+	dropNotNull := func(c *Column) string {
+		return fmt.Sprintf(`ALTER COLUMN "%s" DROP NOT NULL`, c.Name)
+	}
+	alterType := func(c *Column) string {
+		return fmt.Sprintf(`ALTER COLUMN "%s" TYPE %s USING "%s"::%s`, c.Name, c.Type, c.Name, c.Type)
+	}
+	hasDefault := func(c *Column) bool {
+		return c.Default != "" && !isSequenceDefault(c.Default)
+	}
+
+	for _, e := range existing {
+		if _, ok := desiredByName[e.Name]; !ok {
+			dropped = append(dropped, change(DropColumn, e.Name, true,
+				fmt.Sprintf(`DROP COLUMN "%s"`, e.Name),
+				fmt.Sprintf(`ADD COLUMN %s`, columnDefinition(e))))
+		}
+	}
+
+	for _, d := range desired {
+		e, ok := existingByName[d.Name]
+		if !ok {
+			// adding a column that may not be null without
+			// a default value fails for tables containing records
+			added = append(added, change(AddColumn, d.Name, d.NotNull && !hasDefault(d),
+				fmt.Sprintf(`ADD COLUMN %s`, columnDefinition(d)),
+				fmt.Sprintf(`DROP COLUMN "%s"`, d.Name)))
+			continue
+		}
+
+		typeChanged := e.Type != d.Type
+		defaultChanged := !defaultsEqual(e.Default, d.Default)
+
+		if (typeChanged || defaultChanged) && hasDefault(e) {
+			altered = append(altered, change(DropColumnDefault, d.Name, false,
+				dropDefault(e), setDefault(e)))
+		}
+		if typeChanged {
+			altered = append(altered, change(AlterColumnType, d.Name, true,
+				alterType(d), alterType(e)))
+		}
+		if (typeChanged || defaultChanged) && hasDefault(d) {
+			altered = append(altered, change(SetColumnDefault, d.Name, false,
+				setDefault(d), dropDefault(d)))
+		}
+
+		if e.NotNull != d.NotNull {
+			if d.NotNull {
+				altered = append(altered, change(SetColumnNotNull, d.Name, false,
+					setNotNull(d), dropNotNull(d)))
+			} else {
+				altered = append(altered, change(DropColumnNotNull, d.Name, false,
+					dropNotNull(d), setNotNull(d)))
 			}
-			columnNames += fmt.Sprintf(`"%s"`, column.ColumnName)
 		}
+	}
 
-		if _, err := tx.Exec(fmt.Sprintf(`INSERT INTO "%s" (%s) SELECT * FROM "%s"`, tmpTableName, columnNames, s.table)); err != nil {
+	var changes []*Change
+	changes = append(changes, dropped...)
+	changes = append(changes, added...)
+	changes = append(changes, altered...)
+	return changes
+}
+
+// columnDefinition returns the definition
+// of a column for use in ADD COLUMN statements.
+func columnDefinition(c *Column) string {
+	def := fmt.Sprintf(`"%s" %s`, c.Name, c.Type)
+	if c.Default != "" && !isSequenceDefault(c.Default) {
+		def += " DEFAULT " + c.Default
+	}
+	if c.NotNull {
+		def += " NOT NULL"
+	}
+	return def
+}
+
+// isSequenceDefault returns whether a default expression
+// retrieves the next value of a sequence, as is the case
+// for serial columns. As the sequence names of the temporary
+// table differ from the existing table's, these defaults
+// are not considered when comparing columns.
+func isSequenceDefault(def string) bool {
+	return strings.HasPrefix(def, "nextval(")
+}
+
+// defaultsEqual returns whether two default expressions are equal.
+func defaultsEqual(a string, b string) bool {
+	if isSequenceDefault(a) && isSequenceDefault(b) {
+		return true
+	}
+	return a == b
+}
+
+// performMigration migrates the Schema's existing table
+// in place, executing all changes in a single transaction.
+//
+// Returns an error without performing any changes
+// if destructive changes are required but not allowed.
+func (s *Schema) performMigration(db *pg.DB, options *MigrationOptions) error {
+	err := db.RunInTransaction(func(tx *pg.Tx) error {
+		existing, err := tableColumns(tx, s.table)
+		if err != nil {
 			return err
 		}
-
-		if _, err := tx.Exec(fmt.Sprintf(`DROP TABLE "%s"`, s.table)); err != nil {
+		existingConstraints, err := tableConstraints(tx, s.table)
+		if err != nil {
 			return err
 		}
-
-		if _, err := tx.Exec(fmt.Sprintf(`ALTER TABLE "%s" RENAME TO "%s"`, tmpTableName, s.table)); err != nil {
+		desired, err := s.desiredState(tx)
+		if err != nil {
 			return err
 		}
 
-		return nil
-	}); err != nil {
-		// migration failed.
-		// drop the temporary table
-		db.Exec(fmt.Sprintf(`DROP TABLE "%s"`, tmpTableName))
+		dropped, added := diffConstraints(s.table, existingConstraints, desired.Constraints)
+		var changes []*Change
+		changes = append(changes, dropped...)
+		changes = append(changes, s.columnChanges(existing, desired.Columns)...)
+		changes = append(changes, added...)
 
-		return fmt.Errorf("table migration failed. you have to manually perform migration: %s", err.Error())
-	}
+		// refuse destructive changes unless explicitly allowed
+		if !options.AllowDestructive {
+			var destructive []string
+			for _, c := range changes {
+				if c.Destructive {
+					destructive = append(destructive, c.SQL)
+				}
+			}
+			if len(destructive) > 0 {
+				return fmt.Errorf("destructive changes are not allowed: %s", strings.Join(destructive, "; "))
+			}
+		}
 
+		for _, c := range changes {
+			if _, err := tx.Exec(c.SQL); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf(`migration of table "%s" failed. you have to manually perform migration: %s`, s.table, err.Error())
+	}
 	return nil
 }
@@ -0,0 +1,171 @@
+package internal
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestDiffColumns(t *testing.T) {
+	existing := []*Column{
+		{Name: "id", Type: "bigint", NotNull: true, Default: "nextval('test_id_seq'::regclass)"},
+		{Name: "name", Type: "text"},
+		{Name: "age", Type: "bigint"},
+	}
+
+	// equal columns result in no changes
+	changes := diffColumns("test", existing, []*Column{
+		{Name: "id", Type: "bigint", NotNull: true, Default: "nextval('test__migration_id_seq'::regclass)"},
+		{Name: "name", Type: "text"},
+		{Name: "age", Type: "bigint"},
+	})
+	assert.Empty(t, changes)
+
+	changes = diffColumns("test", existing, []*Column{
+		{Name: "id", Type: "bigint", NotNull: true, Default: "nextval('test__migration_id_seq'::regclass)"},
+		{Name: "name", Type: "text", NotNull: true, Default: "'unknown'::text"},
+		{Name: "valid", Type: "boolean", Default: "true"},
+	})
+	assert.Len(t, changes, 4)
+
+	assert.Equal(t, DropColumn, changes[0].Type)
+	assert.True(t, changes[0].Destructive)
+	assert.Equal(t, `ALTER TABLE "test" DROP COLUMN "age"`, changes[0].SQL)
+	assert.Equal(t, `ALTER TABLE "test" ADD COLUMN "age" bigint`, changes[0].DownSQL)
+
+	assert.Equal(t, AddColumn, changes[1].Type)
+	assert.False(t, changes[1].Destructive)
+	assert.Equal(t, `ALTER TABLE "test" ADD COLUMN "valid" boolean DEFAULT true`, changes[1].SQL)
+
+	assert.Equal(t, SetColumnDefault, changes[2].Type)
+	assert.Equal(t, `ALTER TABLE "test" ALTER COLUMN "name" SET DEFAULT 'unknown'::text`, changes[2].SQL)
+
+	assert.Equal(t, SetColumnNotNull, changes[3].Type)
+	assert.Equal(t, `ALTER TABLE "test" ALTER COLUMN "name" SET NOT NULL`, changes[3].SQL)
+
+	// type changes drop and restore the default value
+	changes = diffColumns("test", []*Column{
+		{Name: "count", Type: "integer", Default: "0"},
+	}, []*Column{
+		{Name: "count", Type: "bigint", Default: "0"},
+	})
+	assert.Len(t, changes, 3)
+	assert.Equal(t, DropColumnDefault, changes[0].Type)
+	assert.Equal(t, AlterColumnType, changes[1].Type)
+	assert.True(t, changes[1].Destructive)
+	assert.Equal(t, `ALTER TABLE "test" ALTER COLUMN "count" TYPE bigint USING "count"::bigint`, changes[1].SQL)
+	assert.Equal(t, `ALTER TABLE "test" ALTER COLUMN "count" TYPE integer USING "count"::integer`, changes[1].DownSQL)
+	assert.Equal(t, SetColumnDefault, changes[2].Type)
+
+	// adding a column that may not be null requires a default
+	changes = diffColumns("test", nil, []*Column{
+		{Name: "name", Type: "text", NotNull: true},
+		{Name: "valid", Type: "boolean", NotNull: true, Default: "true"},
+	})
+	assert.Len(t, changes, 2)
+	assert.True(t, changes[0].Destructive)
+	assert.Equal(t, `ALTER TABLE "test" ADD COLUMN "name" text NOT NULL`, changes[0].SQL)
+	assert.False(t, changes[1].Destructive)
+}
+
+func TestDiffConstraints(t *testing.T) {
+	existing := []string{"PRIMARY KEY (id)", "UNIQUE (name)"}
+
+	// equal constraints result in no changes
+	dropped, added := diffConstraints("test", existing, []string{"PRIMARY KEY (id)", "UNIQUE (name)"})
+	assert.Empty(t, dropped)
+	assert.Empty(t, added)
+
+	dropped, added = diffConstraints("test", existing, []string{"PRIMARY KEY (id)", "UNIQUE (email)"})
+	assert.Len(t, dropped, 1)
+	assert.Equal(t, DropConstraint, dropped[0].Type)
+	assert.False(t, dropped[0].Destructive)
+	assert.Contains(t, dropped[0].SQL, `pg_get_constraintdef(oid) = 'UNIQUE (name)'`)
+	assert.Equal(t, `ALTER TABLE "test" ADD UNIQUE (name)`, dropped[0].DownSQL)
+
+	assert.Len(t, added, 1)
+	assert.Equal(t, AddConstraint, added[0].Type)
+	assert.Equal(t, `ALTER TABLE "test" ADD UNIQUE (email)`, added[0].SQL)
+	assert.Contains(t, added[0].DownSQL, `pg_get_constraintdef(oid) = 'UNIQUE (email)'`)
+
+	// dropping the primary key is destructive
+	dropped, _ = diffConstraints("test", existing, []string{"UNIQUE (name)"})
+	assert.Len(t, dropped, 1)
+	assert.True(t, dropped[0].Destructive)
+}
+
+type renamedColumnTest struct {
+	Id       int64  `jargo:",table:renamed_column_tests"`
+	FullName string `jargo:",renamedFrom:name"`
+}
+
+func TestColumnChangesRename(t *testing.T) {
+	schema := registerSchema(t, renamedColumnTest{})
+
+	desired := []*Column{
+		{Name: "id", Type: "bigint", NotNull: true, Default: "nextval('a_id_seq'::regclass)"},
+		{Name: "full_name", Type: "text"},
+	}
+
+	// the previous column is renamed
+	changes := schema.columnChanges([]*Column{
+		{Name: "id", Type: "bigint", NotNull: true, Default: "nextval('b_id_seq'::regclass)"},
+		{Name: "name", Type: "text"},
+	}, desired)
+	assert.Len(t, changes, 1)
+	assert.Equal(t, RenameColumn, changes[0].Type)
+	assert.Equal(t, `ALTER TABLE "renamed_column_tests" RENAME COLUMN "name" TO "full_name"`, changes[0].SQL)
+	assert.Equal(t, `ALTER TABLE "renamed_column_tests" RENAME COLUMN "full_name" TO "name"`, changes[0].DownSQL)
+
+	// the rename is a no-op once applied
+	changes = schema.columnChanges([]*Column{
+		{Name: "id", Type: "bigint", NotNull: true, Default: "nextval('b_id_seq'::regclass)"},
+		{Name: "full_name", Type: "text"},
+	}, desired)
+	assert.Empty(t, changes)
+}
+
+type triggerDiffTest struct {
+	Id        int64      `jargo:",table:trigger_diff_tests"`
+	UpdatedAt *time.Time `jargo:",updatedAt"`
+}
+
+func TestDiffTriggers(t *testing.T) {
+	schema := registerSchema(t, triggerDiffTest{})
+
+	columns := []*Column{
+		{Name: "id", Type: "bigint", NotNull: true, Default: "nextval('trigger_diff_tests_id_seq'::regclass)"},
+		{Name: "updated_at", Type: "timestamp with time zone", NotNull: true},
+	}
+	desired := &TableState{
+		Table:            "trigger_diff_tests",
+		Columns:          columns,
+		Triggers:         []string{"jargo_updated_at_trigger_updated_at"},
+		TriggerFunctions: map[string]string{"jargo_updated_at_trigger_updated_at": "new"},
+	}
+
+	// missing triggers are created, dropping their function when reverted
+	changes := schema.diff(&TableState{Table: "trigger_diff_tests", Columns: columns}, desired)
+	assert.Len(t, changes, 1)
+	assert.Equal(t, CreateTrigger, changes[0].Type)
+	assert.Contains(t, changes[0].DownSQL, `DROP FUNCTION IF EXISTS jargo_updated_at_trigger_updated_at_func()`)
+
+	// triggers whose function changed are replaced
+	changes = schema.diff(&TableState{
+		Table:            "trigger_diff_tests",
+		Columns:          columns,
+		Triggers:         []string{"jargo_updated_at_trigger_updated_at"},
+		TriggerFunctions: map[string]string{"jargo_updated_at_trigger_updated_at": "old"},
+	}, desired)
+	assert.Len(t, changes, 1)
+	assert.Equal(t, ReplaceTrigger, changes[0].Type)
+	assert.Equal(t, "old", changes[0].DownSQL)
+
+	// trigger functions are not compared if their definition is unknown
+	changes = schema.diff(&TableState{
+		Table:    "trigger_diff_tests",
+		Columns:  columns,
+		Triggers: []string{"jargo_updated_at_trigger_updated_at"},
+	}, desired)
+	assert.Empty(t, changes)
+}
@@ -16,6 +16,8 @@ const (
 	optionAlias   = "alias"
 	optionColumn  = "column"
 
+	optionRealtime = "realtime"
+
 	optionHas       = "has"
 	optionBelongsTo = "belongsTo"
 	optionMany2Many = "many2many"
@@ -29,10 +31,21 @@ const (
 	optionDefault   = "default"
 	optionType      = "type"
 
+	optionRenamedFrom = "renamedFrom"
+
+	optionIndex      = "index"
+	optionIndexWhere = "indexWhere"
+
+	optionEnum  = "enum"
+	optionCheck = "check"
+
+	optionOnDelete = "onDelete"
+
 	optionCreatedAt = "createdAt"
 	optionUpdatedAt = "updatedAt"
 
 	optionExpire = "expire"
+	optionTTL    = "ttl"
 )
 
 type fieldType int
@@ -91,6 +104,12 @@ func (r SchemaRegistry) newSchemaDefinition(t reflect.Type) *Schema {
 		}
 	}
 
+	// parse and validate index declarations
+	// and constraints
+	schema.parseUniqueConstraints()
+	schema.indexes = schema.parseIndexes()
+	schema.checks = schema.parseChecks()
+
 	schema.joinJsonapiModelType = reflect.StructOf(jsonapiJoinFields)
 	schema.joinPGModelType = reflect.StructOf(pgJoinFields)
 	return schema
@@ -106,6 +125,9 @@ func (r SchemaRegistry) generateSchemaModels(schema *Schema) {
 
 	schema.jsonapiModelType = reflect.StructOf(jsonapiFields)
 	schema.pgModelType = reflect.StructOf(pgFields)
+
+	// parse foreign keys once the related schemas are registered
+	schema.foreignKeys = schema.parseForeignKeys()
 }
 
 // parses a struct's id field, retrieving
@@ -140,13 +162,16 @@ func parseSchema(t reflect.Type) *Schema {
 	}
 
 	// parse options defined in struct tag.
-	// they may be used to override sql table and alias.
+	// they may be used to override sql table and alias
+	// and to enable realtime.
 	for option, value := range parsed.Options {
 		switch option {
 		case optionTable:
 			schema.table = value
 		case optionAlias:
 			schema.alias = value
+		case optionRealtime:
+			schema.realtime = isSet(parsed.Options, optionRealtime)
 		default:
 			panic(errDisallowedOption(option))
 		}
@@ -158,12 +183,12 @@ func parseSchema(t reflect.Type) *Schema {
 	}
 
 	// validate table name
-	if !isValidSQLName(schema.table) {
+	if !IsValidSQLName(schema.table) {
 		panic(errInvalidTableName)
 	}
 
 	// validate alias
-	if !isValidSQLName(schema.alias) {
+	if !IsValidSQLName(schema.alias) {
 		panic(errInvalidTableAlias)
 	}
 
@@ -0,0 +1,35 @@
+package internal
+
+import (
+	"github.com/stretchr/testify/assert"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+// registerSchemas registers the Schemas of models with
+// a new SchemaRegistry, failing the test if any is invalid.
+func registerSchemas(t *testing.T, models ...interface{}) []*Schema {
+	registry := make(SchemaRegistry)
+	var schemas []*Schema
+	for _, model := range models {
+		schema, err := registry.RegisterSchema(reflect.TypeOf(model))
+		if !assert.Nil(t, err) {
+			t.FailNow()
+		}
+		schemas = append(schemas, schema)
+	}
+	return schemas
+}
+
+// registerSchema registers the Schema of a model with
+// a new SchemaRegistry, failing the test if it is invalid.
+func registerSchema(t *testing.T, model interface{}) *Schema {
+	return registerSchemas(t, model)[0]
+}
+
+// assertManagedName asserts that name is a name returned by managedName
+// for the given prefix, table and name, e.g. "jargo_idx_index_tests_name_1a2b3c4d".
+func assertManagedName(t *testing.T, prefix string, table string, name string, actual string) {
+	assert.Regexp(t, `^`+regexp.QuoteMeta(prefix+table+"_"+name)+`_[0-9a-f]{8}$`, actual)
+}
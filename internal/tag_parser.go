@@ -18,8 +18,15 @@ func parseJargoTagDefaultName(tag string, defaultName string) *JargoTag {
 	return parsed
 }
 
+// sqlOptions contains the options whose values are SQL expressions.
+var sqlOptions = map[string]bool{
+	optionDefault:    true,
+	optionCheck:      true,
+	optionIndexWhere: true,
+}
+
 func parseJargoTag(tag string) *JargoTag {
-	spl := strings.Split(tag, ",")
+	spl := splitJargoTag(tag)
 	parsed := &JargoTag{
 		Name:    spl[0],
 		Options: make(map[string]string),
@@ -43,3 +50,47 @@ func parseJargoTag(tag string) *JargoTag {
 
 	return parsed
 }
+
+// splitJargoTag splits a jargo tag into its name and options.
+//
+// Options are separated by commas. In the values of options
+// containing SQL expressions, commas enclosed in parentheses or
+// single-quoted strings don't separate options, allowing values like
+// "check:status IN ('draft', 'published')". If the parentheses or quotes
+// of such a value are unbalanced, the remainder of the tag is part of the value.
+func splitJargoTag(tag string) []string {
+	var parts []string
+	start := 0
+	// sql indicates whether the current part is an option
+	// whose value is an SQL expression
+	sql := false
+	depth := 0
+	quoted := false
+	for i := 0; i < len(tag); i++ {
+		c := tag[i]
+		if sql {
+			switch {
+			case c == '\'':
+				// escaped quotes ('') toggle twice
+				quoted = !quoted
+				continue
+			case quoted:
+				continue
+			case c == '(':
+				depth++
+				continue
+			case c == ')':
+				depth--
+				continue
+			}
+		}
+		if c == ',' && depth <= 0 {
+			parts = append(parts, tag[start:i])
+			start = i + 1
+			key := strings.SplitN(tag[start:], ":", 2)[0]
+			sql = sqlOptions[key]
+			depth = 0
+		}
+	}
+	return append(parts, tag[start:])
+}
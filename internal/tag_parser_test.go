@@ -31,3 +31,18 @@ func TestParseJargoTagDefaultName(t *testing.T) {
 func assertKeyValue(t *testing.T, options map[string]string, key string, value string) {
 	assert.Equalf(t, options[key], value, "Parsed option value for %s is incorrect", key)
 }
+
+func TestParseJargoTagSQLOptions(t *testing.T) {
+	p := parseJargoTag(`myName,check:status IN ('a', 'it''s, b'),indexWhere:coalesce(a, b) > 0,default:'x,y',nosort`)
+	assert.Equal(t, p.Name, "myName")
+	assert.Len(t, p.Options, 4)
+	assertKeyValue(t, p.Options, "check", `status IN ('a', 'it''s, b')`)
+	assertKeyValue(t, p.Options, "indexWhere", "coalesce(a, b) > 0")
+	assertKeyValue(t, p.Options, "default", "'x,y'")
+	assertKeyValue(t, p.Options, "nosort", "")
+
+	// commas in other options separate options
+	p = parseJargoTag(`myName,enum:it's|(a,nosort`)
+	assertKeyValue(t, p.Options, "enum", "it's|(a")
+	assertKeyValue(t, p.Options, "nosort", "")
+}
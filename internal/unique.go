@@ -0,0 +1,80 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	errInvalidUniqueGroup  = errors.New("unique group name may only consist of [0-9,a-z,A-Z$_]")
+	errUniqueWithoutColumn = errors.New(`"unique" option is only allowed on fields with a database column`)
+)
+
+// uniqueField is a SchemaField whose column may be unique by itself.
+type uniqueField interface {
+	ColumnName() string
+	// unique returns whether the field's column
+	// has a unique constraint of its own.
+	unique() bool
+}
+
+// registerConstraint registers the fields whose
+// columns are covered by the constraint with the given name.
+func (s *Schema) registerConstraint(name string, fields ...SchemaField) {
+	if s.constraints == nil {
+		s.constraints = make(map[string][]SchemaField)
+	}
+	s.constraints[name] = fields
+}
+
+// ConstraintFields returns the fields whose columns are covered
+// by the unique constraint, check constraint or primary key with the given name.
+// Returns nil if there is no such constraint.
+func (s *Schema) ConstraintFields(name string) []SchemaField {
+	return s.constraints[name]
+}
+
+// parseUniqueConstraints registers the primary key and
+// the unique constraints of fields with the "unique" option
+// under the names postgres generates for them.
+// Composite unique constraints are registered by parseIndexes.
+func (s *Schema) parseUniqueConstraints() {
+	s.registerConstraint(constraintName(s.table, "", "pkey"), s.IdField())
+
+	for _, f := range s.fields {
+		uf, ok := f.(uniqueField)
+		if !ok || !uf.unique() {
+			continue
+		}
+		if uf.ColumnName() == "" {
+			panic(errUniqueWithoutColumn)
+		}
+		s.registerConstraint(constraintName(s.table, uf.ColumnName(), "key"), f)
+	}
+}
+
+// constraintName returns the name postgres generates
+// for a constraint of a table, in the format "table_column_label".
+// Like postgres, the longer of table and column is truncated
+// until the name doesn't exceed the maximum identifier length.
+func constraintName(table string, column string, label string) string {
+	// the label and underscores separating the parts
+	overhead := len(label) + 1
+	if column != "" {
+		overhead++
+	}
+
+	tableChars, columnChars := len(table), len(column)
+	for tableChars+columnChars > maxIdentifierLength-overhead {
+		if tableChars > columnChars {
+			tableChars--
+		} else {
+			columnChars--
+		}
+	}
+
+	if column == "" {
+		return fmt.Sprintf("%s_%s", table[:tableChars], label)
+	}
+	return fmt.Sprintf("%s_%s_%s", table[:tableChars], column[:columnChars], label)
+}
@@ -0,0 +1,48 @@
+package internal
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type uniqueTest struct {
+	Id        int64  `jargo:",table:custom_unique_tests"`
+	UserName  string `jargo:",unique"`
+	FirstName string `jargo:",unique:full_name"`
+	LastName  string `jargo:",unique:full_name"`
+}
+
+func TestConstraintFields(t *testing.T) {
+	schema := registerSchema(t, uniqueTest{})
+
+	fields := schema.ConstraintFields("custom_unique_tests_pkey")
+	assert.Len(t, fields, 1)
+	assert.Equal(t, IdFieldJsonapiName, fields[0].JSONAPIName())
+
+	fields = schema.ConstraintFields("custom_unique_tests_user_name_key")
+	assert.Len(t, fields, 1)
+	assert.Equal(t, "user-name", fields[0].JSONAPIName())
+
+	indexes := schema.Indexes()
+	assert.Len(t, indexes, 1)
+	assertManagedName(t, indexPrefix, "custom_unique_tests", "full_name", indexes[0].Name)
+	assert.Equal(t, `CREATE UNIQUE INDEX IF NOT EXISTS "`+indexes[0].Name+`" ON "custom_unique_tests" ("first_name", "last_name")`,
+		indexes[0].Definition)
+
+	fields = schema.ConstraintFields(indexes[0].Name)
+	assert.Len(t, fields, 2)
+	assert.Equal(t, "first-name", fields[0].JSONAPIName())
+	assert.Equal(t, "last-name", fields[1].JSONAPIName())
+
+	assert.Nil(t, schema.ConstraintFields("unknown"))
+}
+
+func TestConstraintName(t *testing.T) {
+	assert.Equal(t, "users_name_key", constraintName("users", "name", "key"))
+	assert.Equal(t, "users_pkey", constraintName("users", "", "pkey"))
+
+	// the longer part is truncated first
+	name := constraintName("a_table_name_that_is_way_too_long_to_be_used_as_is_in_a_name", "column", "key")
+	assert.Len(t, name, maxIdentifierLength)
+	assert.Equal(t, "a_table_name_that_is_way_too_long_to_be_used_as_is_i_column_key", name)
+}
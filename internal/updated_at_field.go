@@ -2,15 +2,14 @@ package internal
 
 import (
 	"fmt"
-	"github.com/go-pg/pg"
 )
 
 type updatedAtField struct {
 	*attrField
 }
 
-const updatedAtTriggerQuery = `
-CREATE OR REPLACE FUNCTION jargo_updated_at_trigger_%s_func()
+const updatedAtTriggerFunctionQuery = `
+CREATE OR REPLACE FUNCTION %s()
 RETURNS TRIGGER AS
 $$
 BEGIN
@@ -18,19 +17,31 @@ BEGIN
   RETURN NEW;
 END
 $$ LANGUAGE plpgsql;
+`
 
-DROP TRIGGER IF EXISTS jargo_updated_at_trigger_%s ON "%s";
+const updatedAtTriggerQuery = `
+DROP TRIGGER IF EXISTS %s ON "%s";
 
-CREATE TRIGGER jargo_updated_at_trigger_%s
+CREATE TRIGGER %s
 BEFORE UPDATE ON "%s"
-FOR EACH ROW EXECUTE PROCEDURE jargo_updated_at_trigger_%s_func();
+FOR EACH ROW EXECUTE PROCEDURE %s();
 `
 
-func (f *updatedAtField) afterCreateTable(db *pg.DB) error {
-	_, err := db.Exec(fmt.Sprintf(updatedAtTriggerQuery,
-		f.column, f.column,                 // CREATE FUNCTION statement
-		f.column, f.schema.table,           // DROP TRIGGER statement
-		f.column, f.schema.table, f.column, // CREATE TRIGGER statement
-	))
-	return err
+func (f *updatedAtField) triggerName() string {
+	return fmt.Sprintf("jargo_updated_at_trigger_%s", f.column)
+}
+
+func (f *updatedAtField) triggerFunctionName() string {
+	return fmt.Sprintf("jargo_updated_at_trigger_%s_func", f.column)
+}
+
+func (f *updatedAtField) triggerFunctionQuery() string {
+	return fmt.Sprintf(updatedAtTriggerFunctionQuery, f.triggerFunctionName(), f.column)
+}
+
+func (f *updatedAtField) triggerQuery() string {
+	return f.triggerFunctionQuery() + fmt.Sprintf(updatedAtTriggerQuery,
+		f.triggerName(), f.schema.table, // DROP TRIGGER statement
+		f.triggerName(), f.schema.table, f.triggerFunctionName(), // CREATE TRIGGER statement
+	)
 }
@@ -15,7 +15,8 @@ func isValidJsonapiMemberName(val string) bool {
 	return memberNameRegex.MatchString(val)
 }
 
-func isValidSQLName(val string) bool {
+// IsValidSQLName returns whether val may be used as sql table or column name.
+func IsValidSQLName(val string) bool {
 	return sqlNameRegex.MatchString(val)
 }
 
@@ -128,6 +129,20 @@ func StringToId(id string, typ reflect.Type) interface{} {
 	}
 }
 
+// NormalizeId returns the string representation of an id of the given type,
+// as used in JSON API documents and realtime events,
+// e.g. "1" for "+01" if typ is an integer type.
+// Returns false if id is not a valid id of the given type.
+func NormalizeId(id string, typ reflect.Type) (normalized string, ok bool) {
+	// StringToId panics when encountering invalid ids
+	defer func() {
+		if rec := recover(); rec != nil {
+			normalized, ok = "", false
+		}
+	}()
+	return IdToString(StringToId(id, typ)), true
+}
+
 // StringToTextUnmarshaler converts a string to an instance
 // of the given type, whose pointer type or itself
 // must implement encoding.TextMarshaler.
@@ -1,8 +1,9 @@
 package internal
 
 import (
-	"testing"
 	"github.com/stretchr/testify/assert"
+	"reflect"
+	"testing"
 )
 
 func TestIsValidJsonapiMemberName(t *testing.T) {
@@ -41,12 +42,29 @@ func TestIsValidSQLName(t *testing.T) {
 	ok := isValidJsonapiMemberName("")
 	assert.Equal(t, false, ok)
 
-	ok = isValidSQLName("%hi")
+	ok = IsValidSQLName("%hi")
 	assert.Equal(t, false, ok)
 
-	ok = isValidSQLName("hi")
+	ok = IsValidSQLName("hi")
+	assert.Equal(t, true, ok)
+
+	ok = IsValidSQLName("_hi")
 	assert.Equal(t, true, ok)
+}
 
-	ok = isValidSQLName("_hi")
+func TestNormalizeId(t *testing.T) {
+	id, ok := NormalizeId("+01", reflect.TypeOf(int64(0)))
 	assert.Equal(t, true, ok)
+	assert.Equal(t, "1", id)
+
+	id, ok = NormalizeId("007", reflect.TypeOf(uint(0)))
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "7", id)
+
+	id, ok = NormalizeId("+01", reflect.TypeOf(""))
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "+01", id)
+
+	_, ok = NormalizeId("abc", reflect.TypeOf(int64(0)))
+	assert.Equal(t, false, ok)
 }
@@ -0,0 +1,138 @@
+package jargo
+
+import (
+	"fmt"
+	"github.com/crushedpixel/jargo/internal"
+	"sort"
+)
+
+// MigrationMode determines how an Application
+// creates and migrates the tables of Resources
+// when registering them.
+type MigrationMode int
+
+const (
+	// MigrateAuto creates tables that don't exist yet
+	// and migrates existing tables in place.
+	MigrateAuto MigrationMode = iota
+	// MigrateVerify doesn't change the database,
+	// causing RegisterResource to return an error
+	// if a Resource's table requires any changes.
+	// It is meant to be used when the database is migrated
+	// using migration files, see Application.ApplyMigrations.
+	MigrateVerify
+	// MigrateSkip neither changes nor verifies the database.
+	// It can be used in conjunction with Application.PlanMigrations
+	// to inspect pending changes without applying them.
+	MigrateSkip
+)
+
+// SchemaChangeType is the type of a SchemaChange.
+type SchemaChangeType string
+
+const (
+	ChangeCreateExtension   = SchemaChangeType(internal.CreateExtension)
+	ChangeCreateTable       = SchemaChangeType(internal.CreateTable)
+	ChangeAddColumn         = SchemaChangeType(internal.AddColumn)
+	ChangeDropColumn        = SchemaChangeType(internal.DropColumn)
+	ChangeRenameColumn      = SchemaChangeType(internal.RenameColumn)
+	ChangeAlterColumnType   = SchemaChangeType(internal.AlterColumnType)
+	ChangeSetColumnDefault  = SchemaChangeType(internal.SetColumnDefault)
+	ChangeDropColumnDefault = SchemaChangeType(internal.DropColumnDefault)
+	ChangeSetColumnNotNull  = SchemaChangeType(internal.SetColumnNotNull)
+	ChangeDropColumnNotNull = SchemaChangeType(internal.DropColumnNotNull)
+	ChangeCreateTrigger     = SchemaChangeType(internal.CreateTrigger)
+	ChangeReplaceTrigger    = SchemaChangeType(internal.ReplaceTrigger)
+	ChangeCreateIndex       = SchemaChangeType(internal.CreateIndex)
+	ChangeDropIndex         = SchemaChangeType(internal.DropIndex)
+	ChangeAddCheck          = SchemaChangeType(internal.AddCheck)
+	ChangeDropCheck         = SchemaChangeType(internal.DropCheck)
+	ChangeAddForeignKey     = SchemaChangeType(internal.AddForeignKey)
+	ChangeDropForeignKey    = SchemaChangeType(internal.DropForeignKey)
+	ChangeAddConstraint     = SchemaChangeType(internal.AddConstraint)
+	ChangeDropConstraint    = SchemaChangeType(internal.DropConstraint)
+
+	// ChangeDropRealtimeTrigger drops the realtime trigger from the table
+	// of a Resource that neither has the realtime option set,
+	// nor belongs to a Resource with the realtime option set.
+	// It is never performed by MigrateAuto, as running Realtime instances
+	// may have enabled the Resource using Realtime.Enable.
+	ChangeDropRealtimeTrigger = SchemaChangeType(internal.DropRealtimeTrigger)
+)
+
+// A SchemaChange is a pending change
+// to the database schema of a Resource.
+type SchemaChange struct {
+	Type SchemaChangeType
+	// Table is the name of the Resource's table.
+	Table string
+	// Column is the name of the column affected by the change,
+	// or an empty string if the change doesn't affect a single column.
+	Column string
+	// Name is the name of the extension, trigger, index
+	// or constraint created or dropped by the change.
+	Name string
+	// SQL is the statement performing the change.
+	SQL string
+	// DownSQL is the statement reverting the change,
+	// or an empty string if it is not reverted.
+	DownSQL string
+	// Destructive indicates whether the change may discard data
+	// or fail for tables containing records, e.g. when adding
+	// a column that may not be null without a default value.
+	// Destructive changes are only performed if
+	// Options.AllowDestructiveMigrations is set.
+	Destructive bool
+}
+
+// A MigrationPlan contains the pending
+// schema changes of a Resource.
+type MigrationPlan struct {
+	Resource *Resource
+	Changes  []*SchemaChange
+}
+
+// PlanMigrations returns the pending schema changes
+// of all registered Resources without performing them.
+// Resources whose tables are up to date are omitted.
+// The plans are sorted by the Resources' JSON API names.
+func (app *Application) PlanMigrations() ([]*MigrationPlan, error) {
+	return app.planMigrations(func(resource *Resource) ([]*internal.Change, error) {
+		return resource.schema.PlanMigration(app.DB())
+	})
+}
+
+// planMigrations returns the migration plans of all registered
+// Resources, using plan to determine each Resource's changes.
+func (app *Application) planMigrations(plan func(*Resource) ([]*internal.Change, error)) ([]*MigrationPlan, error) {
+	var plans []*MigrationPlan
+	for _, resource := range app.resources {
+		changes, err := plan(resource)
+		if err != nil {
+			return nil, fmt.Errorf(`error planning migration of resource "%s": %s`,
+				resource.JSONAPIName(), err.Error())
+		}
+		if len(changes) == 0 {
+			continue
+		}
+
+		p := &MigrationPlan{Resource: resource}
+		for _, c := range changes {
+			p.Changes = append(p.Changes, &SchemaChange{
+				Type:        SchemaChangeType(c.Type),
+				Table:       c.Table,
+				Column:      c.Column,
+				Name:        c.Name,
+				SQL:         c.SQL,
+				DownSQL:     c.DownSQL,
+				Destructive: c.Destructive,
+			})
+		}
+		plans = append(plans, p)
+	}
+
+	sort.Slice(plans, func(i, j int) bool {
+		return plans[i].Resource.JSONAPIName() < plans[j].Resource.JSONAPIName()
+	})
+	return plans, nil
+}
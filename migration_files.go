@@ -0,0 +1,380 @@
+package jargo
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/crushedpixel/jargo/internal"
+	"github.com/go-pg/pg"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// migrationsTableName is the name of the table
+	// recording the versions of applied migration files.
+	migrationsTableName = "jargo_migrations"
+	// snapshotFileName is the name of the file in the migrations directory
+	// containing the table states of the last generated migration.
+	snapshotFileName = "schema_snapshot.json"
+)
+
+var errInvalidMigrationName = errors.New("migration name may only consist of [0-9,a-z,A-Z_]")
+
+var (
+	migrationNameRegex = regexp.MustCompile(`\A[0-9a-zA-Z_]+\z`)
+	migrationFileRegex = regexp.MustCompile(`\A(\d+)_([0-9a-zA-Z_]+)\.up\.sql\z`)
+)
+
+const createMigrationsTableQuery = `
+CREATE TABLE IF NOT EXISTS "%s" (
+  "version" integer PRIMARY KEY,
+  "name" text NOT NULL,
+  "applied_at" timestamptz NOT NULL DEFAULT NOW()
+)
+`
+
+// A MigrationFile is a versioned migration
+// consisting of an up and a down SQL file.
+type MigrationFile struct {
+	Version int
+	Name    string
+	// UpPath is the path of the file performing the migration.
+	UpPath string
+	// DownPath is the path of the file reverting the migration.
+	DownPath string
+}
+
+// GenerateMigration diffs the registered Resources
+// against the database, writing the pending schema changes
+// into a new numbered pair of up and down migration files in dir.
+// It also writes a snapshot of the Resources' table states into dir,
+// which can be diffed against using GenerateMigrationFromSnapshot.
+//
+// The database is not changed.
+// Returns nil if there are no pending changes.
+func (app *Application) GenerateMigration(dir string, name string) (*MigrationFile, error) {
+	plans, err := app.PlanMigrations()
+	if err != nil {
+		return nil, err
+	}
+	return app.writeMigration(dir, name, plans)
+}
+
+// GenerateMigrationFromSnapshot diffs the registered Resources
+// against the snapshot written by the last migration file generation in dir,
+// writing the pending schema changes into a new numbered pair
+// of up and down migration files in dir, and updating the snapshot.
+// If there is no snapshot, all tables are assumed not to exist.
+//
+// This allows generating migration files without access to the
+// database to migrate. The Application's database is still required
+// to determine the tables' desired states, but is not changed.
+// Returns nil if there are no pending changes.
+func (app *Application) GenerateMigrationFromSnapshot(dir string, name string) (*MigrationFile, error) {
+	snapshot, err := readSnapshot(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	plans, err := app.planMigrations(func(resource *Resource) ([]*internal.Change, error) {
+		return resource.schema.PlanMigrationFrom(app.DB(), snapshot[resource.schema.Table()])
+	})
+	if err != nil {
+		return nil, err
+	}
+	return app.writeMigration(dir, name, plans)
+}
+
+// writeMigration writes the migration files for
+// the changes of plans, as well as the snapshot.
+func (app *Application) writeMigration(dir string, name string, plans []*MigrationPlan) (*MigrationFile, error) {
+	if !migrationNameRegex.MatchString(name) {
+		return nil, errInvalidMigrationName
+	}
+	if len(plans) == 0 {
+		return nil, nil
+	}
+
+	files, err := readMigrationFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	version := 1
+	if len(files) > 0 {
+		version = files[len(files)-1].Version + 1
+	}
+
+	// foreign keys are dropped before and added after all other
+	// changes, so the tables they reference exist when adding them
+	var dropForeignKeys, other, addForeignKeys []*SchemaChange
+	for _, plan := range plans {
+		for _, c := range plan.Changes {
+			switch c.Type {
+			case ChangeDropForeignKey:
+				dropForeignKeys = append(dropForeignKeys, c)
+			case ChangeAddForeignKey:
+				addForeignKeys = append(addForeignKeys, c)
+			default:
+				other = append(other, c)
+			}
+		}
+	}
+	changes := append(append(dropForeignKeys, other...), addForeignKeys...)
+
+	// up statements are executed in order,
+	// down statements in reverse order
+	var up, down []string
+	for _, c := range changes {
+		up = append(up, c.SQL)
+		if c.DownSQL != "" {
+			down = append([]string{c.DownSQL}, down...)
+		}
+	}
+
+	file := &MigrationFile{
+		Version:  version,
+		Name:     name,
+		UpPath:   filepath.Join(dir, fmt.Sprintf("%04d_%s.up.sql", version, name)),
+		DownPath: filepath.Join(dir, fmt.Sprintf("%04d_%s.down.sql", version, name)),
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(file.UpPath, migrationFileContent(up), 0644); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(file.DownPath, migrationFileContent(down), 0644); err != nil {
+		return nil, err
+	}
+
+	if err := app.writeSnapshot(dir); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// migrationFileContent returns the content
+// of a migration file executing statements.
+func migrationFileContent(statements []string) []byte {
+	var b bytes.Buffer
+	for _, s := range statements {
+		s = strings.TrimSpace(s)
+		b.WriteString(s)
+		if !strings.HasSuffix(s, ";") {
+			b.WriteString(";")
+		}
+		b.WriteString("\n\n")
+	}
+	return b.Bytes()
+}
+
+// readSnapshot reads the snapshot in dir,
+// returning the table states by table name.
+// Returns an empty snapshot if there is none.
+func readSnapshot(dir string) (map[string]*internal.TableState, error) {
+	snapshot := make(map[string]*internal.TableState)
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, snapshotFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return snapshot, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// writeSnapshot writes the desired table states
+// of all registered Resources into dir.
+// Table states already in the snapshot whose
+// Resource is not registered are kept.
+func (app *Application) writeSnapshot(dir string) error {
+	snapshot, err := readSnapshot(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, resource := range app.resources {
+		state, err := resource.schema.DesiredState(app.DB())
+		if err != nil {
+			return err
+		}
+		snapshot[state.Table] = state
+	}
+
+	b, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, snapshotFileName), b, 0644)
+}
+
+// readMigrationFiles returns all migration
+// files in dir, sorted by version.
+func readMigrationFiles(dir string) ([]*MigrationFile, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var files []*MigrationFile
+	for _, info := range infos {
+		match := migrationFileRegex.FindStringSubmatch(info.Name())
+		if match == nil {
+			continue
+		}
+		version, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, &MigrationFile{
+			Version:  version,
+			Name:     match[2],
+			UpPath:   filepath.Join(dir, info.Name()),
+			DownPath: filepath.Join(dir, fmt.Sprintf("%s_%s.down.sql", match[1], match[2])),
+		})
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Version < files[j].Version
+	})
+	for i := 1; i < len(files); i++ {
+		if files[i].Version == files[i-1].Version {
+			return nil, fmt.Errorf("duplicate migration version %d", files[i].Version)
+		}
+	}
+	return files, nil
+}
+
+// ApplyMigrations applies all migration files in dir
+// that haven't been applied yet in order of their versions,
+// recording the applied versions in the jargo_migrations table.
+// Each migration is applied in its own transaction.
+//
+// It is meant to be used in conjunction with
+// the MigrateVerify migration mode.
+// Returns the migration files applied.
+func (app *Application) ApplyMigrations(dir string) ([]*MigrationFile, error) {
+	files, err := readMigrationFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := app.DB().Exec(fmt.Sprintf(createMigrationsTableQuery, migrationsTableName)); err != nil {
+		return nil, err
+	}
+
+	var applied []*MigrationFile
+	for _, file := range files {
+		ok, err := app.runMigrationFile(file, true)
+		if err != nil {
+			return applied, fmt.Errorf(`error applying migration %d "%s": %s`,
+				file.Version, file.Name, err.Error())
+		}
+		if ok {
+			applied = append(applied, file)
+		}
+	}
+	return applied, nil
+}
+
+// RevertMigration reverts the most recently applied
+// migration in dir using its down file.
+// Returns the migration file reverted,
+// or nil if no migrations were applied.
+func (app *Application) RevertMigration(dir string) (*MigrationFile, error) {
+	files, err := readMigrationFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := app.DB().Exec(fmt.Sprintf(createMigrationsTableQuery, migrationsTableName)); err != nil {
+		return nil, err
+	}
+
+	var version int
+	_, err = app.DB().QueryOne(pg.Scan(&version),
+		fmt.Sprintf(`SELECT "version" FROM "%s" ORDER BY "version" DESC LIMIT 1`, migrationsTableName))
+	if err != nil {
+		if err == pg.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	for _, file := range files {
+		if file.Version == version {
+			if _, err := app.runMigrationFile(file, false); err != nil {
+				return nil, fmt.Errorf(`error reverting migration %d "%s": %s`,
+					file.Version, file.Name, err.Error())
+			}
+			return file, nil
+		}
+	}
+	return nil, fmt.Errorf("migration file of applied version %d not found", version)
+}
+
+// runMigrationFile applies or reverts a migration file
+// in a transaction, updating the jargo_migrations table.
+// Returns false if the migration was already
+// applied or reverted, respectively.
+func (app *Application) runMigrationFile(file *MigrationFile, up bool) (bool, error) {
+	path := file.DownPath
+	if up {
+		path = file.UpPath
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+
+	ran := false
+	err = app.DB().RunInTransaction(func(tx *pg.Tx) error {
+		// prevent migrations from being run concurrently
+		if _, err := tx.Exec(fmt.Sprintf(`LOCK TABLE "%s" IN EXCLUSIVE MODE`, migrationsTableName)); err != nil {
+			return err
+		}
+
+		var applied bool
+		if _, err := tx.QueryOne(pg.Scan(&applied),
+			fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM "%s" WHERE "version" = ?)`, migrationsTableName),
+			file.Version); err != nil {
+			return err
+		}
+		if applied == up {
+			return nil
+		}
+
+		if strings.TrimSpace(string(content)) != "" {
+			if _, err := tx.Exec(string(content)); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		if up {
+			_, err = tx.Exec(fmt.Sprintf(`INSERT INTO "%s" ("version", "name") VALUES (?, ?)`, migrationsTableName),
+				file.Version, file.Name)
+		} else {
+			_, err = tx.Exec(fmt.Sprintf(`DELETE FROM "%s" WHERE "version" = ?`, migrationsTableName),
+				file.Version)
+		}
+		if err != nil {
+			return err
+		}
+		ran = true
+		return nil
+	})
+	return ran, err
+}
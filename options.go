@@ -3,6 +3,8 @@ package jargo
 import (
 	"github.com/go-pg/pg"
 	"gopkg.in/go-playground/validator.v9"
+	"log"
+	"time"
 )
 
 // DefaultMaxPageSize is the default maximum number
@@ -18,6 +20,51 @@ type Options struct {
 	MaxPageSize          int
 
 	Validate *validator.Validate
+
+	// ErrorHandler is invoked with errors that occur
+	// in the Application's background tasks,
+	// such as expiring records.
+	// Defaults to a function logging the error.
+	ErrorHandler ErrorHandlerFunc
+
+	// ExpireLeaderCheckInterval is the interval in which
+	// resource expirers try to acquire leadership
+	// or check whether they are still the leader.
+	// Only a single instance of all Applications
+	// sharing a database expires a Resource's records.
+	// Defaults to 10s.
+	ExpireLeaderCheckInterval time.Duration
+
+	// ExpireBatchSize is the maximum number
+	// of records expired in a single query.
+	// Defaults to 1000.
+	ExpireBatchSize int
+
+	// ExpireBatchPause is the time to wait
+	// between expiring batches of records.
+	// Defaults to 100ms.
+	ExpireBatchPause time.Duration
+
+	// AllowDestructiveMigrations allows migrations
+	// of existing tables that may discard data,
+	// such as dropping columns or changing their types.
+	// If false, registering a Resource whose table
+	// requires such changes returns an error.
+	AllowDestructiveMigrations bool
+
+	// MigrationMode determines how the tables
+	// of Resources are created and migrated
+	// when registering them.
+	// Defaults to MigrateAuto.
+	MigrationMode MigrationMode
+}
+
+// ErrorHandlerFunc handles errors occurring
+// in an Application's background tasks.
+type ErrorHandlerFunc func(err error)
+
+func defaultErrorHandlerFunc(err error) {
+	log.Printf("Error: %s\n", err.Error())
 }
 
 func (o *Options) setDefaults() {
@@ -42,4 +89,23 @@ func (o *Options) setDefaults() {
 	if o.Validate == nil {
 		o.Validate = validator.New()
 	}
+
+	if o.ErrorHandler == nil {
+		o.ErrorHandler = defaultErrorHandlerFunc
+	}
+
+	if o.ExpireLeaderCheckInterval == 0 {
+		o.ExpireLeaderCheckInterval = 10 * time.Second
+	}
+
+	if o.ExpireBatchSize == 0 {
+		o.ExpireBatchSize = 1000
+	}
+	if o.ExpireBatchSize < 1 {
+		panic("expire batch size has to be positive")
+	}
+
+	if o.ExpireBatchPause == 0 {
+		o.ExpireBatchPause = 100 * time.Millisecond
+	}
 }
@@ -42,6 +42,13 @@ func ParseFieldParameters(query map[string][]string) map[string][]string {
 // extracting filter parameters.
 // The resulting map can be used in Resource.ParseFilters.
 //
+// Comma-separated values are split into multiple values,
+// except for values of the "contains" operator, which are
+// JSON values that may contain commas themselves.
+// Values of other operators on jsonb attributes are split as well,
+// so JSON values containing commas can only be filtered by
+// using the "contains" operator or Resource.Filters.
+//
 // http://jsonapi.org/format/#fetching-filtering
 func ParseFilterParameters(query map[string][]string) map[string]map[string][]interface{} {
 	// map[field]map[operator][]values
@@ -70,6 +77,12 @@ func ParseFilterParameters(query map[string][]string) map[string]map[string][]in
 
 		values := make([]interface{}, 0)
 		for _, val := range v {
+			if op == "CONTAINS" {
+				// values of the contains operator are JSON,
+				// which may contain commas
+				values = append(values, val)
+				continue
+			}
 			for _, str := range strings.Split(val, ",") {
 				values = append(values, str)
 			}
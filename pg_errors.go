@@ -0,0 +1,279 @@
+package jargo
+
+import (
+	"fmt"
+	"github.com/crushedpixel/jargo/internal"
+	"github.com/go-pg/pg"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// A PGErrorMapper maps a postgres error returned by a Query
+// on a Resource to the error returned by the Query instead,
+// e.g. an ApiError. If it returns nil, the error is mapped
+// as if no PGErrorMapper was registered.
+//
+// PGErrorMappers are registered per SQLSTATE code
+// using RegisterPGErrorMapper.
+type PGErrorMapper func(resource *Resource, err pg.Error) error
+
+var (
+	// pgErrorMappers contains the PGErrorMappers
+	// registered for SQLSTATE codes.
+	pgErrorMappers = make(map[string]PGErrorMapper)
+	// pgErrorMappersMutex is the mutex protecting pgErrorMappers
+	pgErrorMappersMutex = &sync.Mutex{}
+)
+
+// RegisterPGErrorMapper registers a PGErrorMapper for postgres errors
+// with the given SQLSTATE code, replacing any PGErrorMapper
+// previously registered for the code. It takes precedence over
+// jargo's own mapping of the code, if there is one.
+// The PGErrorMapper applies to Queries of all Resources.
+//
+// See https://www.postgresql.org/docs/10/static/errcodes-appendix.html
+func RegisterPGErrorMapper(code string, mapper PGErrorMapper) {
+	pgErrorMappersMutex.Lock()
+	pgErrorMappers[code] = mapper
+	pgErrorMappersMutex.Unlock()
+}
+
+// pgErrorMapper returns the PGErrorMapper registered
+// for the given SQLSTATE code, or nil.
+func pgErrorMapper(code string) PGErrorMapper {
+	pgErrorMappersMutex.Lock()
+	defer pgErrorMappersMutex.Unlock()
+	return pgErrorMappers[code]
+}
+
+// pgErrToApiErr returns descriptive ApiError instances
+// for specific pg.Error types. For unexpected errors,
+// it returns the error itself.
+//
+// https://www.postgresql.org/docs/10/static/protocol-error-fields.html
+// https://www.postgresql.org/docs/10/static/errcodes-appendix.html
+func (q *Query) pgErrToApiErr(pgErr pg.Error) error {
+	code := pgErr.Field('C')
+
+	// custom mappers take precedence
+	if mapper := pgErrorMapper(code); mapper != nil {
+		if err := mapper(q.resource, pgErr); err != nil {
+			return err
+		}
+	}
+
+	schema := q.resource.schema
+	switch code {
+	case "23502": // not_null_violation
+		return newNotNullViolationError(schema.FieldByColumn(pgErr.Field('c')), pgErr.Field('c'))
+	case "23503": // foreign_key_violation
+		constraint := pgErr.Field('n')
+
+		var resource, relationship string
+		reference := schema.Reference(constraint)
+		if reference != nil {
+			resource = reference.Schema.JSONAPIName()
+			relationship = reference.Field.JSONAPIName()
+		}
+
+		if reference != nil && reference.Schema == schema && q.typ != typeDelete {
+			// the relationship of the resource being written
+			// references a resource that doesn't exist
+			return newForeignKeyViolationError(http.StatusNotFound,
+				fmt.Sprintf(`related resource of relationship "%s" does not exist`, relationship),
+				internal.SourcePointer(reference.Field), resource, relationship, constraint)
+		}
+		// the resource is still referenced by another resource
+		return newForeignKeyViolationError(http.StatusConflict,
+			fmt.Sprintf(`resource is still referenced by relationship "%s" of resource "%s"`, relationship, resource),
+			"", resource, relationship, constraint)
+	case "23505": // unique_violation
+		return newUniqueViolationError(schema.ConstraintFields(pgErr.Field('n')))
+	case "23514": // check_violation
+		constraint := pgErr.Field('n')
+
+		var field internal.SchemaField
+		if fields := schema.ConstraintFields(constraint); len(fields) > 0 {
+			field = fields[0]
+		}
+		return newCheckViolationError(field, constraint)
+	case "22001": // string_data_right_truncation
+		return newValueTooLongError(pgErr.Field('M'),
+			schema.FieldByColumn(pgErr.Field('c')), pgErr.Field('c'))
+	case "22P02": // invalid_text_representation
+		return newInvalidTextRepresentationError(pgErr.Field('M'),
+			schema.FieldByColumn(pgErr.Field('c')), pgErr.Field('c'))
+	default:
+		return pgErr.(error)
+	}
+}
+
+// fieldInfo returns the JSON API name and source pointer of a field.
+// Returns empty strings if field is nil.
+func fieldInfo(field internal.SchemaField) (name string, pointer string) {
+	if field == nil {
+		return "", ""
+	}
+	return field.JSONAPIName(), internal.SourcePointer(field)
+}
+
+// NotNullViolationError is returned by Queries that
+// violate a NOT NULL constraint in the database.
+type NotNullViolationError struct {
+	*ApiError
+	// Field is the JSON API name of the field
+	// whose constraint was violated
+	Field string
+	// Column is the database column
+	// whose constraint was violated
+	Column string
+}
+
+func newNotNullViolationError(field internal.SchemaField, column string) *NotNullViolationError {
+	name, pointer := fieldInfo(field)
+	return &NotNullViolationError{
+		ApiError: NewApiErrorWithPointer(http.StatusBadRequest, "NOT_NULL_VIOLATION", name, pointer),
+		Field:    name,
+		Column:   column,
+	}
+}
+
+// ForeignKeyViolationError is returned by Queries that violate
+// a foreign key created for a belongsTo relation with the "onDelete" option.
+//
+// If a relationship of the resource being written references a resource
+// that doesn't exist, its status is 404 Not Found and its source pointer
+// is the relationship's. If a resource is still referenced by another
+// resource, e.g. when deleting a record that is still referenced
+// by a relation with the "onDelete:restrict" option, its status is 409 Conflict.
+type ForeignKeyViolationError struct {
+	*ApiError
+	// Resource is the JSON API name of the resource
+	// containing the violating relationship
+	Resource string
+	// Relationship is the JSON API name
+	// of the violating relationship
+	Relationship string
+	// Constraint is the name of the violated foreign key
+	Constraint string
+}
+
+func newForeignKeyViolationError(status int, detail string, pointer string,
+	resource string, relationship string, constraint string) *ForeignKeyViolationError {
+
+	return &ForeignKeyViolationError{
+		ApiError:     NewApiErrorWithPointer(status, "FOREIGN_KEY_VIOLATION", detail, pointer),
+		Resource:     resource,
+		Relationship: relationship,
+		Constraint:   constraint,
+	}
+}
+
+// UniqueViolationError is returned by Queries that violate
+// a unique constraint in the database.
+type UniqueViolationError struct {
+	*ApiError
+	// Field is the JSON API name of the first field
+	// whose constraint was violated
+	Field string
+	// Column is the database column of the first field
+	// whose constraint was violated
+	Column string
+	// Fields contains the JSON API names of all fields
+	// covered by the violated constraint
+	Fields []string
+	// Columns contains the database columns of all fields
+	// covered by the violated constraint
+	Columns []string
+}
+
+func newUniqueViolationError(fields []internal.SchemaField) *UniqueViolationError {
+	e := &UniqueViolationError{}
+	for _, f := range fields {
+		e.Fields = append(e.Fields, f.JSONAPIName())
+		e.Columns = append(e.Columns, f.ColumnName())
+	}
+
+	var pointer string
+	if len(fields) > 0 {
+		e.Field, pointer = fieldInfo(fields[0])
+		e.Column = fields[0].ColumnName()
+	}
+	e.ApiError = NewApiErrorWithPointer(http.StatusBadRequest, "UNIQUE_VIOLATION",
+		strings.Join(e.Fields, ","), pointer)
+	return e
+}
+
+// CheckViolationError is returned by Queries that violate
+// a check constraint in the database, such as the constraints
+// created for the "enum" and "check" options.
+type CheckViolationError struct {
+	*ApiError
+	// Field is the JSON API name of the field
+	// whose constraint was violated
+	Field string
+	// Column is the database column
+	// whose constraint was violated
+	Column string
+	// Constraint is the name of the violated constraint
+	Constraint string
+}
+
+func newCheckViolationError(field internal.SchemaField, constraint string) *CheckViolationError {
+	name, pointer := fieldInfo(field)
+	var column string
+	if field != nil {
+		column = field.ColumnName()
+	}
+	return &CheckViolationError{
+		ApiError:   NewApiErrorWithPointer(http.StatusBadRequest, "CHECK_VIOLATION", name, pointer),
+		Field:      name,
+		Column:     column,
+		Constraint: constraint,
+	}
+}
+
+// ValueTooLongError is returned by Queries writing
+// a value exceeding the length of its column's type,
+// e.g. a string exceeding the length of a varchar column.
+type ValueTooLongError struct {
+	*ApiError
+	// Field is the JSON API name of the field whose
+	// value is too long, if reported by the database
+	Field string
+	// Column is the database column whose
+	// value is too long, if reported by the database
+	Column string
+}
+
+func newValueTooLongError(detail string, field internal.SchemaField, column string) *ValueTooLongError {
+	name, pointer := fieldInfo(field)
+	return &ValueTooLongError{
+		ApiError: NewApiErrorWithPointer(http.StatusBadRequest, "VALUE_TOO_LONG", detail, pointer),
+		Field:    name,
+		Column:   column,
+	}
+}
+
+// InvalidTextRepresentationError is returned by Queries
+// using a value the database cannot convert to its column's type,
+// e.g. a malformed UUID.
+type InvalidTextRepresentationError struct {
+	*ApiError
+	// Field is the JSON API name of the field
+	// whose value is invalid, if reported by the database
+	Field string
+	// Column is the database column
+	// whose value is invalid, if reported by the database
+	Column string
+}
+
+func newInvalidTextRepresentationError(detail string, field internal.SchemaField, column string) *InvalidTextRepresentationError {
+	name, pointer := fieldInfo(field)
+	return &InvalidTextRepresentationError{
+		ApiError: NewApiErrorWithPointer(http.StatusBadRequest, "INVALID_TEXT_REPRESENTATION", detail, pointer),
+		Field:    name,
+		Column:   column,
+	}
+}
@@ -7,13 +7,13 @@ import (
 	"github.com/mohae/deepcopy"
 	"net/http"
 	"reflect"
-	"regexp"
 )
 
 var (
 	errQueryType              = errors.New("invalid query type")
 	errNotSelecting           = errors.New("query type must be select")
 	errNotSelectingOrDeleting = errors.New("query type must be select or delete")
+	errNotSelectingOrUpdating = errors.New("query type must be select or update")
 	errNoCollection           = errors.New("query must be a collection")
 	errMismatchingResource    = errors.New("resource does not match query resource")
 )
@@ -34,6 +34,7 @@ type Query struct {
 	*orm.Query
 
 	// final fields
+	db         orm.DB
 	typ        queryType
 	resource   *Resource
 	collection bool // whether the resource model is a slice
@@ -42,6 +43,9 @@ type Query struct {
 	fields     *FieldSet
 	pagination Pagination
 	filters    *Filters
+	// whether to refresh the expiration
+	// of the records returned
+	refreshExpiration bool
 
 	// whereCalls contains query calls altering
 	// the WHERE clause. these calls are applied to the
@@ -64,6 +68,7 @@ func newQuery(db orm.DB, resource *Resource, typ queryType, collection bool, pgM
 
 	return &Query{
 		Query:      db.Model(clone),
+		db:         db,
 		typ:        typ,
 		resource:   resource,
 		collection: collection,
@@ -178,6 +183,23 @@ func (q *Query) Filters(f *Filters) *Query {
 	return q
 }
 
+// RefreshExpiration causes the expiration of all records
+// returned by the Query to be extended by the ttl of
+// the Resource's expire field after executing it.
+// See Resource.RefreshExpiration for the records that are refreshed.
+// Has no effect if the Resource has no expire field
+// or its expire field has no "ttl" option.
+//
+// Panics if Query is not a Select or Update Query.
+func (q *Query) RefreshExpiration() *Query {
+	if q.typ != typeSelect && q.typ != typeUpdate {
+		panic(errNotSelectingOrUpdating)
+	}
+	q.refreshExpiration = true
+
+	return q
+}
+
 // Result returns the query result resource model.
 // Executes the query if it hasn't been executed yet.
 func (q *Query) Result() (interface{}, error) {
@@ -337,58 +359,11 @@ func (q *Query) execute() {
 	} else {
 		q.result = q.resource.schema.ParsePGModel(m.Interface()).ToResourceModel()
 	}
-}
 
-var constraintSuffixRegex = regexp.MustCompile(`_([^_]+)?\z`)
-
-// pgErrToApiErr returns descriptive ApiError instances
-// for specific pg.Error types. For unexpected errors,
-// it returns the error itself.
-//
-// https://www.postgresql.org/docs/10/static/protocol-error-fields.html
-// https://www.postgresql.org/docs/10/static/errcodes-appendix.html
-func (q *Query) pgErrToApiErr(pgErr pg.Error) error {
-	switch pgErr.Field('C') {
-	case "23505": // unique_violation
-		// parse constraint name to get column name
-		constraint := pgErr.Field('n')
-		// unique constraint is always in the format "table_column_suffix",
-		// so we can strip away the table name and following underscore
-		// from the start, and _suffix from the end
-		column := constraint[len(q.resource.schema.Table())+1:]
-		column = constraintSuffixRegex.ReplaceAllString(column, "")
-
-		// get json api field name for column
-		var field string
-		for _, f := range q.resource.schema.Fields() {
-			if f.ColumnName() == column {
-				field = f.JSONAPIName()
-				break
-			}
+	if q.refreshExpiration {
+		if err := q.resource.RefreshExpiration(q.db, q.result); err != nil {
+			q.executionError = err
+			q.result = nil
 		}
-
-		return newUniqueViolationError(field, column)
-	default:
-		return pgErr.(error)
-	}
-}
-
-// UniqueViolationError is returned by Queries that violate
-// a unique constraint in the database.
-type UniqueViolationError struct {
-	*ApiError
-	// Field is the JSON API name of the field
-	// whose constraint was violated
-	Field string
-	// Column is the database column
-	// whose constraint was violated
-	Column string
-}
-
-func newUniqueViolationError(field string, column string) *UniqueViolationError {
-	return &UniqueViolationError{
-		ApiError: NewApiError(http.StatusBadRequest, "UNIQUE_VIOLATION", field),
-		Field:    field,
-		Column:   column,
 	}
 }
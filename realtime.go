@@ -8,71 +8,134 @@ import (
 	"github.com/crushedpixel/cement"
 	"github.com/crushedpixel/jargo/internal"
 	"github.com/desertbit/glue"
-	"github.com/go-pg/pg"
-	"github.com/go-pg/pg/orm"
 	"github.com/google/jsonapi"
 	"github.com/json-iterator/go"
+	"log"
 	"net/http"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const (
-	triggerFunctionName             = "jargo_realtime_notify"
+	triggerFunctionName             = internal.RealtimeTriggerFunctionName
 	realtimeNotificationChannelName = "jargo_realtime"
+	realtimeEventsTableName         = "jargo_realtime_events"
 )
 
 var (
 	errRealtimeAlreadyRunning = errors.New("realtime instance is already running")
 	errRealtimeNotRunning     = errors.New("realtime instance must be running to be able to handle http requests")
+	errInvalidEventRetention  = errors.New("realtime event retention must be positive")
 )
 
-// triggerFunctionQuery is a query creating a trigger function
-// that notifies the notification channel whenever a row is inserted, updated or deleted.
+// triggerFunctionQuery is a query creating the realtime event log table
+// and a trigger function that appends an event to it and notifies
+// the notification channel whenever a row is inserted, updated or deleted.
+//
+// To stay below the 8000 byte limit of pg_notify payloads, an event
+// only contains the table, id and operation, as well as the values of the
+// relation id columns (passed as trigger arguments) that were changed.
+// If the notification payload still exceeds the limit,
+// only the event's sequence number is sent.
 var triggerFunctionQuery = fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %s (
+  seq bigserial PRIMARY KEY,
+  table_name text NOT NULL,
+  row_id text NOT NULL,
+  type text NOT NULL,
+  old_relations jsonb NOT NULL,
+  new_relations jsonb NOT NULL,
+  created_at timestamptz NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS %s_created_at_idx ON %s (created_at);
+
 CREATE OR REPLACE FUNCTION %s() RETURNS TRIGGER AS $$
+DECLARE
+  old_row jsonb;
+  new_row jsonb;
+  old_relations jsonb := '{}'::jsonb;
+  new_relations jsonb := '{}'::jsonb;
+  col text;
+  event_seq bigint;
+  payload text;
 BEGIN
   IF TG_OP = 'INSERT' THEN
-    PERFORM pg_notify('%s', json_build_object('table', TG_TABLE_NAME,
-      'id', NEW.id::text, 'type', TG_OP,
-      'new', row_to_json(NEW)::text
-    )::text);
+    new_row := to_jsonb(NEW);
   ELSIF TG_OP = 'DELETE' THEN
-    PERFORM pg_notify('%s', json_build_object('table', TG_TABLE_NAME,
-      'id', OLD.id::text, 'type', TG_OP,
-      'old', row_to_json(OLD)::text
-    )::text);
+    old_row := to_jsonb(OLD);
   ELSIF TG_OP = 'UPDATE' THEN
-    PERFORM pg_notify('%s', json_build_object('table', TG_TABLE_NAME,
-      'id', OLD.id::text, 'type', TG_OP,
-      'old', row_to_json(OLD)::text,
-      'new', row_to_json(NEW)::text
-    )::text);
+    old_row := to_jsonb(OLD);
+    new_row := to_jsonb(NEW);
   ELSE
     RAISE EXCEPTION 'Invalid Trigger Operation: %%', TG_OP;
   END IF;
-  RETURN NEW;
+
+  -- collect the values of all relation id columns
+  -- that were set, unset or changed by the operation
+  FOR i IN 0 .. TG_NARGS - 1 LOOP
+    col := TG_ARGV[i];
+    IF TG_OP = 'UPDATE' AND (old_row -> col) IS NOT DISTINCT FROM (new_row -> col) THEN
+      CONTINUE;
+    END IF;
+    IF jsonb_typeof(old_row -> col) <> 'null' THEN
+      old_relations := old_relations || jsonb_build_object(col, old_row ->> col);
+    END IF;
+    IF jsonb_typeof(new_row -> col) <> 'null' THEN
+      new_relations := new_relations || jsonb_build_object(col, new_row ->> col);
+    END IF;
+  END LOOP;
+
+  INSERT INTO %s (table_name, row_id, type, old_relations, new_relations)
+  VALUES (TG_TABLE_NAME, COALESCE(new_row, old_row) ->> 'id', TG_OP, old_relations, new_relations)
+  RETURNING seq INTO event_seq;
+
+  payload := json_build_object('seq', event_seq, 'table', TG_TABLE_NAME,
+    'id', COALESCE(new_row, old_row) ->> 'id', 'type', TG_OP,
+    'old', old_relations,
+    'new', new_relations
+  )::text;
+
+  IF octet_length(payload) >= 8000 THEN
+    payload := json_build_object('seq', event_seq, 'truncated', true)::text;
+  END IF;
+
+  PERFORM pg_notify('%s', payload);
+  RETURN NULL;
 END;
 $$ LANGUAGE plpgsql;
 `,
+	realtimeEventsTableName,
+	realtimeEventsTableName,
+	realtimeEventsTableName,
 	triggerFunctionName,
-	realtimeNotificationChannelName,
-	realtimeNotificationChannelName,
+	realtimeEventsTableName,
 	realtimeNotificationChannelName)
 
 // notificationPayload is a struct representation
 // of the json payload sent to jargo_realtime listeners
+// and of the rows of the realtime event log.
 type notificationPayload struct {
+	// The sequence number of the event
+	Seq int64 `json:"seq" sql:"seq"`
 	// The table name of the modified record
-	Table string `json:"table"`
+	Table string `json:"table" sql:"table_name"`
 	// The id of the modified record
-	Id string `json:"id"`
+	Id string `json:"id" sql:"row_id"`
 	// Type of the action made to the row
-	Type string `json:"type"`
-	// The original record, json-encoded
-	OldRecord string `json:"old"`
-	// The modified record, json-encoded
-	NewRecord string `json:"new"`
+	Type string `json:"type" sql:"type"`
+	// The relation id column values that were changed,
+	// before the change, mapped by column name
+	OldRelations map[string]string `json:"old" sql:"old_relations"`
+	// The relation id column values that were changed,
+	// after the change, mapped by column name
+	NewRelations map[string]string `json:"new" sql:"new_relations"`
+	// Truncated indicates that the payload exceeded the
+	// pg_notify size limit and only contains the sequence number.
+	// The event has to be read from the event log.
+	Truncated bool `json:"truncated" sql:"-"`
 }
 
 const (
@@ -89,22 +152,34 @@ const (
 	// after the connection was accepted.
 	msgConnectionAccepted = "CONNECTION_ACCEPTED"
 
-	subscribeChannelName = "subscribe"
-	deletedChannelName   = "deleted"
-	updatedChannelName   = "updated"
+	subscribeChannelName   = "subscribe"
+	unsubscribeChannelName = "unsubscribe"
+	deletedChannelName     = "deleted"
+	updatedChannelName     = "updated"
+	resyncChannelName      = "resync"
+	revokedChannelName     = "revoked"
 
 	msgOk              = `{"status":"ok"}`
 	msgInvalidResource = `{"error":"INVALID_RESOURCE"}`
+	msgInvalidId       = `{"error":"INVALID_ID"}`
 	msgAccessDenied    = `{"error":"ACCESS_DENIED"}`
+	msgNotSubscribed   = `{"error":"NOT_SUBSCRIBED"}`
+	msgResync          = `{}`
 )
 
 // subscribePayload is a struct representing the JSON payload
-// sent by Realtime clients when subscribing to a resource.
+// sent by Realtime clients when subscribing to
+// or unsubscribing from a resource.
 type subscribePayload struct {
 	// Model is the JSON API member name of the resource type to subscribe to
 	Model string `json:"model"`
 	// Id is the id of the resource to subscribe to
 	Id string `json:"id"`
+	// Since is the sequence number of the last event
+	// the client received before reconnecting.
+	// If set, all events missed since then are sent
+	// after subscribing.
+	Since int64 `json:"since"`
 }
 
 // resourceDeletedPayload is a struct representing the JSON payload
@@ -112,6 +187,14 @@ type subscribePayload struct {
 type resourceDeletedPayload struct {
 	Model string `json:"model"`
 	Id    string `json:"id"`
+	Seq   int64  `json:"seq"`
+}
+
+// resourceRevokedPayload is a struct representing the JSON payload
+// to send to Realtime clients when a subscription was revoked.
+type resourceRevokedPayload struct {
+	Model string `json:"model"`
+	Id    string `json:"id"`
 }
 
 // resourceUpdatedPayload is a struct representing the JSON payload
@@ -119,12 +202,21 @@ type resourceDeletedPayload struct {
 type resourceUpdatedPayload struct {
 	Model   string `json:"model"`
 	Id      string `json:"id"`
+	Seq     int64  `json:"seq"`
 	Payload string `json:"payload"`
 }
 
 // Realtime allows clients to subscribe to
 // resource instances via websocket.
+// Realtime has to be enabled for each Resource,
+// either using the realtime option on its id field
+// or by calling Enable.
 type Realtime struct {
+	// lastSeq is the sequence number of the most recent
+	// event received. Must be accessed atomically,
+	// so it is the first field to guarantee 64-bit alignment.
+	lastSeq int64
+
 	*glue.Server
 
 	app *Application
@@ -132,6 +224,11 @@ type Realtime struct {
 	// namespace is the namespace on which to listen for requests.
 	namespace string
 
+	// CheckOrigin is invoked for every incoming connection request
+	// to determine whether the request's origin is allowed.
+	// Defaults to a function always returning true.
+	CheckOrigin CheckOriginFunc
+
 	// ConnectionMessageTimeout is the time
 	// to wait for the connection message.
 	// Defaults to 10s.
@@ -147,12 +244,107 @@ type Realtime struct {
 
 	MaySubscribe MaySubscribeFunc
 
+	// MayReceive is invoked before sending an updated or deleted
+	// message to a socket. If it returns false, the message is not sent
+	// and the socket's subscription to the resource instance is revoked.
+	// Defaults to a function always returning true.
+	MayReceive MayReceiveFunc
+
+	// ErrorHandler is invoked with errors that occur
+	// while processing database notifications, which
+	// can't be returned to a caller.
+	// Defaults to a function logging the error.
+	ErrorHandler RealtimeErrorHandlerFunc
+
+	// MissedEvents is invoked after the database notification listener
+	// has been re-established following a connection loss,
+	// as changes made in the meantime were not received.
+	// Defaults to ReplayMissedEvents.
+	MissedEvents MissedEventsFunc
+
+	// ReconnectMinBackoff is the time to wait before
+	// trying to re-establish the database notification listener
+	// after a connection loss. It is doubled after every
+	// failed attempt, up to ReconnectMaxBackoff.
+	// Defaults to 100ms.
+	ReconnectMinBackoff time.Duration
+	// ReconnectMaxBackoff is the maximum time to wait before
+	// trying to re-establish the database notification listener.
+	// Defaults to 30s.
+	ReconnectMaxBackoff time.Duration
+
+	// MaySubscribeSSE is invoked for every subscription
+	// requested by a Server-Sent Events client.
+	// If it returns false, the request is rejected.
+	// Defaults to a function always returning true.
+	MaySubscribeSSE MaySubscribeSSEFunc
+
+	// MayReceiveSSE is the equivalent of MayReceive
+	// for Server-Sent Events clients.
+	// Defaults to a function always returning true.
+	MayReceiveSSE MayReceiveSSEFunc
+
+	// SSEKeepAliveInterval is the interval in which
+	// comments are sent to Server-Sent Events clients
+	// to keep the connection alive.
+	// Defaults to 30s.
+	SSEKeepAliveInterval time.Duration
+
+	// CoalesceWindow is the time changes to resource instances
+	// are collected before they are sent to subscribers.
+	// Multiple changes to the same resource instance within the window
+	// are merged into a single message, and the instances of a resource
+	// that were updated are fetched using a single query.
+	// If zero, changes are sent immediately.
+	// Defaults to 0.
+	CoalesceWindow time.Duration
+
+	// EventRetention is the time events are kept in the event log,
+	// allowing clients to receive the events they missed
+	// after reconnecting. Must be positive.
+	// Defaults to 24h.
+	EventRetention time.Duration
+
 	// subscriptions is a map containing all subscriptions
-	// for a socket.
-	subscriptions map[*glue.Socket]map[*Resource][]string
+	// for a subscriber. The ids a subscriber is subscribed to
+	// are stored as a set to avoid duplicate subscriptions.
+	subscriptions map[subscriber]map[*Resource]map[string]struct{}
 	// subscriptionsMutex is the mutex protecting subscriptions
 	subscriptionsMutex *sync.Mutex
 
+	// socketValues contains the connection context values
+	// stored for each socket.
+	socketValues map[*glue.Socket]map[interface{}]interface{}
+	// socketValuesMutex is the mutex protecting socketValues
+	socketValuesMutex *sync.Mutex
+
+	// enabled contains the Resources realtime is enabled for.
+	enabled map[*Resource]struct{}
+	// triggers contains the Resources whose realtime
+	// triggers are required by the Realtime instance.
+	triggers map[*Resource]struct{}
+	// enabledMutex is the mutex protecting enabled and triggers
+	enabledMutex *sync.Mutex
+
+	// replays contains the replays of missed events
+	// running for subscribers, buffering their live messages.
+	replays map[subscriber]*replay
+	// replaysMutex is the mutex protecting replays
+	replaysMutex *sync.Mutex
+
+	// replayedSeqs contains the sequence numbers of the events
+	// replayed by the last run of ReplayMissedEvents, whose
+	// notifications may still be received after the replay.
+	replayedSeqs map[int64]struct{}
+	// replayedSeqsMutex is the mutex protecting replayedSeqs
+	replayedSeqsMutex *sync.Mutex
+
+	// pendingChanges contains the changes collected
+	// during the current CoalesceWindow.
+	pendingChanges map[subscription]*pendingChange
+	// pendingChangesMutex is the mutex protecting pendingChanges
+	pendingChangesMutex *sync.Mutex
+
 	// connectingSockets is the channel to which
 	// all sockets that have just connected are written.
 	connectingSockets chan *glue.Socket
@@ -162,8 +354,33 @@ type Realtime struct {
 	running bool
 }
 
+type CheckOriginFunc func(req *http.Request) bool
 type HandleConnectionFunc func(socket *glue.Socket, message string) bool
 type MaySubscribeFunc func(socket *glue.Socket, resource *Resource, id string) bool
+type MaySubscribeSSEFunc func(req *http.Request, resource *Resource, id string) bool
+
+// MayReceiveFunc determines whether a socket may receive a message about
+// a change to a resource instance. instance is the resource model instance
+// after the change, or nil if the instance was deleted.
+type MayReceiveFunc func(socket *glue.Socket, resource *Resource, id string, instance interface{}) bool
+
+// MayReceiveSSEFunc determines whether a Server-Sent Events client
+// may receive a message about a change to a resource instance.
+// instance is the resource model instance after the change,
+// or nil if the instance was deleted.
+type MayReceiveSSEFunc func(req *http.Request, resource *Resource, id string, instance interface{}) bool
+
+// RealtimeErrorHandlerFunc handles errors occurring
+// in a Realtime instance's background tasks.
+type RealtimeErrorHandlerFunc func(err error)
+
+// MissedEventsFunc handles the loss of database notifications
+// during a connection loss of a Realtime instance.
+type MissedEventsFunc func(r *Realtime)
+
+func defaultCheckOriginFunc(*http.Request) bool {
+	return true
+}
 
 func defaultHandleConnectionFunc(*glue.Socket, string) bool {
 	return true
@@ -173,22 +390,147 @@ func defaultMaySubscribeFunc(*glue.Socket, *Resource, string) bool {
 	return true
 }
 
+func defaultMaySubscribeSSEFunc(*http.Request, *Resource, string) bool {
+	return true
+}
+
+func defaultMayReceiveFunc(*glue.Socket, *Resource, string, interface{}) bool {
+	return true
+}
+
+func defaultMayReceiveSSEFunc(*http.Request, *Resource, string, interface{}) bool {
+	return true
+}
+
+func defaultRealtimeErrorHandlerFunc(err error) {
+	log.Printf("Realtime error: %s\n", err.Error())
+}
+
+// ResyncMissedEvents is a MissedEventsFunc sending a message
+// to all clients with active subscriptions on the resync channel,
+// instructing them to re-fetch all resources they are subscribed to.
+// It is used as a fallback by ReplayMissedEvents.
+func ResyncMissedEvents(r *Realtime) {
+	r.subscriptionsMutex.Lock()
+	var subscribers []subscriber
+	for s := range r.subscriptions {
+		if !s.closed() {
+			subscribers = append(subscribers, s)
+		}
+	}
+	r.subscriptionsMutex.Unlock()
+
+	for _, s := range subscribers {
+		r.deliver(s, nil, resyncChannelName, msgResync, 0, false)
+	}
+}
+
+// ReplayMissedEvents is a MissedEventsFunc reading all events
+// missed during the connection loss from the event log
+// and sending them to subscribed clients.
+// Events that were already received are skipped, and notifications
+// of replayed events received afterwards are ignored.
+// If the events are no longer available, it calls ResyncMissedEvents.
+func ReplayMissedEvents(r *Realtime) {
+	since := atomic.LoadInt64(&r.lastSeq)
+	if since == 0 {
+		ResyncMissedEvents(r)
+		return
+	}
+
+	events, ok, err := r.eventsSince(since)
+	if err != nil {
+		r.handleError(err)
+		ResyncMissedEvents(r)
+		return
+	}
+	if !ok {
+		ResyncMissedEvents(r)
+		return
+	}
+
+	// notifications of events replayed by
+	// previous runs are no longer expected
+	r.replayedSeqsMutex.Lock()
+	r.replayedSeqs = make(map[int64]struct{})
+	r.replayedSeqsMutex.Unlock()
+
+	for _, e := range events {
+		if e.Seq <= atomic.LoadInt64(&r.lastSeq) {
+			continue
+		}
+		r.updateLastSeq(e.Seq)
+
+		r.replayedSeqsMutex.Lock()
+		r.replayedSeqs[e.Seq] = struct{}{}
+		r.replayedSeqsMutex.Unlock()
+
+		if err := r.handleEvent(e); err != nil {
+			r.handleError(err)
+		}
+	}
+}
+
+// replayed returns whether the event with the given sequence number
+// was replayed by ReplayMissedEvents, forgetting about it,
+// as its notification is received at most once.
+func (r *Realtime) replayed(seq int64) bool {
+	r.replayedSeqsMutex.Lock()
+	defer r.replayedSeqsMutex.Unlock()
+	_, ok := r.replayedSeqs[seq]
+	delete(r.replayedSeqs, seq)
+	return ok
+}
+
+// IgnoreMissedEvents is a MissedEventsFunc doing nothing.
+func IgnoreMissedEvents(*Realtime) {}
+
 // NewRealtime returns a new Realtime instance for an Application and namespace
-// using the default HandleConnection and MaySubscribe handlers,
-// which allow all connections and subscriptions.
+// using the default CheckOrigin, HandleConnection, MaySubscribe,
+// MaySubscribeSSE, MayReceive and MayReceiveSSE handlers, which allow
+// all origins, connections, subscriptions and messages.
 func NewRealtime(app *Application, namespace string) *Realtime {
 	r := &Realtime{
 		app: app,
 
+		CheckOrigin: defaultCheckOriginFunc,
+
 		ConnectionMessageTimeout: 10 * time.Second,
 		HandleConnection:         defaultHandleConnectionFunc,
 
-		MaySubscribe: defaultMaySubscribeFunc,
+		MaySubscribe:    defaultMaySubscribeFunc,
+		MaySubscribeSSE: defaultMaySubscribeSSEFunc,
+		MayReceive:      defaultMayReceiveFunc,
+		MayReceiveSSE:   defaultMayReceiveSSEFunc,
+
+		SSEKeepAliveInterval: 30 * time.Second,
+		EventRetention:       24 * time.Hour,
+
+		ErrorHandler: defaultRealtimeErrorHandlerFunc,
+		MissedEvents: ReplayMissedEvents,
+
+		ReconnectMinBackoff: 100 * time.Millisecond,
+		ReconnectMaxBackoff: 30 * time.Second,
 
 		connectingSockets: make(chan *glue.Socket, 0),
 
-		subscriptions:      make(map[*glue.Socket]map[*Resource][]string),
+		subscriptions:      make(map[subscriber]map[*Resource]map[string]struct{}),
 		subscriptionsMutex: &sync.Mutex{},
+
+		socketValues:      make(map[*glue.Socket]map[interface{}]interface{}),
+		socketValuesMutex: &sync.Mutex{},
+
+		enabled:      make(map[*Resource]struct{}),
+		triggers:     make(map[*Resource]struct{}),
+		enabledMutex: &sync.Mutex{},
+
+		replays:      make(map[subscriber]*replay),
+		replaysMutex: &sync.Mutex{},
+
+		replayedSeqs:      make(map[int64]struct{}),
+		replayedSeqsMutex: &sync.Mutex{},
+
+		pendingChangesMutex: &sync.Mutex{},
 	}
 	r.SetNamespace(namespace)
 	return r
@@ -215,9 +557,11 @@ func (r *Realtime) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	r.Server.ServeHTTP(w, req)
 }
 
-// Bridge registers the Realtime instance with a ServeMux.
+// Bridge registers the Realtime instance with a ServeMux,
+// serving the Server-Sent Events endpoint under SSEPath.
 func (r *Realtime) Bridge(mux *http.ServeMux) {
 	mux.Handle(r.namespace, r)
+	mux.Handle(r.SSEPath(), r.SSEHandler())
 }
 
 // Run starts handling incoming requests.
@@ -227,38 +571,69 @@ func (r *Realtime) Run(ctx context.Context) error {
 		panic(errRealtimeAlreadyRunning)
 	}
 
+	if r.EventRetention <= 0 {
+		return errInvalidEventRetention
+	}
+
+	r.enabledMutex.Lock()
 	r.running = true
+	r.enabledMutex.Unlock()
+
+	// once stopped, the triggers installed by the Realtime instance
+	// are no longer considered, so they are installed again
+	// (if missing) when it is run again
+	defer func() {
+		r.enabledMutex.Lock()
+		r.running = false
+		r.triggers = make(map[*Resource]struct{})
+		r.enabledMutex.Unlock()
+	}()
 
 	// initialize glue server
 	s := glue.NewServer(glue.Options{
 		HTTPHandleURL: r.namespace,
-		CheckOrigin: func(r *http.Request) bool {
-			return true // TODO add a way to specify a CheckOrigin function?
-		},
+		CheckOrigin:   r.CheckOrigin,
 	})
 
 	s.OnNewSocket(r.onNewSocket)
 	r.Server = s
 
-	// create trigger function calling notify
+	// create event log table and trigger function calling notify
 	_, err := r.app.DB().Exec(triggerFunctionQuery)
 	if err != nil {
 		return err
 	}
 
-	// create triggers on database tables
+	// missed events are replayed starting
+	// from the most recent event in the log
+	seq, err := r.latestSeq()
+	if err != nil {
+		return err
+	}
+	atomic.StoreInt64(&r.lastSeq, seq)
+
+	// register with the application first,
+	// so resources registered in the meantime are enabled
+	r.app.addRealtime(r)
+	defer r.app.removeRealtime(r)
+
+	// enable realtime for all resources with the realtime option
+	// and create triggers on the required database tables
+	var resources []*Resource
 	for _, resource := range r.app.resources {
-		err := resource.schema.CreateRealtimeTriggers(r.app.DB(), triggerFunctionName)
-		if err != nil {
-			return err
-		}
+		resources = append(resources, resource)
+	}
+	if err := r.resourcesRegistered(resources); err != nil {
+		return err
 	}
 
 	// create notification channel
-	notificationChannel := r.app.DB().Listen(realtimeNotificationChannelName).Channel()
+	notificationChannel := make(chan string)
 
 	go r.handleConnectingSockets(ctx)
+	go r.listen(ctx, notificationChannel)
 	go r.handleRowUpdates(notificationChannel, ctx)
+	go r.pruneEvents(ctx)
 
 	// wait until context is finished and return
 	<-ctx.Done()
@@ -273,202 +648,434 @@ func (r *Realtime) handleConnectingSockets(ctx context.Context) {
 	for {
 		select {
 		case socket := <-r.connectingSockets:
-			message, err := socket.Read(r.ConnectionMessageTimeout)
-			socket.DiscardRead()
-			if err != nil {
-				if err != glue.ErrSocketClosed {
-					// no connection message received
-					socket.Write(msgConnectionTimeout)
-					socket.Close()
-				}
-				break
-			}
-			if !r.HandleConnection(socket, message) {
-				// connection disallowed
-				socket.Write(msgConnectionDisallowed)
-				socket.Close()
-				break
+			// handle each connection in its own goroutine,
+			// so waiting for the connection message or a slow
+			// HandleConnection function doesn't block other sockets
+			go r.handleConnectingSocket(socket)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// handleConnectingSocket waits for a socket's connection message
+// and accepts or rejects the connection according to HandleConnection.
+func (r *Realtime) handleConnectingSocket(socket *glue.Socket) {
+	// remove all of the socket's subscriptions
+	// and connection context values once it disconnects
+	socket.OnClose(func() {
+		r.removeSubscriptions(socketSubscriber{socket})
+		r.removeValues(socket)
+	})
+
+	message, err := socket.Read(r.ConnectionMessageTimeout)
+	socket.DiscardRead()
+	if err != nil {
+		if err != glue.ErrSocketClosed {
+			// no connection message received
+			socket.Write(msgConnectionTimeout)
+			socket.Close()
+		}
+		return
+	}
+	if !r.HandleConnection(socket, message) {
+		// connection disallowed
+		socket.Write(msgConnectionDisallowed)
+		socket.Close()
+		return
+	}
+
+	socket.Write(msgConnectionAccepted)
+	r.initSocketConnection(socket)
+}
+
+// listen receives database notifications on the realtime notification channel
+// and writes their payloads into notifications until ctx is done.
+// If the listener connection is lost, it is re-established with exponential backoff,
+// invoking the MissedEvents handler once the connection is restored.
+func (r *Realtime) listen(ctx context.Context, notifications chan<- string) {
+	backoff := r.ReconnectMinBackoff
+	reconnecting := false
+	for {
+		err := r.receiveNotifications(ctx, notifications, func() {
+			backoff = r.ReconnectMinBackoff
+			if reconnecting {
+				reconnecting = false
+				r.MissedEvents(r)
 			}
+		})
 
-			socket.Write(msgConnectionAccepted)
-			r.initSocketConnection(socket)
+		select {
 		case <-ctx.Done():
 			return
+		default:
+		}
+
+		r.handleError(fmt.Errorf("realtime notification listener failed: %s", err.Error()))
+		reconnecting = true
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+
+		backoff *= 2
+		if backoff > r.ReconnectMaxBackoff {
+			backoff = r.ReconnectMaxBackoff
+		}
+	}
+}
+
+// receiveNotifications establishes a database notification listener,
+// calling onConnected once the connection is established and writing
+// all notification payloads into notifications.
+// Returns once the listener fails or ctx is done.
+func (r *Realtime) receiveNotifications(ctx context.Context, notifications chan<- string, onConnected func()) error {
+	ln := r.app.DB().Listen(realtimeNotificationChannelName)
+	defer ln.Close()
+
+	// DB.Listen does not report connection errors,
+	// so listen again to ensure the connection is established.
+	// LISTEN is idempotent, so this has no further effect.
+	if err := ln.Listen(realtimeNotificationChannelName); err != nil {
+		return err
+	}
+	onConnected()
+
+	// close the listener once ctx is done
+	// to abort blocking receive calls
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			ln.Close()
+		case <-stop:
+		}
+	}()
+
+	for {
+		channel, payload, err := ln.Receive()
+		if err != nil {
+			return err
+		}
+		if channel != realtimeNotificationChannelName {
+			continue
+		}
+
+		select {
+		case notifications <- payload:
+		case <-ctx.Done():
+			return nil
 		}
 	}
 }
 
-func (r *Realtime) handleRowUpdates(channel <-chan *pg.Notification, ctx context.Context) {
+// handleError passes an error to the ErrorHandler.
+func (r *Realtime) handleError(err error) {
+	r.ErrorHandler(err)
+}
+
+func (r *Realtime) handleRowUpdates(channel <-chan string, ctx context.Context) {
 	for {
 		select {
-		case notification := <-channel:
-			// parse notification payload
-			payload := &notificationPayload{}
-			if err := jsoniter.Unmarshal([]byte(notification.Payload), payload); err != nil {
-				panic(err)
+		case payload := <-channel:
+			if err := r.handleNotification(payload); err != nil {
+				r.handleError(err)
 			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
 
-			// get resource type of affected table
-			var resource *Resource
-			for _, res := range r.app.resources {
-				if res.schema.Table() == payload.Table {
-					resource = res
-					break
-				}
-			}
-			if resource == nil {
-				panic("resource for table name not found")
-			}
+// handleNotification processes the payload of a database notification,
+// sending updates to all sockets subscribed to affected resources.
+func (r *Realtime) handleNotification(notification string) (err error) {
+	// parse notification payload
+	payload := &notificationPayload{}
+	if err := jsoniter.Unmarshal([]byte(notification), payload); err != nil {
+		return fmt.Errorf("invalid realtime notification payload: %s", err.Error())
+	}
 
-			// map of all resources affected by the change
-			updated := make(map[*Resource][]string)
-
-			// add all resources that were updated by the resources'
-			// relationships being modified
-			var modifiedInstance *internal.SchemaInstance
-			if payload.Type == "INSERT" || payload.Type == "DELETE" {
-				var recordPayload string
-				if payload.Type == "INSERT" {
-					recordPayload = payload.NewRecord
-				} else {
-					recordPayload = payload.OldRecord
-				}
-
-				instance, err := decodeJsonRecord(resource, recordPayload)
-				if err != nil {
-					panic(err)
-				}
-
-				// get all resources this resource was/is now
-				// related to and add them to the updated map
-				modifiedInstance = resource.schema.ParsePGModel(instance)
-				for schema, ids := range modifiedInstance.GetRelationIds() {
-					// get resource for schema
-					for _, res := range r.app.resources {
-						if res.schema == schema {
-							// convert id interface values
-							// into their string representation
-							var stringIds []string
-							for _, id := range ids {
-								stringIds = append(stringIds, internal.IdToString(id))
-							}
-							updated[res] = append(updated[res], stringIds...)
-						}
-					}
-				}
-			} else if payload.Type == "UPDATE" {
-				oldInstance, err := decodeJsonRecord(resource, payload.OldRecord)
-				if err != nil {
-					panic(err)
-				}
-				oldRelations := resource.schema.ParsePGModel(oldInstance).GetRelationIds()
-
-				newInstance, err := decodeJsonRecord(resource, payload.NewRecord)
-				if err != nil {
-					panic(err)
-				}
-				modifiedInstance = resource.schema.ParsePGModel(newInstance)
-				newRelations := modifiedInstance.GetRelationIds()
-
-				// create a changeset of all of the resources' relations
-				changeset := make(map[*internal.Schema][]interface{})
-
-				// get all relations that were removed
-				for schema, oldIds := range oldRelations {
-					newIds := newRelations[schema]
-					removed := difference(oldIds, newIds)
-					changeset[schema] = append(changeset[schema], removed...)
-				}
-
-				// get all relations that were added
-				for schema, newIds := range newRelations {
-					oldIds := oldRelations[schema]
-					added := difference(newIds, oldIds)
-					changeset[schema] = append(changeset[schema], added...)
-				}
-
-				// apply changeset to updated map
-				for schema, ids := range changeset {
-					// get resource for schema
-					for _, res := range r.app.resources {
-						if res.schema == schema {
-							// convert id interface values
-							// into their string representation
-							var stringIds []string
-							for _, id := range ids {
-								stringIds = append(stringIds, internal.IdToString(id))
-							}
-							updated[res] = stringIds
-						}
-					}
-				}
-			} else {
-				panic("unknown trigger event type")
-			}
+	// events committed while the listener was being re-established
+	// may have been replayed already
+	if r.replayed(payload.Seq) {
+		return nil
+	}
 
-			if payload.Type == "DELETE" {
-				sockets := r.subscribers(resource, payload.Id)
-				if len(sockets) > 0 {
-					sendResourceDeleted(sockets, resource, payload.Id)
-				}
-			} else {
-				// add resource to map of resources that were updated
-				updated[resource] = append(updated[resource], payload.Id)
-			}
+	// read payloads that exceeded the pg_notify size limit from the event log
+	if payload.Truncated {
+		payload, err = r.readEvent(payload.Seq)
+		if err != nil {
+			return err
+		}
+	}
 
-			// send updates for all updated resources to subscribed clients
-			for resource, ids := range updated {
-				for _, id := range ids {
-					sockets := r.subscribers(resource, id)
-					if len(sockets) > 0 {
-						// fetch updated resource instance from database
-						m, err := resource.SelectById(r.app.DB(), id).Result()
-						if err != nil {
-							panic(err)
-						}
-						instance := resource.schema.ParseResourceModel(m)
-						err = sendResourceUpdated(sockets, resource, payload.Id, instance)
-						if err != nil {
-							panic(err)
-						}
-					}
-				}
-			}
+	r.updateLastSeq(payload.Seq)
+	return r.handleEvent(payload)
+}
 
-			break
-		case <-ctx.Done():
+// handleEvent sends updates for an event
+// to all subscribers of affected resources.
+func (r *Realtime) handleEvent(e *notificationPayload) (err error) {
+	// converting ids may panic when encountering
+	// unexpected values. recover from those panics
+	// so a single malformed event does not
+	// take down the whole process.
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("error handling realtime event: %v", rec)
+		}
+	}()
+
+	changes, err := r.eventChanges(e)
+	if err != nil {
+		return err
+	}
+
+	if r.CoalesceWindow > 0 {
+		r.queueChanges(changes, e.Seq)
+		return nil
+	}
+
+	pending := make(map[subscription]*pendingChange)
+	for _, c := range changes {
+		mergeChange(pending, c, e.Seq)
+	}
+	r.sendChanges(pending, r.subscribers, false)
+	return nil
+}
+
+// updateLastSeq sets lastSeq to seq
+// if it is greater than the current value.
+func (r *Realtime) updateLastSeq(seq int64) {
+	for {
+		last := atomic.LoadInt64(&r.lastSeq)
+		if seq <= last || atomic.CompareAndSwapInt64(&r.lastSeq, last, seq) {
 			return
 		}
 	}
 }
 
-// decodeJsonRecord parses a json-encoded record into a pg model instance
-func decodeJsonRecord(resource *Resource, payload string) (interface{}, error) {
-	instance := resource.schema.NewPGModelInstance()
-	model, err := orm.NewModel(instance)
+// pendingChange is a change to a resource instance waiting to be sent.
+type pendingChange struct {
+	deleted bool
+	// seq is the sequence number of the most recent
+	// event that changed the resource instance.
+	seq int64
+}
+
+// mergeChange adds a change caused by the event with the given
+// sequence number to a set of pending changes, replacing previous
+// changes to the same resource instance.
+func mergeChange(pending map[subscription]*pendingChange, c *change, seq int64) {
+	if p, ok := pending[c.subscription]; ok && p.seq > seq {
+		return
+	}
+	pending[c.subscription] = &pendingChange{c.deleted, seq}
+}
+
+// queueChanges adds changes caused by the event with the given sequence
+// number to the pending changes, which are sent once CoalesceWindow
+// has passed since the first of them was queued.
+func (r *Realtime) queueChanges(changes []*change, seq int64) {
+	r.pendingChangesMutex.Lock()
+	defer r.pendingChangesMutex.Unlock()
+
+	if r.pendingChanges == nil {
+		r.pendingChanges = make(map[subscription]*pendingChange)
+		time.AfterFunc(r.CoalesceWindow, r.flushPendingChanges)
+	}
+	for _, c := range changes {
+		mergeChange(r.pendingChanges, c, seq)
+	}
+}
+
+// flushPendingChanges sends all pending changes to subscribers.
+func (r *Realtime) flushPendingChanges() {
+	r.pendingChangesMutex.Lock()
+	pending := r.pendingChanges
+	r.pendingChanges = nil
+	r.pendingChangesMutex.Unlock()
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			r.handleError(fmt.Errorf("error sending realtime changes: %v", rec))
+		}
+	}()
+	r.sendChanges(pending, r.subscribers, false)
+}
+
+// sendChanges sends changes to resource instances to the subscribers returned
+// by the subscribers function, ordered by sequence number.
+// replayed indicates whether the changes are part of a replay of missed events.
+// Updated instances of the same resource are fetched using a single query.
+// Errors are handled individually so a single failing
+// change does not prevent the others from being sent.
+func (r *Realtime) sendChanges(changes map[subscription]*pendingChange,
+	subscribers func(resource *Resource, id string) []subscriber, replayed bool) {
+
+	// only handle changes to resource instances with subscribers
+	subs := make(map[subscription][]subscriber)
+	updated := make(map[*Resource][]string)
+	for s, c := range changes {
+		sub := subscribers(s.resource, s.id)
+		if len(sub) == 0 {
+			continue
+		}
+		subs[s] = sub
+		if !c.deleted {
+			updated[s.resource] = append(updated[s.resource], s.id)
+		}
+	}
+
+	// fetch updated resource instances from database
+	instances := make(map[subscription]interface{})
+	for resource, ids := range updated {
+		fetched, err := r.fetchInstances(resource, ids)
+		if err != nil {
+			r.handleError(err)
+			continue
+		}
+		for id, instance := range fetched {
+			instances[subscription{resource, id}] = instance
+		}
+	}
+
+	var ordered []subscription
+	for s := range subs {
+		ordered = append(ordered, s)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		return changes[ordered[i]].seq < changes[ordered[j]].seq
+	})
+
+	for _, s := range ordered {
+		c := changes[s]
+		var err error
+		if c.deleted {
+			err = r.sendDelete(subs[s], s.resource, s.id, c.seq, replayed)
+		} else if instance, ok := instances[s]; ok {
+			err = r.sendUpdate(subs[s], s.resource, s.id, c.seq, instance, replayed)
+		}
+		// updated instances that were not fetched
+		// have been deleted in the meantime,
+		// so a deleted message follows
+		if err != nil {
+			r.handleError(err)
+		}
+	}
+}
+
+// fetchInstances fetches the instances of a resource
+// with the given ids from the database, mapped by their
+// id's string representation, matching the ids of subscriptions.
+func (r *Realtime) fetchInstances(resource *Resource, ids []string) (map[string]interface{}, error) {
+	values := make([]interface{}, len(ids))
+	for i, id := range ids {
+		values[i] = id
+	}
+
+	column := escapePGColumn(resource.schema.IdField().PGFilterColumn())
+	res, err := resource.Select(r.app.DB()).
+		WhereIn(fmt.Sprintf("%s IN (?)", column), values...).
+		Result()
+	if err != nil {
+		return nil, fmt.Errorf(`error fetching updated resources "%s": %s`,
+			resource.JSONAPIName(), err.Error())
+	}
+
+	instances := make(map[string]interface{})
+	for _, instance := range resource.schema.ParseResourceModelCollection(res) {
+		instances[internal.IdToString(instance.Id())] = instance.ToResourceModel()
+	}
+	return instances, nil
+}
+
+// sendUpdate sends an updated resource instance to subscribers
+// as part of the event with the given sequence number.
+func (r *Realtime) sendUpdate(subscribers []subscriber, resource *Resource, id string, seq int64,
+	instance interface{}, replayed bool) error {
+
+	message, err := resourceUpdatedMessage(resource, id, seq, resource.schema.ParseResourceModel(instance))
+	if err != nil {
+		return err
+	}
+	for _, s := range subscribers {
+		if !s.mayReceive(r, resource, id, instance) {
+			r.revoke(s, resource, id)
+			continue
+		}
+		r.deliver(s, &subscription{resource, id}, updatedChannelName, message, seq, replayed)
+	}
+	return nil
+}
+
+// sendDelete notifies subscribers about the deletion of a resource instance
+// as part of the event with the given sequence number.
+func (r *Realtime) sendDelete(subscribers []subscriber, resource *Resource, id string, seq int64, replayed bool) error {
+	message, err := resourceDeletedMessage(resource, id, seq)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	for _, s := range subscribers {
+		if !s.mayReceive(r, resource, id, nil) {
+			r.revoke(s, resource, id)
+			continue
+		}
+		r.deliver(s, &subscription{resource, id}, deletedChannelName, message, seq, replayed)
 	}
+	return nil
+}
+
+// Revoke removes a socket's subscription to a resource instance,
+// sending a message on the revoked channel to notify the client.
+// Returns false if the socket was not subscribed to the resource instance.
+func (r *Realtime) Revoke(socket *glue.Socket, resource *Resource, id string) bool {
+	if normalized, ok := resource.schema.NormalizeId(id); ok {
+		id = normalized
+	}
+	return r.revoke(socketSubscriber{socket}, resource, id)
+}
 
-	it := jsoniter.ParseString(jsoniter.ConfigDefault, payload)
-	record := it.ReadAny()
-	for i, key := range record.Keys() {
-		model.ScanColumn(i, key, []byte(record.Get(key).ToString()))
+// revoke removes a subscriber's subscription to a resource instance,
+// sending a message on the revoked channel to notify the client.
+// Returns false if the subscriber was not subscribed to the resource instance.
+func (r *Realtime) revoke(sub subscriber, resource *Resource, id string) bool {
+	if !r.removeSubscription(sub, resource, id) {
+		return false
 	}
 
-	return instance, nil
+	message, err := resourceRevokedMessage(resource, id)
+	if err != nil {
+		r.handleError(err)
+		return true
+	}
+	r.deliver(sub, &subscription{resource, id}, revokedChannelName, message, 0, false)
+	return true
 }
 
 func (r *Realtime) initSocketConnection(socket *glue.Socket) {
 	subscribeChannel := socket.Channel(subscribeChannelName)
 	subscribeChannel.OnRead(cement.Glue(subscribeChannel, r.onSubscribeRead))
+
+	unsubscribeChannel := socket.Channel(unsubscribeChannelName)
+	unsubscribeChannel.OnRead(cement.Glue(unsubscribeChannel, r.onUnsubscribeRead))
 }
 
-func (r *Realtime) onSubscribeRead(socket *glue.Socket, messageId string, data string) (int, string) {
+// parseSubscribePayload parses a subscribePayload,
+// returning the Resource it refers to.
+// The payload's id is normalized to the representation
+// used in realtime events, e.g. "1" for "01" on integer ids.
+// If the payload is invalid, it returns a cement error message
+// that should be sent to the client.
+func (r *Realtime) parseSubscribePayload(data string) (*subscribePayload, *Resource, string) {
 	payload := &subscribePayload{}
 	err := json.Unmarshal([]byte(data), payload)
 	if err != nil {
-		return cement.CodeError, cement.MsgInvalidPayload
+		return nil, nil, cement.MsgInvalidPayload
 	}
 
 	// get resource with from application's registry
@@ -479,8 +1086,23 @@ func (r *Realtime) onSubscribeRead(socket *glue.Socket, messageId string, data s
 			break
 		}
 	}
-	if resource == nil {
-		return cement.CodeError, msgInvalidResource
+	if resource == nil || !r.Enabled(resource) {
+		return nil, nil, msgInvalidResource
+	}
+
+	id, ok := resource.schema.NormalizeId(payload.Id)
+	if !ok {
+		return nil, nil, msgInvalidId
+	}
+	payload.Id = id
+
+	return payload, resource, ""
+}
+
+func (r *Realtime) onSubscribeRead(socket *glue.Socket, messageId string, data string) (int, string) {
+	payload, resource, msg := r.parseSubscribePayload(data)
+	if payload == nil {
+		return cement.CodeError, msg
 	}
 
 	// call MaySubscribe hook
@@ -488,78 +1110,217 @@ func (r *Realtime) onSubscribeRead(socket *glue.Socket, messageId string, data s
 		return cement.CodeError, msgAccessDenied
 	}
 
+	sub := socketSubscriber{socket}
+	if payload.Since > 0 {
+		// buffer live messages until missed events were replayed
+		r.startReplay(sub)
+	}
+
 	// subscribe client to resource
+	if !r.addSubscription(sub, resource, payload.Id) {
+		// the socket was closed while
+		// processing the subscription
+		if payload.Since > 0 {
+			r.finishReplay(sub, nil, 0)
+		}
+		return cement.CodeError, msgAccessDenied
+	}
+
+	if payload.Since > 0 {
+		// send missed events after the response.
+		// live messages are sent once the replay finished.
+		go r.replayEvents(sub, []*subscription{{resource, payload.Id}}, payload.Since)
+	}
+
+	return cement.CodeOk, msgOk
+}
+
+func (r *Realtime) onUnsubscribeRead(socket *glue.Socket, messageId string, data string) (int, string) {
+	payload, resource, msg := r.parseSubscribePayload(data)
+	if payload == nil {
+		return cement.CodeError, msg
+	}
+
+	if !r.removeSubscription(socketSubscriber{socket}, resource, payload.Id) {
+		return cement.CodeError, msgNotSubscribed
+	}
+
+	return cement.CodeOk, msgOk
+}
+
+// subscription is a subscription to a resource instance.
+type subscription struct {
+	resource *Resource
+	id       string
+}
+
+// subscriber is a client that is able
+// to subscribe to resource instances.
+type subscriber interface {
+	// send sends a message on a channel.
+	// seq is the sequence number of the event the message belongs to,
+	// or 0 if the message does not belong to an event.
+	send(channel string, message string, seq int64)
+	// closed returns whether the subscriber's connection is closed.
+	closed() bool
+	// mayReceive returns whether the subscriber may receive
+	// a message about a change to a resource instance.
+	mayReceive(r *Realtime, resource *Resource, id string, instance interface{}) bool
+}
+
+// socketSubscriber is a subscriber connected via a glue socket.
+type socketSubscriber struct {
+	socket *glue.Socket
+}
+
+func (s socketSubscriber) send(channel string, message string, seq int64) {
+	c := s.socket.Channel(channel)
+	c.DiscardRead()
+	c.Write(message)
+}
+
+func (s socketSubscriber) closed() bool {
+	return s.socket.IsClosed()
+}
+
+func (s socketSubscriber) mayReceive(r *Realtime, resource *Resource, id string, instance interface{}) bool {
+	return r.MayReceive(s.socket, resource, id, instance)
+}
+
+// addSubscription subscribes a subscriber to a resource instance.
+// Returns false if the subscriber is already closed.
+func (r *Realtime) addSubscription(sub subscriber, resource *Resource, id string) bool {
 	r.subscriptionsMutex.Lock()
-	s, ok := r.subscriptions[socket]
+	defer r.subscriptionsMutex.Unlock()
+
+	if sub.closed() {
+		return false
+	}
+
+	s, ok := r.subscriptions[sub]
 	if !ok {
-		s = make(map[*Resource][]string)
-		r.subscriptions[socket] = s
+		s = make(map[*Resource]map[string]struct{})
+		r.subscriptions[sub] = s
 	}
 
-	s[resource] = append(s[resource], payload.Id)
-	r.subscriptionsMutex.Unlock()
+	ids, ok := s[resource]
+	if !ok {
+		ids = make(map[string]struct{})
+		s[resource] = ids
+	}
 
-	return cement.CodeOk, msgOk
+	// subscribing to the same id multiple times
+	// has no effect, as ids are stored as a set
+	ids[id] = struct{}{}
+	return true
 }
 
-// subscribers returns all sockets that are subscribed to a resource instance.
-func (r *Realtime) subscribers(resource *Resource, id string) []*glue.Socket {
-	var sockets []*glue.Socket
+// removeSubscription removes a subscriber's subscription to a resource instance.
+// Returns false if the subscriber was not subscribed to the resource instance.
+func (r *Realtime) removeSubscription(sub subscriber, resource *Resource, id string) bool {
 	r.subscriptionsMutex.Lock()
-	for socket, subscriptions := range r.subscriptions {
-		for _, i := range subscriptions[resource] {
-			if id == i {
-				sockets = append(sockets, socket)
-			}
+	defer r.subscriptionsMutex.Unlock()
+
+	s, ok := r.subscriptions[sub]
+	if !ok {
+		return false
+	}
+	ids, ok := s[resource]
+	if !ok {
+		return false
+	}
+	if _, ok := ids[id]; !ok {
+		return false
+	}
+
+	// clean up empty maps so
+	// they don't accumulate over time
+	delete(ids, id)
+	if len(ids) == 0 {
+		delete(s, resource)
+	}
+	if len(s) == 0 {
+		delete(r.subscriptions, sub)
+	}
+
+	return true
+}
+
+// removeSubscriptions removes all of a subscriber's subscriptions.
+func (r *Realtime) removeSubscriptions(sub subscriber) {
+	r.subscriptionsMutex.Lock()
+	delete(r.subscriptions, sub)
+	r.subscriptionsMutex.Unlock()
+}
+
+// subscribers returns all subscribers that are subscribed to a resource instance.
+func (r *Realtime) subscribers(resource *Resource, id string) []subscriber {
+	var subscribers []subscriber
+	r.subscriptionsMutex.Lock()
+	for s, subscriptions := range r.subscriptions {
+		// skip subscribers that were closed,
+		// but not yet removed by their close handler
+		if s.closed() {
+			continue
+		}
+		if _, ok := subscriptions[resource][id]; ok {
+			subscribers = append(subscribers, s)
 		}
 	}
 	r.subscriptionsMutex.Unlock()
-	return sockets
+	return subscribers
 }
 
-func sendResourceDeleted(sockets []*glue.Socket, resource *Resource, id string) error {
+// resourceDeletedMessage returns the message to send
+// to clients when a resource instance was deleted.
+func resourceDeletedMessage(resource *Resource, id string, seq int64) (string, error) {
 	b, err := jsoniter.ConfigDefault.Marshal(&resourceDeletedPayload{
 		Model: resource.JSONAPIName(),
 		Id:    id,
+		Seq:   seq,
 	})
 	if err != nil {
-		return err
+		return "", err
 	}
-	str := string(b)
-	for _, socket := range sockets {
-		channel := socket.Channel(deletedChannelName)
-		channel.DiscardRead()
-		channel.Write(str)
+	return string(b), nil
+}
+
+// resourceRevokedMessage returns the message to send
+// to clients when their subscription to a resource instance was revoked.
+func resourceRevokedMessage(resource *Resource, id string) (string, error) {
+	b, err := jsoniter.ConfigDefault.Marshal(&resourceRevokedPayload{
+		Model: resource.JSONAPIName(),
+		Id:    id,
+	})
+	if err != nil {
+		return "", err
 	}
-	return nil
+	return string(b), nil
 }
 
-func sendResourceUpdated(sockets []*glue.Socket, resource *Resource, id string, instance *internal.SchemaInstance) error {
+// resourceUpdatedMessage returns the message to send
+// to clients when a resource instance was inserted or updated.
+func resourceUpdatedMessage(resource *Resource, id string, seq int64, instance *internal.SchemaInstance) (string, error) {
 	p, err := jsonapi.Marshal(instance.ToJsonapiModel())
 	if err != nil {
-		return err
+		return "", err
 	}
 	payload := p.(*jsonapi.OnePayload)
 	payload.Included = nil
 
 	resourceBytes, err := jsoniter.ConfigDefault.Marshal(p)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	b, err := jsoniter.ConfigDefault.Marshal(&resourceUpdatedPayload{
 		Model:   resource.JSONAPIName(),
 		Id:      id,
+		Seq:     seq,
 		Payload: string(resourceBytes),
 	})
 	if err != nil {
-		return err
+		return "", err
 	}
-	str := string(b)
-	for _, socket := range sockets {
-		channel := socket.Channel(updatedChannelName)
-		channel.DiscardRead()
-		channel.Write(str)
-	}
-	return nil
+	return string(b), nil
 }
@@ -0,0 +1,482 @@
+// Package client implements a client for
+// the jargo Realtime websocket protocol.
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/crushedpixel/cement"
+	"github.com/crushedpixel/jargo"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrConnectionTimeout    = errors.New("connection message timed out")
+	ErrConnectionDisallowed = errors.New("connection disallowed")
+	ErrInvalidResource      = errors.New("invalid resource")
+	ErrInvalidId            = errors.New("invalid id")
+	ErrAccessDenied         = errors.New("access denied")
+	ErrNotSubscribed        = errors.New("not subscribed")
+	ErrClosed               = errors.New("client is closed")
+	ErrTimeout              = errors.New("request timed out")
+	ErrEventBufferFull      = errors.New("event buffer is full")
+)
+
+// EventType is the type of an Event.
+type EventType int
+
+const (
+	// Updated events are received when a resource
+	// instance was inserted or updated.
+	Updated EventType = iota
+	// Deleted events are received when a resource instance was deleted.
+	Deleted
+	// Revoked events are received when the server
+	// revoked a subscription to a resource instance.
+	Revoked
+	// Resync events are received when the server
+	// was unable to deliver all events.
+	// All subscribed resource instances should be re-fetched.
+	Resync
+)
+
+// Event is an event received from a Realtime server.
+type Event struct {
+	Type EventType
+	// Resource is the Resource of the changed instance.
+	// Not set for Resync events.
+	Resource *jargo.Resource
+	// Id is the id of the changed instance.
+	// Not set for Resync events.
+	Id string
+	// Seq is the sequence number of the event,
+	// which can be used to resume after reconnecting.
+	// Only set for Updated and Deleted events.
+	Seq int64
+	// Instance is the Resource Model Instance after the change.
+	// Only set for Updated events.
+	Instance interface{}
+}
+
+// Options is used to configure a Client when dialing.
+type Options struct {
+	// Resources are the Resources the client
+	// is able to subscribe to.
+	Resources []*jargo.Resource
+
+	// ConnectionMessage is the message sent
+	// after connecting, which is passed to the
+	// server's HandleConnection function.
+	ConnectionMessage string
+
+	// Header is sent with the websocket handshake request.
+	Header http.Header
+
+	// Timeout is the time to wait for the server
+	// to respond to the connection message and requests.
+	// Defaults to 10s.
+	Timeout time.Duration
+
+	// EventBufferSize is the number of events that are
+	// buffered if they are not read from Events in time.
+	// If the buffer is full when an event is received,
+	// the client is closed with ErrEventBufferFull.
+	// Defaults to 256.
+	EventBufferSize int
+}
+
+func (o *Options) setDefaults() {
+	if o.Timeout == 0 {
+		o.Timeout = 10 * time.Second
+	}
+	if o.EventBufferSize == 0 {
+		o.EventBufferSize = 256
+	}
+}
+
+// response is the response to a request.
+type response struct {
+	code int
+	data string
+}
+
+// Client is a connection to a Realtime server.
+type Client struct {
+	conn    *websocket.Conn
+	timeout time.Duration
+
+	// resources contains the Resources the client
+	// is able to subscribe to by JSON API name.
+	resources map[string]*jargo.Resource
+
+	// writeMutex serializes writes to conn.
+	writeMutex *sync.Mutex
+
+	// requestsMutex protects all variables below.
+	requestsMutex *sync.Mutex
+	// nextRequestId is the message id of the next request.
+	nextRequestId uint64
+	// requests contains the response channels
+	// of pending requests by message id.
+	requests map[string]chan *response
+
+	events chan *Event
+
+	done      chan struct{}
+	closeOnce *sync.Once
+	// err is the error that caused the connection to close.
+	// Only valid after done was closed.
+	err error
+}
+
+// Dial connects to a Realtime server at the given url,
+// which is the Realtime namespace, e.g. "ws://localhost/realtime/",
+// and waits for the connection message to be accepted.
+func Dial(url string, options Options) (*Client, error) {
+	options.setDefaults()
+
+	conn, _, err := websocket.DefaultDialer.Dial(strings.TrimSuffix(url, "/")+"/"+gluePath, options.Header)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		conn:    conn,
+		timeout: options.Timeout,
+
+		resources: make(map[string]*jargo.Resource),
+
+		writeMutex: new(sync.Mutex),
+
+		requestsMutex: new(sync.Mutex),
+		requests:      make(map[string]chan *response),
+
+		events: make(chan *Event, options.EventBufferSize),
+
+		done:      make(chan struct{}),
+		closeOnce: new(sync.Once),
+	}
+	for _, r := range options.Resources {
+		c.resources[r.JSONAPIName()] = r
+	}
+
+	if err := c.handshake(options.ConnectionMessage); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	go c.readLoop()
+	return c, nil
+}
+
+// handshake initializes the glue socket and sends the connection message,
+// waiting for the server to accept it.
+func (c *Client) handshake(connectionMessage string) error {
+	c.conn.SetReadDeadline(time.Now().Add(c.timeout))
+	defer c.conn.SetReadDeadline(time.Time{})
+
+	init, err := json.Marshal(&glueInitPayload{Version: glueVersion})
+	if err != nil {
+		return err
+	}
+	if err := c.write(cmdInit + string(init)); err != nil {
+		return err
+	}
+	if _, err := c.readCommand(cmdInit); err != nil {
+		return err
+	}
+
+	if err := c.write(encodeChannelData(mainChannelName, connectionMessage)); err != nil {
+		return err
+	}
+	for {
+		data, err := c.readCommand(cmdChannelData)
+		if err != nil {
+			return err
+		}
+		channel, message, err := unmarshalValues(data)
+		if err != nil {
+			return err
+		}
+		if channel != mainChannelName {
+			continue
+		}
+
+		switch message {
+		case msgConnectionAccepted:
+			return nil
+		case msgConnectionTimeout:
+			return ErrConnectionTimeout
+		case msgConnectionDisallowed:
+			return ErrConnectionDisallowed
+		default:
+			return fmt.Errorf(`unexpected connection response "%s"`, message)
+		}
+	}
+}
+
+// readCommand reads messages until a message with the given command
+// is received, returning its data. Pings are answered.
+func (c *Client) readCommand(command string) (string, error) {
+	for {
+		cmd, data, err := c.read()
+		if err != nil {
+			return "", err
+		}
+		switch cmd {
+		case command:
+			return data, nil
+		case cmdPing:
+			if err := c.write(cmdPong); err != nil {
+				return "", err
+			}
+		case cmdClose, cmdInvalid:
+			return "", ErrClosed
+		}
+	}
+}
+
+// read reads the next message, returning its command and data.
+func (c *Client) read() (string, string, error) {
+	_, b, err := c.conn.ReadMessage()
+	if err != nil {
+		return "", "", err
+	}
+	message := string(b)
+	if len(message) < cmdLen {
+		return "", "", errInvalidMessage
+	}
+	return message[:cmdLen], message[cmdLen:], nil
+}
+
+// write writes a message.
+func (c *Client) write(message string) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	return c.conn.WriteMessage(websocket.TextMessage, []byte(message))
+}
+
+// readLoop reads messages until the connection is closed,
+// dispatching responses and events. It never blocks on the event
+// buffer, so responses are received regardless of unread events.
+func (c *Client) readLoop() {
+	for {
+		data, err := c.readCommand(cmdChannelData)
+		if err != nil {
+			c.close(err)
+			return
+		}
+
+		channel, message, err := unmarshalValues(data)
+		if err != nil {
+			c.close(err)
+			return
+		}
+
+		switch channel {
+		case subscribeChannelName, unsubscribeChannelName:
+			c.handleResponse(message)
+		case updatedChannelName, deletedChannelName, revokedChannelName, resyncChannelName:
+			e, err := c.decodeEvent(channel, message)
+			if err != nil {
+				c.close(err)
+				return
+			}
+			select {
+			case c.events <- e:
+			default:
+				// events are not read from Events.
+				// close the client instead of dropping events,
+				// so it can resubscribe to resync its state.
+				c.close(ErrEventBufferFull)
+				return
+			}
+		}
+	}
+}
+
+// handleResponse passes a response to the pending request it belongs to.
+func (c *Client) handleResponse(message string) {
+	messageId, code, data, err := decodeResponse(message)
+	if err != nil {
+		return
+	}
+
+	c.requestsMutex.Lock()
+	ch, ok := c.requests[messageId]
+	delete(c.requests, messageId)
+	c.requestsMutex.Unlock()
+
+	if ok {
+		ch <- &response{code, data}
+	}
+}
+
+// decodeEvent decodes a message received on an event channel.
+func (c *Client) decodeEvent(channel string, message string) (*Event, error) {
+	if channel == resyncChannelName {
+		return &Event{Type: Resync}, nil
+	}
+
+	payload := &resourcePayload{}
+	if err := json.Unmarshal([]byte(message), payload); err != nil {
+		return nil, err
+	}
+	resource, ok := c.resources[payload.Model]
+	if !ok {
+		return nil, fmt.Errorf(`received event for unknown resource "%s"`, payload.Model)
+	}
+
+	e := &Event{
+		Resource: resource,
+		Id:       payload.Id,
+		Seq:      payload.Seq,
+	}
+	switch channel {
+	case updatedChannelName:
+		instance, err := resource.ParseJsonapiPayloadString(payload.Payload, nil, false)
+		if err != nil {
+			return nil, err
+		}
+		e.Type = Updated
+		e.Instance = instance
+	case deletedChannelName:
+		e.Type = Deleted
+	case revokedChannelName:
+		e.Type = Revoked
+	}
+	return e, nil
+}
+
+// request sends a request on a channel and waits for the response.
+func (c *Client) request(channel string, data string) (*response, error) {
+	ch := make(chan *response, 1)
+
+	c.requestsMutex.Lock()
+	c.nextRequestId++
+	messageId := strconv.FormatUint(c.nextRequestId, 10)
+	c.requests[messageId] = ch
+	c.requestsMutex.Unlock()
+
+	defer func() {
+		c.requestsMutex.Lock()
+		delete(c.requests, messageId)
+		c.requestsMutex.Unlock()
+	}()
+
+	if err := c.write(encodeChannelData(channel, encodeRequest(messageId, data))); err != nil {
+		return nil, err
+	}
+
+	select {
+	case res := <-ch:
+		return res, nil
+	case <-c.done:
+		return nil, ErrClosed
+	case <-time.After(c.timeout):
+		return nil, ErrTimeout
+	}
+}
+
+// subscriptionRequest sends a subscribe or unsubscribe request,
+// returning an error if the server rejected it.
+func (c *Client) subscriptionRequest(channel string, payload *subscribePayload) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.request(channel, string(b))
+	if err != nil {
+		return err
+	}
+	if res.code == cement.CodeOk {
+		return nil
+	}
+
+	e := &errorPayload{}
+	if err := json.Unmarshal([]byte(res.data), e); err != nil {
+		return fmt.Errorf("request failed: %s", res.data)
+	}
+	switch e.Error {
+	case errInvalidResource:
+		return ErrInvalidResource
+	case errInvalidId:
+		return ErrInvalidId
+	case errAccessDenied:
+		return ErrAccessDenied
+	case errNotSubscribed:
+		return ErrNotSubscribed
+	default:
+		return fmt.Errorf("request failed: %s", e.Error)
+	}
+}
+
+// Subscribe subscribes to a resource instance.
+func (c *Client) Subscribe(resource *jargo.Resource, id string) error {
+	return c.SubscribeSince(resource, id, 0)
+}
+
+// SubscribeSince subscribes to a resource instance,
+// receiving all events missed since the event with the given
+// sequence number, or a Resync event if they are no longer available.
+func (c *Client) SubscribeSince(resource *jargo.Resource, id string, seq int64) error {
+	return c.subscriptionRequest(subscribeChannelName, &subscribePayload{
+		Model: resource.JSONAPIName(),
+		Id:    id,
+		Since: seq,
+	})
+}
+
+// Unsubscribe unsubscribes from a resource instance.
+func (c *Client) Unsubscribe(resource *jargo.Resource, id string) error {
+	return c.subscriptionRequest(unsubscribeChannelName, &subscribePayload{
+		Model: resource.JSONAPIName(),
+		Id:    id,
+	})
+}
+
+// Events returns the channel events are received on.
+// It has to be drained continuously, as the client is closed
+// with ErrEventBufferFull once EventBufferSize events are unread.
+// It is not closed when the client is closed, use Done instead.
+func (c *Client) Events() <-chan *Event {
+	return c.events
+}
+
+// Done returns a channel that is closed once the client is closed.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the error that caused the client to close,
+// or nil if it is still open.
+func (c *Client) Err() error {
+	select {
+	case <-c.done:
+		return c.err
+	default:
+		return nil
+	}
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	c.write(cmdClose)
+	c.close(ErrClosed)
+	return nil
+}
+
+func (c *Client) close(err error) {
+	c.closeOnce.Do(func() {
+		c.err = err
+		close(c.done)
+		c.conn.Close()
+	})
+}
@@ -0,0 +1,136 @@
+package client
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// glue protocol constants.
+// Every message starts with a two character command.
+const (
+	// gluePath is the path relative to the Realtime namespace
+	// under which glue accepts websocket connections.
+	gluePath = "ws"
+
+	// glueVersion is the glue client version
+	// sent in the init message.
+	glueVersion = "1.9.1"
+
+	cmdLen         = 2
+	cmdInit        = "in"
+	cmdPing        = "pi"
+	cmdPong        = "po"
+	cmdClose       = "cl"
+	cmdInvalid     = "iv"
+	cmdChannelData = "cd"
+
+	// mainChannelName is the channel used by glue's
+	// Socket.Read and Socket.Write methods.
+	mainChannelName = "m"
+)
+
+// Realtime protocol constants,
+// as defined in the jargo package.
+const (
+	msgConnectionTimeout    = "CONNECTION_TIMEOUT"
+	msgConnectionDisallowed = "CONNECTION_DISALLOWED"
+	msgConnectionAccepted   = "CONNECTION_ACCEPTED"
+
+	subscribeChannelName   = "subscribe"
+	unsubscribeChannelName = "unsubscribe"
+	deletedChannelName     = "deleted"
+	updatedChannelName     = "updated"
+	resyncChannelName      = "resync"
+	revokedChannelName     = "revoked"
+
+	errInvalidResource = "INVALID_RESOURCE"
+	errInvalidId       = "INVALID_ID"
+	errAccessDenied    = "ACCESS_DENIED"
+	errNotSubscribed   = "NOT_SUBSCRIBED"
+)
+
+var errInvalidMessage = errors.New("invalid message")
+
+// glueInitPayload is the payload of the init message
+// sent by the client after connecting.
+type glueInitPayload struct {
+	Version string `json:"version"`
+}
+
+// subscribePayload is the payload sent
+// when subscribing to or unsubscribing from
+// a resource instance.
+type subscribePayload struct {
+	Model string `json:"model"`
+	Id    string `json:"id"`
+	Since int64  `json:"since,omitempty"`
+}
+
+// errorPayload is the payload of
+// failed subscribe and unsubscribe requests.
+type errorPayload struct {
+	Error string `json:"error"`
+}
+
+// resourcePayload is the payload of
+// updated, deleted and revoked messages.
+type resourcePayload struct {
+	Model   string `json:"model"`
+	Id      string `json:"id"`
+	Seq     int64  `json:"seq"`
+	Payload string `json:"payload"`
+}
+
+// marshalValues encodes two values into a single string
+// the way glue does, prefixing them with the length of the first value.
+func marshalValues(first string, second string) string {
+	return strconv.Itoa(len(first)) + "&" + first + second
+}
+
+// unmarshalValues decodes a string encoded by marshalValues.
+func unmarshalValues(data string) (string, string, error) {
+	pos := strings.Index(data, "&")
+	if pos < 0 {
+		return "", "", errInvalidMessage
+	}
+	l, err := strconv.Atoi(data[:pos])
+	if err != nil || l < 0 {
+		return "", "", errInvalidMessage
+	}
+	data = data[pos+1:]
+	if l > len(data) {
+		return "", "", errInvalidMessage
+	}
+	return data[:l], data[l:], nil
+}
+
+// encodeChannelData encodes a glue message
+// sending data on a channel.
+func encodeChannelData(channel string, data string) string {
+	return cmdChannelData + marshalValues(channel, data)
+}
+
+// encodeRequest encodes a cement request
+// with the given message id and data.
+func encodeRequest(messageId string, data string) string {
+	return marshalValues(messageId, data)
+}
+
+// decodeResponse decodes a cement response,
+// returning its message id, code and data.
+func decodeResponse(message string) (string, int, string, error) {
+	messageId, rest, err := unmarshalValues(message)
+	if err != nil {
+		return "", 0, "", err
+	}
+	c, data, err := unmarshalValues(rest)
+	if err != nil {
+		return "", 0, "", err
+	}
+	code, err := strconv.Atoi(c)
+	if err != nil {
+		return "", 0, "", errInvalidMessage
+	}
+	return messageId, code, data, nil
+}
@@ -0,0 +1,98 @@
+package jargo
+
+import (
+	"errors"
+	"github.com/desertbit/glue"
+	"strings"
+)
+
+const bearerTokenPrefix = "Bearer "
+
+var errInvalidBearerToken = errors.New(`connection message must be of the form "Bearer <token>"`)
+
+// identityKey is the connection context key
+// under which a socket's identity is stored.
+type identityKey struct{}
+
+// SetValue stores a connection context value for a socket,
+// which can be retrieved using Value, e.g. in MaySubscribe.
+// Values are removed once the socket is closed.
+func (r *Realtime) SetValue(socket *glue.Socket, key interface{}, value interface{}) {
+	r.socketValuesMutex.Lock()
+	defer r.socketValuesMutex.Unlock()
+
+	// the socket may have been closed
+	// while its connection message was handled
+	if socket.IsClosed() {
+		return
+	}
+
+	values, ok := r.socketValues[socket]
+	if !ok {
+		values = make(map[interface{}]interface{})
+		r.socketValues[socket] = values
+	}
+	values[key] = value
+}
+
+// Value returns the connection context value stored for a socket
+// under the given key. Returns nil if there is no such value.
+func (r *Realtime) Value(socket *glue.Socket, key interface{}) interface{} {
+	r.socketValuesMutex.Lock()
+	defer r.socketValuesMutex.Unlock()
+
+	return r.socketValues[socket][key]
+}
+
+// removeValues removes all connection context values stored for a socket.
+func (r *Realtime) removeValues(socket *glue.Socket) {
+	r.socketValuesMutex.Lock()
+	delete(r.socketValues, socket)
+	r.socketValuesMutex.Unlock()
+}
+
+// Identity returns the identity stored for a socket
+// by the HandleConnectionFunc returned by BearerTokenHandshake.
+// Returns nil if no identity is stored for the socket.
+func (r *Realtime) Identity(socket *glue.Socket) interface{} {
+	return r.Value(socket, identityKey{})
+}
+
+// TokenVerifierFunc verifies a bearer token, returning the identity
+// associated with it. If the token is invalid, it returns an error.
+type TokenVerifierFunc func(token string) (identity interface{}, err error)
+
+// BearerTokenHandshake returns a HandleConnectionFunc expecting
+// the connection message to be of the form "Bearer <token>".
+// The token is validated using verify before the connection is accepted,
+// and the identity returned by verify is stored for the socket,
+// so it can be retrieved using Identity.
+func (r *Realtime) BearerTokenHandshake(verify TokenVerifierFunc) HandleConnectionFunc {
+	return func(socket *glue.Socket, message string) bool {
+		token, err := parseBearerToken(message)
+		if err != nil {
+			return false
+		}
+
+		identity, err := verify(token)
+		if err != nil {
+			return false
+		}
+
+		r.SetValue(socket, identityKey{}, identity)
+		return true
+	}
+}
+
+// parseBearerToken extracts the token from a
+// connection message of the form "Bearer <token>".
+func parseBearerToken(message string) (string, error) {
+	if !strings.HasPrefix(message, bearerTokenPrefix) {
+		return "", errInvalidBearerToken
+	}
+	token := strings.TrimSpace(message[len(bearerTokenPrefix):])
+	if token == "" {
+		return "", errInvalidBearerToken
+	}
+	return token, nil
+}
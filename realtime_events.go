@@ -0,0 +1,268 @@
+package jargo
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-pg/pg"
+	"time"
+)
+
+// change is a change to a resource instance caused by an event.
+type change struct {
+	subscription
+	deleted bool
+}
+
+// eventChanges returns the changes to resource instances caused by an event.
+func (r *Realtime) eventChanges(e *notificationPayload) ([]*change, error) {
+	if e.Type != "INSERT" && e.Type != "UPDATE" && e.Type != "DELETE" {
+		return nil, fmt.Errorf(`unknown realtime trigger event type "%s"`, e.Type)
+	}
+
+	// get resource type of affected table
+	var resource *Resource
+	for _, res := range r.app.resources {
+		if res.schema.Table() == e.Table {
+			resource = res
+			break
+		}
+	}
+	if resource == nil {
+		return nil, fmt.Errorf(`received realtime event for unknown table "%s"`, e.Table)
+	}
+
+	// triggers are left installed when realtime is disabled for a resource,
+	// so events for resources realtime isn't enabled for are ignored
+	var changes []*change
+	if r.Enabled(resource) {
+		changes = append(changes, &change{subscription{resource, e.Id}, e.Type == "DELETE"})
+	}
+
+	// add all resources that were updated by the resources'
+	// relationships being modified. the event only contains
+	// relation id columns whose values were changed, so all resources
+	// referenced before and after the change are affected.
+	relations := resource.schema.BelongsToColumns()
+	for _, changed := range []map[string]string{e.OldRelations, e.NewRelations} {
+		for column, id := range changed {
+			schema, ok := relations[column]
+			if !ok {
+				return nil, fmt.Errorf(`unknown relation column "%s" in realtime event for table "%s"`,
+					column, e.Table)
+			}
+			// get resource for schema
+			if res, ok := r.app.resources[schema]; ok && r.Enabled(res) {
+				changes = append(changes, &change{subscription{res, id}, false})
+			}
+		}
+	}
+
+	return changes, nil
+}
+
+// readEvent reads the event with the given sequence number from the event log.
+func (r *Realtime) readEvent(seq int64) (*notificationPayload, error) {
+	e := &notificationPayload{}
+	_, err := r.app.DB().QueryOne(e, fmt.Sprintf(
+		`SELECT seq, table_name, row_id, type, old_relations, new_relations FROM "%s" WHERE seq = ?`,
+		realtimeEventsTableName), seq)
+	if err != nil {
+		return nil, fmt.Errorf("error reading realtime event %d: %s", seq, err.Error())
+	}
+	return e, nil
+}
+
+// latestSeq returns the sequence number of the most recent event
+// in the event log, or 0 if the event log is empty.
+func (r *Realtime) latestSeq() (int64, error) {
+	var seq int64
+	_, err := r.app.DB().QueryOne(pg.Scan(&seq), fmt.Sprintf(
+		`SELECT COALESCE(MAX(seq), 0) FROM "%s"`, realtimeEventsTableName))
+	if err != nil {
+		return 0, fmt.Errorf("error reading latest realtime event: %s", err.Error())
+	}
+	return seq, nil
+}
+
+// eventsSince returns all events following the event with the
+// given sequence number from the event log, ordered by sequence number.
+// Returns false if the events are no longer available.
+func (r *Realtime) eventsSince(seq int64) ([]*notificationPayload, bool, error) {
+	// events are pruned in the order they were created,
+	// so if the event itself is still available,
+	// so are all events following it.
+	var exists bool
+	_, err := r.app.DB().QueryOne(pg.Scan(&exists), fmt.Sprintf(
+		`SELECT EXISTS (SELECT 1 FROM "%s" WHERE seq = ?)`, realtimeEventsTableName), seq)
+	if err != nil {
+		return nil, false, fmt.Errorf("error reading realtime events: %s", err.Error())
+	}
+	if !exists {
+		return nil, false, nil
+	}
+
+	var events []*notificationPayload
+	_, err = r.app.DB().Query(&events, fmt.Sprintf(
+		`SELECT seq, table_name, row_id, type, old_relations, new_relations FROM "%s" WHERE seq > ? ORDER BY seq`,
+		realtimeEventsTableName), seq)
+	if err != nil {
+		return nil, false, fmt.Errorf("error reading realtime events: %s", err.Error())
+	}
+	return events, true, nil
+}
+
+// replay is the state of the replays of missed events running for a subscriber.
+// While events are replayed, live messages sent to the subscriber are buffered,
+// so they are received after the replayed events, in order.
+type replay struct {
+	// running is the number of replays running for the subscriber.
+	running int
+	// replayed contains the sequence number up to which
+	// events were replayed by subscription.
+	replayed map[subscription]int64
+	// buffered contains the live messages sent
+	// to the subscriber while events were replayed.
+	buffered []*bufferedMessage
+}
+
+// bufferedMessage is a live message buffered while events are replayed.
+type bufferedMessage struct {
+	// subscription is the subscription the message belongs to,
+	// or nil if it does not belong to a subscription.
+	subscription *subscription
+	channel      string
+	message      string
+	seq          int64
+}
+
+// deliver sends a message to a subscriber. seq is the sequence
+// number of the event the message belongs to, or 0.
+// Messages that are not part of a replay are buffered
+// while events are replayed to the subscriber.
+func (r *Realtime) deliver(s subscriber, sub *subscription, channel string, message string, seq int64, replayed bool) {
+	if !replayed {
+		r.replaysMutex.Lock()
+		if rp, ok := r.replays[s]; ok {
+			rp.buffered = append(rp.buffered, &bufferedMessage{sub, channel, message, seq})
+			r.replaysMutex.Unlock()
+			return
+		}
+		r.replaysMutex.Unlock()
+	}
+	s.send(channel, message, seq)
+}
+
+// startReplay starts buffering the live messages sent to a subscriber.
+// It has to be called before adding the subscriptions events are replayed for,
+// so no live message about them is sent before the replayed events.
+func (r *Realtime) startReplay(s subscriber) {
+	r.replaysMutex.Lock()
+	defer r.replaysMutex.Unlock()
+
+	rp, ok := r.replays[s]
+	if !ok {
+		rp = &replay{replayed: make(map[subscription]int64)}
+		r.replays[s] = rp
+	}
+	rp.running++
+}
+
+// finishReplay marks the events of subscriptions up to the given sequence
+// number as replayed. Once all replays running for the subscriber finished,
+// the buffered messages are sent, skipping messages about events
+// that were replayed, as the replay sent the current state
+// of the resource instances they changed.
+func (r *Realtime) finishReplay(s subscriber, subscriptions []*subscription, until int64) {
+	r.replaysMutex.Lock()
+	defer r.replaysMutex.Unlock()
+
+	rp := r.replays[s]
+	for _, sub := range subscriptions {
+		if until > rp.replayed[*sub] {
+			rp.replayed[*sub] = until
+		}
+	}
+
+	rp.running--
+	if rp.running > 0 {
+		return
+	}
+	delete(r.replays, s)
+
+	// messages are sent while holding replaysMutex,
+	// so subsequent live messages can't overtake them
+	for _, m := range rp.buffered {
+		if m.subscription != nil && m.seq > 0 && m.seq <= rp.replayed[*m.subscription] {
+			continue
+		}
+		s.send(m.channel, m.message, m.seq)
+	}
+}
+
+// replayEvents sends the current state of all resource instances
+// in subscriptions that changed after the event with the given
+// sequence number to sub. Each instance is sent once, as part of
+// the most recent event that changed it.
+// If the events are no longer available, a resync message is sent.
+//
+// startReplay has to be called for sub before calling replayEvents.
+func (r *Realtime) replayEvents(sub subscriber, subscriptions []*subscription, seq int64) {
+	until := seq
+	defer func() {
+		r.finishReplay(sub, subscriptions, until)
+	}()
+
+	events, ok, err := r.eventsSince(seq)
+	if err != nil {
+		r.handleError(err)
+		sub.send(resyncChannelName, msgResync, 0)
+		return
+	}
+	if !ok {
+		sub.send(resyncChannelName, msgResync, 0)
+		return
+	}
+
+	subscribed := make(map[subscription]struct{})
+	for _, s := range subscriptions {
+		subscribed[*s] = struct{}{}
+	}
+
+	latest := make(map[subscription]*pendingChange)
+	for _, e := range events {
+		until = e.Seq
+		changes, err := r.eventChanges(e)
+		if err != nil {
+			r.handleError(err)
+			continue
+		}
+		for _, c := range changes {
+			if _, ok := subscribed[c.subscription]; ok {
+				mergeChange(latest, c, e.Seq)
+			}
+		}
+	}
+
+	r.sendChanges(latest, func(*Resource, string) []subscriber {
+		return []subscriber{sub}
+	}, true)
+}
+
+// pruneEvents periodically deletes events older
+// than EventRetention from the event log until ctx is done.
+func (r *Realtime) pruneEvents(ctx context.Context) {
+	ticker := time.NewTicker(r.EventRetention / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			_, err := r.app.DB().Exec(fmt.Sprintf(`DELETE FROM "%s" WHERE created_at < ?`, realtimeEventsTableName),
+				time.Now().Add(-r.EventRetention))
+			if err != nil {
+				r.handleError(fmt.Errorf("error pruning realtime events: %s", err.Error()))
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package jargo
+
+import "fmt"
+
+// Enable enables realtime for a Resource, allowing
+// clients to subscribe to its instances.
+// If the Realtime instance is running, the realtime triggers
+// required for the Resource are installed immediately,
+// otherwise they are installed once Run is called.
+//
+// Realtime is enabled by default for all Resources
+// with the realtime option set on their id field.
+func (r *Realtime) Enable(resource *Resource) error {
+	r.enabledMutex.Lock()
+	defer r.enabledMutex.Unlock()
+
+	r.enabled[resource] = struct{}{}
+	if !r.running {
+		return nil
+	}
+	return r.updateTriggers()
+}
+
+// Disable disables realtime for a Resource,
+// revoking all subscriptions to its instances.
+//
+// If the Realtime instance is running, the realtime triggers it installed
+// that are no longer required are dropped, unless they are required by
+// another running Realtime instance of the Application or by the realtime
+// options of the Resources. Realtime instances of other processes
+// are not taken into account, so they have to enable the Resource again
+// after restarting. Events of Resources realtime is disabled for are ignored.
+func (r *Realtime) Disable(resource *Resource) error {
+	r.enabledMutex.Lock()
+	delete(r.enabled, resource)
+	var unused []*Resource
+	if r.running {
+		required := r.requiredTriggers()
+		for res := range r.triggers {
+			if _, ok := required[res]; !ok {
+				unused = append(unused, res)
+			}
+		}
+	}
+	r.enabledMutex.Unlock()
+
+	// revoke all subscriptions to the resource
+	r.subscriptionsMutex.Lock()
+	revoked := make(map[subscriber][]string)
+	for s, subscriptions := range r.subscriptions {
+		for id := range subscriptions[resource] {
+			revoked[s] = append(revoked[s], id)
+		}
+	}
+	r.subscriptionsMutex.Unlock()
+
+	for s, ids := range revoked {
+		for _, id := range ids {
+			r.revoke(s, resource, id)
+		}
+	}
+
+	return r.dropTriggers(unused)
+}
+
+// Enabled returns whether realtime is enabled for a Resource.
+func (r *Realtime) Enabled(resource *Resource) bool {
+	r.enabledMutex.Lock()
+	defer r.enabledMutex.Unlock()
+
+	_, ok := r.enabled[resource]
+	return ok
+}
+
+// resourcesRegistered enables realtime for all newly registered Resources
+// with the realtime option set and installs the triggers they require.
+func (r *Realtime) resourcesRegistered(resources []*Resource) error {
+	r.enabledMutex.Lock()
+	defer r.enabledMutex.Unlock()
+
+	for _, resource := range resources {
+		if resource.schema.Realtime() {
+			r.enabled[resource] = struct{}{}
+		}
+	}
+	return r.updateTriggers()
+}
+
+// requiredTriggers returns the Resources whose realtime triggers
+// are required by the enabled Resources.
+// Triggers are required on the tables of enabled Resources, as well as
+// on the tables of all Resources belonging to an enabled Resource,
+// as changing their relations updates the enabled Resource.
+// enabledMutex must be held when calling requiredTriggers.
+func (r *Realtime) requiredTriggers() map[*Resource]struct{} {
+	required := make(map[*Resource]struct{})
+	for resource := range r.enabled {
+		required[resource] = struct{}{}
+	}
+	for _, resource := range r.app.resources {
+		for _, schema := range resource.schema.BelongsToColumns() {
+			if related, ok := r.app.resources[schema]; ok {
+				if _, ok := r.enabled[related]; ok {
+					required[resource] = struct{}{}
+				}
+			}
+		}
+	}
+	return required
+}
+
+// updateTriggers installs the realtime triggers required
+// by the enabled Resources that weren't installed yet.
+// enabledMutex must be held when calling updateTriggers.
+func (r *Realtime) updateTriggers() error {
+	for resource := range r.requiredTriggers() {
+		if _, ok := r.triggers[resource]; ok {
+			continue
+		}
+		if err := resource.schema.CreateRealtimeTriggers(r.app.DB(), triggerFunctionName); err != nil {
+			return fmt.Errorf(`error creating realtime triggers for resource "%s": %s`,
+				resource.JSONAPIName(), err.Error())
+		}
+		r.triggers[resource] = struct{}{}
+	}
+
+	return nil
+}
+
+// dropTriggers drops the realtime triggers of Resources that are
+// neither required by the realtime options of the Resources
+// nor by any running Realtime instance of the Application.
+// Other Realtime instances forget about the dropped triggers,
+// so they are installed again if those instances enable the Resources.
+func (r *Realtime) dropTriggers(resources []*Resource) error {
+	for _, resource := range resources {
+		if resource.schema.RealtimeTriggerRequired() {
+			continue
+		}
+
+		required := false
+		for _, other := range r.app.runningRealtimes() {
+			if other == r {
+				continue
+			}
+			other.enabledMutex.Lock()
+			if _, ok := other.requiredTriggers()[resource]; ok {
+				required = true
+			}
+			other.enabledMutex.Unlock()
+		}
+		if required {
+			continue
+		}
+
+		if err := resource.schema.DropRealtimeTriggers(r.app.DB()); err != nil {
+			return fmt.Errorf(`error dropping realtime triggers for resource "%s": %s`,
+				resource.JSONAPIName(), err.Error())
+		}
+		for _, rt := range r.app.runningRealtimes() {
+			rt.enabledMutex.Lock()
+			delete(rt.triggers, resource)
+			rt.enabledMutex.Unlock()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package jargo
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ssePath is the path relative to the Realtime namespace
+	// under which the Server-Sent Events endpoint is served.
+	ssePath = "sse"
+
+	// sseSubscribeParam is the query parameter containing
+	// the resource instances to subscribe to,
+	// in the format <model>:<id>.
+	sseSubscribeParam = "subscribe"
+
+	lastEventIdHeader = "Last-Event-ID"
+
+	// sseEventBufferSize is the number of events that may be
+	// queued for a Server-Sent Events client before it is disconnected.
+	sseEventBufferSize = 256
+)
+
+// sseEvent is an event to be sent to a Server-Sent Events client.
+type sseEvent struct {
+	seq     int64
+	channel string
+	message string
+}
+
+// sseSubscriber is a subscriber connected via Server-Sent Events.
+type sseSubscriber struct {
+	// req is the request the client connected with.
+	req    *http.Request
+	events chan *sseEvent
+
+	done      chan struct{}
+	closeOnce *sync.Once
+}
+
+func newSSESubscriber(req *http.Request) *sseSubscriber {
+	return &sseSubscriber{
+		req:       req,
+		events:    make(chan *sseEvent, sseEventBufferSize),
+		done:      make(chan struct{}),
+		closeOnce: new(sync.Once),
+	}
+}
+
+func (s *sseSubscriber) send(channel string, message string, seq int64) {
+	select {
+	case s.events <- &sseEvent{seq: seq, channel: channel, message: message}:
+	case <-s.done:
+	default:
+		// the client can't keep up with the events.
+		// disconnect it, so it reconnects and
+		// resumes using the Last-Event-ID header.
+		s.close()
+	}
+}
+
+func (s *sseSubscriber) closed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *sseSubscriber) mayReceive(r *Realtime, resource *Resource, id string, instance interface{}) bool {
+	return r.MayReceiveSSE(s.req, resource, id, instance)
+}
+
+func (s *sseSubscriber) close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+}
+
+// SSEPath returns the path under which
+// the Server-Sent Events endpoint is served.
+func (r *Realtime) SSEPath() string {
+	return r.namespace + ssePath
+}
+
+// SSEHandler returns a http.Handler serving
+// the Server-Sent Events endpoint.
+func (r *Realtime) SSEHandler() http.Handler {
+	return http.HandlerFunc(r.ServeSSE)
+}
+
+// ServeSSE serves a Server-Sent Events stream (text/event-stream)
+// of updated, deleted and revoked events for the resource instances specified
+// in the subscribe query parameters, e.g.
+// ?subscribe=users:1&subscribe=posts:5
+//
+// Event ids are the sequence numbers of the events in the event log.
+// If the request contains a Last-Event-ID header, all events
+// the client missed since then are sent, or, if they are no longer
+// available, a resync event is sent.
+func (r *Realtime) ServeSSE(w http.ResponseWriter, req *http.Request) {
+	if !r.running {
+		panic(errRealtimeNotRunning)
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	subscriptions, status, msg := r.parseSSESubscriptions(req)
+	if subscriptions == nil {
+		http.Error(w, msg, status)
+		return
+	}
+
+	// parse Last-Event-ID header
+	var lastSeq int64
+	if header := req.Header.Get(lastEventIdHeader); header != "" {
+		seq, err := strconv.ParseInt(header, 10, 64)
+		if err != nil || seq <= 0 {
+			http.Error(w, "invalid Last-Event-ID header", http.StatusBadRequest)
+			return
+		}
+		lastSeq = seq
+	}
+
+	sub := newSSESubscriber(req)
+	if lastSeq > 0 {
+		// buffer live messages until missed events were replayed
+		r.startReplay(sub)
+	}
+	for _, s := range subscriptions {
+		r.addSubscription(sub, s.resource, s.id)
+	}
+	defer func() {
+		sub.close()
+		r.removeSubscriptions(sub)
+	}()
+
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	// disable response buffering in nginx
+	header.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	if lastSeq > 0 {
+		// replay missed events in a separate goroutine,
+		// as they are queued while events are written.
+		// live messages are sent once the replay finished.
+		go r.replayEvents(sub, subscriptions, lastSeq)
+	}
+
+	keepAlive := time.NewTicker(r.SSEKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case e := <-sub.events:
+			writeSSEEvent(w, e)
+			flusher.Flush()
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case <-sub.done:
+			return
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+// parseSSESubscriptions parses the subscribe query parameters of a request.
+// If the parameters are invalid or any subscription is disallowed
+// by MaySubscribeSSE, it returns nil, the response status
+// and an error message.
+func (r *Realtime) parseSSESubscriptions(req *http.Request) ([]*subscription, int, string) {
+	values := req.URL.Query()[sseSubscribeParam]
+	if len(values) == 0 {
+		return nil, http.StatusBadRequest, "missing subscribe parameter"
+	}
+
+	var subscriptions []*subscription
+	for _, value := range values {
+		// JSON API member names may not contain colons,
+		// so the first colon separates model and id
+		spl := strings.SplitN(value, ":", 2)
+		if len(spl) != 2 || spl[1] == "" {
+			return nil, http.StatusBadRequest, fmt.Sprintf(`invalid subscribe parameter "%s"`, value)
+		}
+
+		var resource *Resource
+		for schema, res := range r.app.resources {
+			if schema.JSONAPIName() == spl[0] {
+				resource = res
+				break
+			}
+		}
+		if resource == nil || !r.Enabled(resource) {
+			return nil, http.StatusBadRequest, msgInvalidResource
+		}
+
+		// normalize the id to the representation used in realtime events
+		id, ok := resource.schema.NormalizeId(spl[1])
+		if !ok {
+			return nil, http.StatusBadRequest, msgInvalidId
+		}
+
+		if !r.MaySubscribeSSE(req, resource, id) {
+			return nil, http.StatusForbidden, msgAccessDenied
+		}
+
+		subscriptions = append(subscriptions, &subscription{
+			resource: resource,
+			id:       id,
+		})
+	}
+
+	return subscriptions, http.StatusOK, ""
+}
+
+// writeSSEEvent writes an event in the text/event-stream format.
+func writeSSEEvent(w http.ResponseWriter, e *sseEvent) {
+	if e.seq > 0 {
+		fmt.Fprintf(w, "id: %d\n", e.seq)
+	}
+	fmt.Fprintf(w, "event: %s\n", e.channel)
+	for _, line := range strings.Split(e.message, "\n") {
+		fmt.Fprintf(w, "data: %s\n", line)
+	}
+	fmt.Fprint(w, "\n")
+}
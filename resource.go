@@ -22,13 +22,28 @@ type Resource struct {
 // creating the necessary database tables.
 // If it has already been initialized,
 // it is not initialized again.
+//
+// If the Resource's table already exists, it is migrated,
+// refusing changes that may discard data.
+//
+// The tables of related Resources referenced by foreign keys
+// created for the "onDelete" option have to exist.
 func (r *Resource) Initialize(db *pg.DB) error {
+	if err := r.initialize(db, nil); err != nil {
+		return err
+	}
+	return r.schema.CreateForeignKeys(db, nil)
+}
+
+// initialize initializes the Resource,
+// migrating existing tables according to options.
+func (r *Resource) initialize(db *pg.DB, options *internal.MigrationOptions) error {
 	if r.initialized {
 		return nil
 	}
 	// for now, creating the table in the database
 	// is all that's needed to initialize a Resource
-	err := r.schema.CreateTable(db)
+	err := r.schema.CreateTable(db, options)
 	if err != nil {
 		return err
 	}
@@ -103,6 +118,25 @@ func (r *Resource) Validate(validate *validator.Validate, instance interface{})
 		if e, ok := err.(validator.ValidationErrors); ok {
 			return ErrValidationFailed(e)
 		}
+		if e, ok := err.(*internal.EnumValueError); ok {
+			return ErrInvalidPayload(e.Error())
+		}
+	}
+	return nil
+}
+
+// EnumValues returns the values allowed for the Resource's
+// attribute with the given JSON API name by its "enum" option.
+// Returns nil if there is no such attribute
+// or the attribute has no "enum" option.
+func (r *Resource) EnumValues(field string) []string {
+	for _, f := range r.schema.Fields() {
+		if f.JSONAPIName() == field {
+			if ef, ok := f.(internal.EnumField); ok {
+				return ef.EnumValues()
+			}
+			return nil
+		}
 	}
 	return nil
 }
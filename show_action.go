@@ -34,9 +34,11 @@ func (a *ShowAction) Handle(req *ShowRequest) Response {
 		}
 	}
 
-	// create show query
+	// create show query, refreshing the expiration
+	// of resources with sliding expiration
 	q := req.Resource().SelectById(req.DB(), req.ResourceId()).
-		Fields(req.Fields())
+		Fields(req.Fields()).
+		RefreshExpiration()
 
 	// if set, apply beforeQuery handler
 	if a.beforeQuery != nil {
@@ -0,0 +1,60 @@
+// +build integration
+
+package integration
+
+import (
+	"github.com/crushedpixel/jargo"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+)
+
+type checkTest struct {
+	Id     int64
+	Status string `jargo:",enum:draft|published|archived"`
+	Price  int    `jargo:",check:price >= 0"`
+}
+
+// TestEnumAttributes tests the validation and
+// database constraints of "enum" attributes.
+func TestEnumAttributes(t *testing.T) {
+	resource, err := app.RegisterResource(checkTest{})
+	require.Nil(t, err)
+
+	require.Equal(t, []string{"draft", "published", "archived"}, resource.EnumValues("status"))
+	require.Nil(t, resource.EnumValues("price"))
+
+	// values that are not allowed are rejected before reaching the database
+	_, err = resource.ParseJsonapiPayloadString(`{"data":{"type":"check-tests","attributes":{"status":"deleted"}}}`,
+		app.Validate(), true)
+	require.IsType(t, &jargo.ApiError{}, err)
+	require.Equal(t, http.StatusBadRequest, err.(*jargo.ApiError).Status())
+
+	res, err := resource.ParseJsonapiPayloadString(`{"data":{"type":"check-tests","attributes":{"status":"draft"}}}`,
+		app.Validate(), true)
+	require.Nil(t, err)
+	_, err = resource.InsertInstance(app.DB(), res).Result()
+	require.Nil(t, err)
+
+	// the database rejects values bypassing validation
+	_, err = resource.InsertInstance(app.DB(), &checkTest{Status: "deleted"}).Result()
+	require.IsType(t, &jargo.CheckViolationError{}, err)
+
+	cve := err.(*jargo.CheckViolationError)
+	require.Equal(t, "status", cve.Field)
+	require.Equal(t, "status", cve.Column)
+}
+
+// TestCheckAttributes tests the database constraints
+// of attributes with the "check" option.
+func TestCheckAttributes(t *testing.T) {
+	resource, err := app.RegisterResource(checkTest{})
+	require.Nil(t, err)
+
+	_, err = resource.InsertInstance(app.DB(), &checkTest{Status: "draft", Price: -1}).Result()
+	require.IsType(t, &jargo.CheckViolationError{}, err)
+
+	cve := err.(*jargo.CheckViolationError)
+	require.Equal(t, "price", cve.Field)
+	require.Equal(t, "price", cve.Column)
+}
@@ -3,7 +3,12 @@
 package integration
 
 import (
+	"context"
+	"errors"
+	"github.com/crushedpixel/jargo"
+	"github.com/go-pg/pg"
 	"github.com/stretchr/testify/require"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -102,3 +107,376 @@ func TestDefaultExpire(t *testing.T) {
 	require.Nil(t, err)
 	require.Nil(t, res)
 }
+
+type expireMark struct {
+	Id      int64
+	Status  string
+	Expires time.Time `jargo:",expire"`
+}
+
+// TestExpireMark tests the ExpireMark expire strategy.
+func TestExpireMark(t *testing.T) {
+	resource, err := app.RegisterResource(expireMark{})
+	require.Nil(t, err)
+	app.SetExpireStrategy(resource, jargo.ExpireMark("status", "overdue"))
+
+	res, err := resource.InsertInstance(app.DB(), &expireMark{
+		Status:  "open",
+		Expires: time.Now().Add(2 * time.Second),
+	}).Result()
+	require.Nil(t, err)
+	created := res.(*expireMark)
+
+	// sleep 3 seconds so resource must have timed out
+	time.Sleep(3 * time.Second)
+
+	// fetch resource again, expecting it to have been marked
+	res, err = resource.SelectById(app.DB(), created.Id).Result()
+	require.Nil(t, err)
+	require.Equal(t, "overdue", res.(*expireMark).Status)
+}
+
+type expireArchive struct {
+	Id      int64
+	Expires time.Time `jargo:",expire"`
+}
+
+// TestExpireArchive tests the ExpireArchive expire strategy.
+func TestExpireArchive(t *testing.T) {
+	resource, err := app.RegisterResource(expireArchive{})
+	require.Nil(t, err)
+	app.SetExpireStrategy(resource, jargo.ExpireArchive("expire_archives_history"))
+
+	res, err := resource.InsertInstance(app.DB(), &expireArchive{
+		Expires: time.Now().Add(2 * time.Second),
+	}).Result()
+	require.Nil(t, err)
+	created := res.(*expireArchive)
+
+	// sleep 3 seconds so resource must have timed out
+	time.Sleep(3 * time.Second)
+
+	// fetch resource again, expecting it to have been moved
+	res, err = resource.SelectById(app.DB(), created.Id).Result()
+	require.Nil(t, err)
+	require.Nil(t, res)
+
+	var count int
+	_, err = app.DB().QueryOne(pg.Scan(&count),
+		`SELECT COUNT(*) FROM "expire_archives_history" WHERE id = ?`, created.Id)
+	require.Nil(t, err)
+	require.Equal(t, 1, count)
+}
+
+type expireArchiveColumns struct {
+	Id      int64
+	Name    string
+	Expires time.Time `jargo:",expire"`
+}
+
+// TestExpireArchiveColumns tests that the ExpireArchive expire strategy
+// migrates an archive table whose columns differ from the Resource's table.
+func TestExpireArchiveColumns(t *testing.T) {
+	// create archive table with different column order,
+	// missing the name column and a non-null column
+	// that doesn't exist on the resource's table
+	_, err := app.DB().Exec(`DROP TABLE IF EXISTS "expire_archive_columns_history"`)
+	require.Nil(t, err)
+	_, err = app.DB().Exec(`CREATE TABLE "expire_archive_columns_history" (
+		expires timestamptz NOT NULL, id bigint NOT NULL, removed text NOT NULL)`)
+	require.Nil(t, err)
+
+	resource, err := app.RegisterResource(expireArchiveColumns{})
+	require.Nil(t, err)
+	app.SetExpireStrategy(resource, jargo.ExpireArchive("expire_archive_columns_history"))
+
+	res, err := resource.InsertInstance(app.DB(), &expireArchiveColumns{
+		Name:    "Marius",
+		Expires: time.Now().Add(2 * time.Second),
+	}).Result()
+	require.Nil(t, err)
+	created := res.(*expireArchiveColumns)
+
+	// sleep 3 seconds so resource must have timed out
+	time.Sleep(3 * time.Second)
+
+	res, err = resource.SelectById(app.DB(), created.Id).Result()
+	require.Nil(t, err)
+	require.Nil(t, res)
+
+	var name string
+	_, err = app.DB().QueryOne(pg.Scan(&name),
+		`SELECT name FROM "expire_archive_columns_history" WHERE id = ?`, created.Id)
+	require.Nil(t, err)
+	require.Equal(t, "Marius", name)
+}
+
+type expireHook struct {
+	Id      int64
+	Expires time.Time `jargo:",expire"`
+}
+
+// TestOnExpire tests that OnExpire hooks
+// receive the expired instances.
+func TestOnExpire(t *testing.T) {
+	resource, err := app.RegisterResource(expireHook{})
+	require.Nil(t, err)
+
+	expired := make(chan []interface{}, 1)
+	app.OnExpire(resource, func(instances []interface{}) {
+		expired <- instances
+	})
+
+	res, err := resource.InsertInstance(app.DB(), &expireHook{
+		Expires: time.Now().Add(2 * time.Second),
+	}).Result()
+	require.Nil(t, err)
+	created := res.(*expireHook)
+
+	select {
+	case instances := <-expired:
+		require.Len(t, instances, 1)
+		require.Equal(t, created.Id, instances[0].(*expireHook).Id)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expire hook was not invoked")
+	}
+}
+
+type expireStats struct {
+	Id      int64
+	Expires time.Time `jargo:",expire"`
+}
+
+// TestExpireStats tests the expiration statistics
+// returned by Application.ExpireStats.
+func TestExpireStats(t *testing.T) {
+	resource, err := app.RegisterResource(expireStats{})
+	require.Nil(t, err)
+
+	for i := 0; i < 3; i++ {
+		_, err := resource.InsertInstance(app.DB(), &expireStats{
+			Expires: time.Now().Add(2 * time.Second),
+		}).Result()
+		require.Nil(t, err)
+	}
+
+	// sleep 3 seconds so resources must have timed out
+	time.Sleep(3 * time.Second)
+
+	stats, ok := app.ExpireStats()[resource]
+	require.True(t, ok)
+	require.True(t, stats.Leader)
+	require.Equal(t, uint64(3), stats.Expired)
+	require.Equal(t, uint64(1), stats.Batches)
+	require.True(t, stats.NextExpiration.IsZero())
+}
+
+type expireTTL struct {
+	Id      int64
+	Expires time.Time `jargo:",expire,ttl:3s"`
+}
+
+// TestExpireTTL tests the sliding expiration
+// of expire fields with a ttl.
+func TestExpireTTL(t *testing.T) {
+	resource, err := app.RegisterResource(expireTTL{})
+	require.Nil(t, err)
+
+	res, err := resource.InsertInstance(app.DB(), &expireTTL{
+		Expires: time.Now().Add(3 * time.Second),
+	}).Result()
+	require.Nil(t, err)
+	created := res.(*expireTTL)
+
+	// refresh expiration shortly before it is reached
+	time.Sleep(2 * time.Second)
+	res, err = resource.SelectById(app.DB(), created.Id).RefreshExpiration().Result()
+	require.Nil(t, err)
+	require.NotNil(t, res)
+	require.True(t, res.(*expireTTL).Expires.After(created.Expires))
+
+	// sleep 2 seconds so the original expiration is reached
+	time.Sleep(2 * time.Second)
+
+	// fetch resource again, expecting it to still exist
+	res, err = resource.SelectById(app.DB(), created.Id).Result()
+	require.Nil(t, err)
+	require.NotNil(t, res)
+
+	// sleep 2 seconds so the refreshed expiration is reached
+	time.Sleep(2 * time.Second)
+
+	// fetch resource again, expecting it to have timed out
+	res, err = resource.SelectById(app.DB(), created.Id).Result()
+	require.Nil(t, err)
+	require.Nil(t, res)
+}
+
+type ttlWithoutExpire struct {
+	Id      int64
+	Expires time.Time `jargo:",ttl:3s"`
+}
+
+// TestTTLWithoutExpire tests that the "ttl" option
+// may only be used in conjunction with the "expire" option.
+func TestTTLWithoutExpire(t *testing.T) {
+	_, err := app.RegisterResource(ttlWithoutExpire{})
+	require.EqualError(t, err, `"ttl" option may only be used in conjunction with the "expire" option`)
+}
+
+type expireLeader struct {
+	Id      int64
+	Expires time.Time `jargo:",expire"`
+}
+
+// TestExpireLeaderElection tests that only one of multiple
+// Applications using the same database expires a Resource's records
+// and that another Application takes over once the leader stops.
+func TestExpireLeaderElection(t *testing.T) {
+	options := jargo.Options{
+		DB:                        pg.Connect(app.DB().Options()),
+		ExpireLeaderCheckInterval: 100 * time.Millisecond,
+	}
+	app1 := jargo.NewApplication(options)
+	options.DB = pg.Connect(app.DB().Options())
+	app2 := jargo.NewApplication(options)
+
+	resource1, err := app1.RegisterResource(expireLeader{})
+	require.Nil(t, err)
+	resource2, err := app2.RegisterResource(expireLeader{})
+	require.Nil(t, err)
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	defer cancel1()
+	go app1.Run(ctx1)
+	time.Sleep(500 * time.Millisecond)
+
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	go app2.Run(ctx2)
+	time.Sleep(500 * time.Millisecond)
+
+	// the first Application is the leader
+	require.True(t, app1.ExpireStats()[resource1].Leader)
+	require.False(t, app2.ExpireStats()[resource2].Leader)
+
+	// stopping the leader releases its advisory lock
+	cancel1()
+	time.Sleep(500 * time.Millisecond)
+	require.True(t, app2.ExpireStats()[resource2].Leader)
+
+	res, err := resource2.InsertInstance(app2.DB(), &expireLeader{
+		Expires: time.Now().Add(1 * time.Second),
+	}).Result()
+	require.Nil(t, err)
+	created := res.(*expireLeader)
+
+	// sleep 2 seconds so resource must have timed out
+	time.Sleep(2 * time.Second)
+
+	res, err = resource2.SelectById(app2.DB(), created.Id).Result()
+	require.Nil(t, err)
+	require.Nil(t, res)
+	require.Equal(t, uint64(1), app2.ExpireStats()[resource2].Expired)
+}
+
+type expireFailure struct {
+	Id      int64
+	Expires time.Time `jargo:",expire"`
+}
+
+// TestExpireFailureBackoff tests that expirations failing
+// with an error are retried with an increasing backoff.
+func TestExpireFailureBackoff(t *testing.T) {
+	resource, err := app.RegisterResource(expireFailure{})
+	require.Nil(t, err)
+
+	var calls int32
+	app.SetExpireStrategy(resource, jargo.ExpireCustom(func(tx *pg.Tx, instances []interface{}) error {
+		atomic.AddInt32(&calls, 1)
+		return errors.New("expiration failed")
+	}))
+
+	_, err = resource.InsertInstance(app.DB(), &expireFailure{
+		Expires: time.Now().Add(1 * time.Second),
+	}).Result()
+	require.Nil(t, err)
+
+	// with a batch pause of 100ms, the expiration
+	// is retried after 100ms, 200ms, 400ms, 800ms, ...
+	time.Sleep(3 * time.Second)
+	n := atomic.LoadInt32(&calls)
+	require.True(t, n > 1, "expiration was not retried")
+	require.True(t, n <= 6, "expiration was retried %d times", n)
+
+	// expiration succeeds once the strategy succeeds
+	app.SetExpireStrategy(resource, jargo.ExpireDelete())
+	time.Sleep(5 * time.Second)
+	var count int
+	_, err = app.DB().QueryOne(pg.Scan(&count), `SELECT COUNT(*) FROM "expire_failures"`)
+	require.Nil(t, err)
+	require.Equal(t, 0, count)
+}
+
+type expireInvalidNotification struct {
+	Id      int64
+	Expires time.Time `jargo:",expire"`
+}
+
+// TestExpireInvalidNotification tests that notifications
+// with an invalid payload don't stop the expiration of records.
+func TestExpireInvalidNotification(t *testing.T) {
+	resource, err := app.RegisterResource(expireInvalidNotification{})
+	require.Nil(t, err)
+
+	_, err = app.DB().Exec(`NOTIFY "jargo_expire_expire_invalid_notifications", 'invalid'`)
+	require.Nil(t, err)
+
+	_, err = resource.InsertInstance(app.DB(), &expireInvalidNotification{
+		Expires: time.Now().Add(1 * time.Second),
+	}).Result()
+	require.Nil(t, err)
+
+	time.Sleep(3 * time.Second)
+	var count int
+	_, err = app.DB().QueryOne(pg.Scan(&count), `SELECT COUNT(*) FROM "expire_invalid_notifications"`)
+	require.Nil(t, err)
+	require.Equal(t, 0, count)
+}
+
+type expireCustomLog struct {
+	Id      int64
+	Expires time.Time `jargo:",expire"`
+}
+
+// TestExpireCustomLog tests that expirations are retried
+// with an increasing backoff if the ExpireFunc leaves records expired,
+// instead of passing them to the ExpireFunc again right away.
+func TestExpireCustomLog(t *testing.T) {
+	resource, err := app.RegisterResource(expireCustomLog{})
+	require.Nil(t, err)
+
+	var calls int32
+	app.SetExpireStrategy(resource, jargo.ExpireCustom(func(tx *pg.Tx, instances []interface{}) error {
+		// only log the expired records
+		atomic.AddInt32(&calls, 1)
+		return nil
+	}))
+
+	_, err = resource.InsertInstance(app.DB(), &expireCustomLog{
+		Expires: time.Now().Add(1 * time.Second),
+	}).Result()
+	require.Nil(t, err)
+
+	time.Sleep(3 * time.Second)
+	n := atomic.LoadInt32(&calls)
+	require.True(t, n > 1, "expiration was not retried")
+	require.True(t, n <= 6, "expiration was retried %d times", n)
+
+	app.SetExpireStrategy(resource, jargo.ExpireDelete())
+	time.Sleep(5 * time.Second)
+	var count int
+	_, err = app.DB().QueryOne(pg.Scan(&count), `SELECT COUNT(*) FROM "expire_custom_logs"`)
+	require.Nil(t, err)
+	require.Equal(t, 0, count)
+}
@@ -0,0 +1,117 @@
+// +build integration
+
+package integration
+
+import (
+	"github.com/crushedpixel/jargo"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+)
+
+type onDeleteParent struct {
+	Id   int64
+	Name string
+}
+
+type onDeleteRestrictChild struct {
+	Id     int64
+	Parent *onDeleteParent `jargo:",belongsTo,onDelete:restrict"`
+}
+
+type onDeleteCascadeChild struct {
+	Id     int64
+	Parent *onDeleteParent `jargo:",belongsTo,onDelete:cascade"`
+}
+
+type onDeleteSetNullChild struct {
+	Id     int64
+	Parent *onDeleteParent `jargo:",belongsTo,onDelete:setNull"`
+}
+
+// TestOnDeleteRestrict tests that deleting a record referenced
+// by a relation with the "onDelete:restrict" option fails.
+func TestOnDeleteRestrict(t *testing.T) {
+	parents, err := app.RegisterResource(onDeleteParent{})
+	require.Nil(t, err)
+	children, err := app.RegisterResource(onDeleteRestrictChild{})
+	require.Nil(t, err)
+
+	res, err := parents.InsertInstance(app.DB(), &onDeleteParent{Name: "restrict"}).Result()
+	require.Nil(t, err)
+	parent := res.(*onDeleteParent)
+
+	_, err = children.InsertInstance(app.DB(), &onDeleteRestrictChild{Parent: parent}).Result()
+	require.Nil(t, err)
+
+	_, err = parents.DeleteById(app.DB(), parent.Id).Result()
+	require.IsType(t, &jargo.ForeignKeyViolationError{}, err)
+
+	fkve := err.(*jargo.ForeignKeyViolationError)
+	require.Equal(t, http.StatusConflict, fkve.Status())
+	require.Equal(t, "on-delete-restrict-children", fkve.Resource)
+	require.Equal(t, "parent", fkve.Relationship)
+
+	require.Equal(t, "", fkve.SourcePointer())
+
+	// referencing a record that doesn't exist
+	// fails with the relationship's source pointer
+	_, err = children.InsertInstance(app.DB(), &onDeleteRestrictChild{
+		Parent: &onDeleteParent{Id: parent.Id + 1000},
+	}).Result()
+	require.IsType(t, &jargo.ForeignKeyViolationError{}, err)
+
+	fkve = err.(*jargo.ForeignKeyViolationError)
+	require.Equal(t, http.StatusNotFound, fkve.Status())
+	require.Equal(t, "/data/relationships/parent", fkve.SourcePointer())
+}
+
+// TestOnDeleteCascade tests that records with a relation
+// with the "onDelete:cascade" option are deleted
+// when deleting the related record.
+func TestOnDeleteCascade(t *testing.T) {
+	parents, err := app.RegisterResource(onDeleteParent{})
+	require.Nil(t, err)
+	children, err := app.RegisterResource(onDeleteCascadeChild{})
+	require.Nil(t, err)
+
+	res, err := parents.InsertInstance(app.DB(), &onDeleteParent{Name: "cascade"}).Result()
+	require.Nil(t, err)
+	parent := res.(*onDeleteParent)
+
+	res, err = children.InsertInstance(app.DB(), &onDeleteCascadeChild{Parent: parent}).Result()
+	require.Nil(t, err)
+	child := res.(*onDeleteCascadeChild)
+
+	_, err = parents.DeleteById(app.DB(), parent.Id).Result()
+	require.Nil(t, err)
+
+	res, err = children.SelectById(app.DB(), child.Id).Result()
+	require.Nil(t, err)
+	require.Nil(t, res)
+}
+
+// TestOnDeleteSetNull tests that relations with the
+// "onDelete:setNull" option are unset
+// when deleting the related record.
+func TestOnDeleteSetNull(t *testing.T) {
+	parents, err := app.RegisterResource(onDeleteParent{})
+	require.Nil(t, err)
+	children, err := app.RegisterResource(onDeleteSetNullChild{})
+	require.Nil(t, err)
+
+	res, err := parents.InsertInstance(app.DB(), &onDeleteParent{Name: "setNull"}).Result()
+	require.Nil(t, err)
+	parent := res.(*onDeleteParent)
+
+	res, err = children.InsertInstance(app.DB(), &onDeleteSetNullChild{Parent: parent}).Result()
+	require.Nil(t, err)
+	child := res.(*onDeleteSetNullChild)
+
+	_, err = parents.DeleteById(app.DB(), parent.Id).Result()
+	require.Nil(t, err)
+
+	res, err = children.SelectById(app.DB(), child.Id).Result()
+	require.Nil(t, err)
+	require.Nil(t, res.(*onDeleteSetNullChild).Parent)
+}
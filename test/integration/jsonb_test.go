@@ -0,0 +1,89 @@
+// +build integration
+
+package integration
+
+import (
+	"github.com/crushedpixel/jargo"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+)
+
+type jsonbSettings struct {
+	Theme string   `json:"theme"`
+	Tags  []string `json:"tags"`
+}
+
+type jsonbTest struct {
+	Id       int64
+	Settings jsonbSettings     `jargo:",type:jsonb"`
+	Labels   map[string]string `jargo:",type:jsonb"`
+}
+
+// TestJSONBAttributes tests the serialization
+// and filtering of attributes with the "type:jsonb" option.
+func TestJSONBAttributes(t *testing.T) {
+	resource, err := app.RegisterResource(jsonbTest{})
+	require.Nil(t, err)
+
+	res, err := resource.InsertInstance(app.DB(), &jsonbTest{
+		Settings: jsonbSettings{Theme: "dark", Tags: []string{"a", "b"}},
+		Labels:   map[string]string{"env": "prod"},
+	}).Result()
+	require.Nil(t, err)
+	dark := res.(*jsonbTest)
+
+	_, err = resource.InsertInstance(app.DB(), &jsonbTest{
+		Settings: jsonbSettings{Theme: "light", Tags: []string{"b"}},
+		Labels:   map[string]string{"env": "dev"},
+	}).Result()
+	require.Nil(t, err)
+
+	// jsonb attributes are serialized as nested objects
+	json, err := resource.ResponseAllFields(dark).Payload()
+	require.Nil(t, err)
+	require.Equal(t,
+		`{"data":{"type":"jsonb-tests","id":"1","attributes":{"labels":{"env":"prod"},"settings":{"theme":"dark","tags":["a","b"]}}}}`,
+		json)
+
+	filter := func(query map[string][]string) []*jsonbTest {
+		filters, err := resource.ParseFilters(jargo.ParseFilterParameters(query))
+		require.Nil(t, err)
+		res, err := resource.Select(app.DB()).Filters(filters).Result()
+		require.Nil(t, err)
+		return res.([]*jsonbTest)
+	}
+
+	// filter by json path
+	results := filter(map[string][]string{"filter[settings.theme]": {"dark"}})
+	require.Len(t, results, 1)
+	require.Equal(t, dark.Id, results[0].Id)
+
+	results = filter(map[string][]string{"filter[labels.env][not]": {"prod"}})
+	require.Len(t, results, 1)
+	require.Equal(t, "dev", results[0].Labels["env"])
+
+	// filter by containment
+	results = filter(map[string][]string{"filter[settings][contains]": {`{"theme":"dark","tags":["a"]}`}})
+	require.Len(t, results, 1)
+	require.Equal(t, dark.Id, results[0].Id)
+
+	results = filter(map[string][]string{"filter[settings.tags][contains]": {`["b"]`}})
+	require.Len(t, results, 2)
+
+	// json paths and containment are only supported on jsonb attributes
+	_, err = resource.ParseFilters(jargo.ParseFilterParameters(
+		map[string][]string{"filter[id.value]": {"1"}}))
+	require.NotNil(t, err)
+	_, err = resource.ParseFilters(jargo.ParseFilterParameters(
+		map[string][]string{"filter[id][contains]": {"1"}}))
+	require.NotNil(t, err)
+
+	// values compared to jsonb values must be valid JSON
+	_, err = resource.ParseFilters(jargo.ParseFilterParameters(
+		map[string][]string{"filter[settings][contains]": {`{"theme":`}}))
+	require.IsType(t, &jargo.ApiError{}, err)
+	require.Equal(t, http.StatusBadRequest, err.(*jargo.ApiError).Status())
+	_, err = resource.Filters(map[string]*jargo.Filter{"settings": {Eq: []interface{}{make(chan int)}}})
+	require.NotNil(t, err)
+}
@@ -3,8 +3,14 @@
 package integration
 
 import (
+	"github.com/crushedpixel/jargo"
+	"github.com/go-pg/pg"
 	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 type MigrationTestType0 struct {
@@ -44,3 +50,386 @@ func TestMigrations(t *testing.T) {
 	results := res.([]*MigrationTestType1)
 	require.Len(t, results, 1)
 }
+
+type MigrationTestType2 struct {
+	Id    int64 `jargo:",table:migration_test_types"`
+	Name  string
+	Valid bool `jargo:",default:TRUE"`
+}
+
+// TestDestructiveMigrations tests that migrations
+// dropping columns are refused unless explicitly allowed.
+func TestDestructiveMigrations(t *testing.T) {
+	_, err := app.RegisterResource(MigrationTestType1{})
+	require.Nil(t, err)
+
+	// registering a resource without the age column
+	// would drop it, which is not allowed by default
+	_, err = jargo.NewApplication(jargo.Options{DB: app.DB()}).
+		RegisterResource(MigrationTestType2{})
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "destructive changes are not allowed")
+
+	// allow destructive migrations
+	resource2, err := jargo.NewApplication(jargo.Options{DB: app.DB(), AllowDestructiveMigrations: true}).
+		RegisterResource(MigrationTestType2{})
+	require.Nil(t, err)
+
+	// existing records should have been kept
+	res, err := resource2.Select(app.DB()).
+		Where(`name = ?`, "Peter").
+		Result()
+	require.Nil(t, err)
+	require.Len(t, res.([]*MigrationTestType2), 1)
+}
+
+type MigrationTestType3 struct {
+	Id    int64 `jargo:",table:migration_test_types"`
+	Name  string
+	Valid bool `jargo:",default:TRUE"`
+	Email string
+}
+
+// TestNotNullColumnMigrations tests that migrations adding
+// a column that may not be null without a default value
+// are considered destructive.
+func TestNotNullColumnMigrations(t *testing.T) {
+	_, err := jargo.NewApplication(jargo.Options{DB: app.DB(), AllowDestructiveMigrations: true}).
+		RegisterResource(MigrationTestType2{})
+	require.Nil(t, err)
+
+	_, err = jargo.NewApplication(jargo.Options{DB: app.DB()}).
+		RegisterResource(MigrationTestType3{})
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "destructive changes are not allowed")
+}
+
+type UniqueMigrationTestType0 struct {
+	Id   int64 `jargo:",table:unique_migration_test_types"`
+	Name string
+}
+
+type UniqueMigrationTestType1 struct {
+	Id   int64  `jargo:",table:unique_migration_test_types"`
+	Name string `jargo:",unique"`
+}
+
+// TestUniqueMigrations tests that unique constraints
+// of existing tables are added and dropped when migrating.
+func TestUniqueMigrations(t *testing.T) {
+	constraints := func() []string {
+		var names []string
+		_, err := app.DB().Query(&names, `SELECT conname FROM pg_constraint WHERE conrelid = to_regclass('unique_migration_test_types') AND contype = 'u'`)
+		require.Nil(t, err)
+		return names
+	}
+	plan := func(model interface{}) []*jargo.SchemaChange {
+		planApp := jargo.NewApplication(jargo.Options{DB: app.DB(), MigrationMode: jargo.MigrateSkip})
+		_, err := planApp.RegisterResource(model)
+		require.Nil(t, err)
+		plans, err := planApp.PlanMigrations()
+		require.Nil(t, err)
+		if len(plans) == 0 {
+			return nil
+		}
+		return plans[0].Changes
+	}
+
+	_, err := jargo.NewApplication(jargo.Options{DB: app.DB()}).RegisterResource(UniqueMigrationTestType0{})
+	require.Nil(t, err)
+	require.Empty(t, constraints())
+
+	// adding the unique option adds the constraint
+	changes := plan(UniqueMigrationTestType1{})
+	require.Len(t, changes, 1)
+	require.Equal(t, jargo.ChangeAddConstraint, changes[0].Type)
+	_, err = jargo.NewApplication(jargo.Options{DB: app.DB(), MigrationMode: jargo.MigrateVerify}).
+		RegisterResource(UniqueMigrationTestType1{})
+	require.NotNil(t, err)
+
+	_, err = jargo.NewApplication(jargo.Options{DB: app.DB()}).RegisterResource(UniqueMigrationTestType1{})
+	require.Nil(t, err)
+	require.Equal(t, []string{"unique_migration_test_types_name_key"}, constraints())
+	require.Empty(t, plan(UniqueMigrationTestType1{}))
+
+	// removing the unique option drops the constraint
+	changes = plan(UniqueMigrationTestType0{})
+	require.Len(t, changes, 1)
+	require.Equal(t, jargo.ChangeDropConstraint, changes[0].Type)
+
+	_, err = jargo.NewApplication(jargo.Options{DB: app.DB()}).RegisterResource(UniqueMigrationTestType0{})
+	require.Nil(t, err)
+	require.Empty(t, constraints())
+	require.Empty(t, plan(UniqueMigrationTestType0{}))
+}
+
+type MigrationPlanTestType struct {
+	Id        int64 `jargo:",table:migration_plan_test_types"`
+	Name      string
+	UpdatedAt *time.Time `jargo:",updatedAt"`
+}
+
+// TestPlanMigrations tests the migration plans returned
+// by Application.PlanMigrations and the verify migration mode.
+func TestPlanMigrations(t *testing.T) {
+	// register resource without creating its table
+	planApp := jargo.NewApplication(jargo.Options{DB: app.DB(), MigrationMode: jargo.MigrateSkip})
+	resource, err := planApp.RegisterResource(MigrationPlanTestType{})
+	require.Nil(t, err)
+
+	plans, err := planApp.PlanMigrations()
+	require.Nil(t, err)
+	require.Len(t, plans, 1)
+	require.Equal(t, resource, plans[0].Resource)
+
+	changes := plans[0].Changes
+	require.Len(t, changes, 2)
+	require.Equal(t, jargo.ChangeCreateTable, changes[0].Type)
+	require.Equal(t, "migration_plan_test_types", changes[0].Table)
+	require.Equal(t, jargo.ChangeCreateTrigger, changes[1].Type)
+	require.Equal(t, "jargo_updated_at_trigger_updated_at", changes[1].Name)
+
+	// planning must not have created the table
+	var exists bool
+	_, err = app.DB().QueryOne(pg.Scan(&exists), `SELECT to_regclass('migration_plan_test_types') IS NOT NULL`)
+	require.Nil(t, err)
+	require.False(t, exists)
+
+	// verify mode fails while changes are pending
+	_, err = jargo.NewApplication(jargo.Options{DB: app.DB(), MigrationMode: jargo.MigrateVerify}).
+		RegisterResource(MigrationPlanTestType{})
+	require.NotNil(t, err)
+
+	// perform migration
+	_, err = app.RegisterResource(MigrationPlanTestType{})
+	require.Nil(t, err)
+
+	// verify mode succeeds after migrating
+	_, err = jargo.NewApplication(jargo.Options{DB: app.DB(), MigrationMode: jargo.MigrateVerify}).
+		RegisterResource(MigrationPlanTestType{})
+	require.Nil(t, err)
+
+	plans, err = planApp.PlanMigrations()
+	require.Nil(t, err)
+	require.Empty(t, plans)
+}
+
+// TestPlanTriggerMigrations tests that triggers
+// whose function was changed are replaced.
+func TestPlanTriggerMigrations(t *testing.T) {
+	resource, err := app.RegisterResource(MigrationPlanTestType{})
+	require.Nil(t, err)
+
+	// change the trigger function's definition
+	_, err = app.DB().Exec(`
+CREATE OR REPLACE FUNCTION jargo_updated_at_trigger_updated_at_func()
+RETURNS TRIGGER AS $$ BEGIN RETURN NEW; END $$ LANGUAGE plpgsql`)
+	require.Nil(t, err)
+
+	var changes []*jargo.SchemaChange
+	plans, err := app.PlanMigrations()
+	require.Nil(t, err)
+	for _, p := range plans {
+		if p.Resource == resource {
+			changes = p.Changes
+		}
+	}
+	require.Len(t, changes, 1)
+	require.Equal(t, jargo.ChangeReplaceTrigger, changes[0].Type)
+	require.Equal(t, "jargo_updated_at_trigger_updated_at", changes[0].Name)
+
+	// verify mode fails while the trigger function differs
+	_, err = jargo.NewApplication(jargo.Options{DB: app.DB(), MigrationMode: jargo.MigrateVerify}).
+		RegisterResource(MigrationPlanTestType{})
+	require.NotNil(t, err)
+
+	// perform migration
+	_, err = jargo.NewApplication(jargo.Options{DB: app.DB()}).
+		RegisterResource(MigrationPlanTestType{})
+	require.Nil(t, err)
+
+	_, err = jargo.NewApplication(jargo.Options{DB: app.DB(), MigrationMode: jargo.MigrateVerify}).
+		RegisterResource(MigrationPlanTestType{})
+	require.Nil(t, err)
+}
+
+type MigrationFileTestType struct {
+	Id   int64 `jargo:",table:migration_file_test_types"`
+	Name string
+}
+
+// TestMigrationFiles tests the generation
+// and application of migration files.
+func TestMigrationFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jargo_migrations")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	genApp := jargo.NewApplication(jargo.Options{DB: app.DB(), MigrationMode: jargo.MigrateSkip})
+	_, err = genApp.RegisterResource(MigrationFileTestType{})
+	require.Nil(t, err)
+
+	// generate migration from empty snapshot
+	file, err := genApp.GenerateMigrationFromSnapshot(dir, "initial")
+	require.Nil(t, err)
+	require.NotNil(t, file)
+	require.Equal(t, 1, file.Version)
+	require.Equal(t, filepath.Join(dir, "0001_initial.up.sql"), file.UpPath)
+
+	up, err := ioutil.ReadFile(file.UpPath)
+	require.Nil(t, err)
+	require.Contains(t, string(up), `CREATE TABLE "migration_file_test_types"`)
+	down, err := ioutil.ReadFile(file.DownPath)
+	require.Nil(t, err)
+	require.Equal(t, "DROP TABLE \"migration_file_test_types\";\n\n", string(down))
+
+	// the snapshot is up to date
+	file, err = genApp.GenerateMigrationFromSnapshot(dir, "unchanged")
+	require.Nil(t, err)
+	require.Nil(t, file)
+
+	// verify mode fails before applying migrations
+	verifyApp := jargo.NewApplication(jargo.Options{DB: app.DB(), MigrationMode: jargo.MigrateVerify})
+	_, err = verifyApp.RegisterResource(MigrationFileTestType{})
+	require.NotNil(t, err)
+
+	applied, err := genApp.ApplyMigrations(dir)
+	require.Nil(t, err)
+	require.Len(t, applied, 1)
+
+	// applying again is a no-op
+	applied, err = genApp.ApplyMigrations(dir)
+	require.Nil(t, err)
+	require.Empty(t, applied)
+
+	verifyApp = jargo.NewApplication(jargo.Options{DB: app.DB(), MigrationMode: jargo.MigrateVerify})
+	_, err = verifyApp.RegisterResource(MigrationFileTestType{})
+	require.Nil(t, err)
+
+	// revert migration, dropping the table
+	reverted, err := genApp.RevertMigration(dir)
+	require.Nil(t, err)
+	require.NotNil(t, reverted)
+	require.Equal(t, 1, reverted.Version)
+
+	var exists bool
+	_, err = app.DB().QueryOne(pg.Scan(&exists), `SELECT to_regclass('migration_file_test_types') IS NOT NULL`)
+	require.Nil(t, err)
+	require.False(t, exists)
+}
+
+type MigrationTriggerFileTestType struct {
+	Id      int64     `jargo:",table:migration_trigger_file_test_types"`
+	Expires time.Time `jargo:",expire"`
+}
+
+// TestMigrationFileTriggers tests that reverting a migration
+// creating a trigger drops its trigger function.
+func TestMigrationFileTriggers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jargo_migrations")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	genApp := jargo.NewApplication(jargo.Options{DB: app.DB(), MigrationMode: jargo.MigrateSkip})
+	_, err = genApp.RegisterResource(MigrationTriggerFileTestType{})
+	require.Nil(t, err)
+
+	_, err = genApp.GenerateMigrationFromSnapshot(dir, "initial")
+	require.Nil(t, err)
+	applied, err := genApp.ApplyMigrations(dir)
+	require.Nil(t, err)
+	require.Len(t, applied, 1)
+
+	var exists bool
+	_, err = app.DB().QueryOne(pg.Scan(&exists),
+		`SELECT to_regproc('jargo_expire_trigger_migration_trigger_file_test_types_func') IS NOT NULL`)
+	require.Nil(t, err)
+	require.True(t, exists)
+
+	_, err = genApp.RevertMigration(dir)
+	require.Nil(t, err)
+
+	_, err = app.DB().QueryOne(pg.Scan(&exists),
+		`SELECT to_regproc('jargo_expire_trigger_migration_trigger_file_test_types_func') IS NOT NULL`)
+	require.Nil(t, err)
+	require.False(t, exists)
+}
+
+type RenameTestType0 struct {
+	Id   int64 `jargo:",table:rename_test_types"`
+	Name string
+}
+
+type RenameTestType1 struct {
+	Id       int64  `jargo:",table:rename_test_types"`
+	FullName string `jargo:",renamedFrom:name"`
+}
+
+// TestRenamedFrom tests that columns of fields
+// with the "renamedFrom" option are renamed, keeping their data.
+func TestRenamedFrom(t *testing.T) {
+	resource0, err := app.RegisterResource(RenameTestType0{})
+	require.Nil(t, err)
+	_, err = resource0.InsertInstance(app.DB(), &RenameTestType0{
+		Name: "Peter",
+	}).Result()
+	require.Nil(t, err)
+
+	resource1, err := app.RegisterResource(RenameTestType1{})
+	require.Nil(t, err)
+
+	res, err := resource1.Select(app.DB()).
+		Where(`full_name = ?`, "Peter").
+		Result()
+	require.Nil(t, err)
+	require.Len(t, res.([]*RenameTestType1), 1)
+
+	// registering the renamed resource again is a no-op
+	verifyApp := jargo.NewApplication(jargo.Options{DB: app.DB(), MigrationMode: jargo.MigrateVerify})
+	_, err = verifyApp.RegisterResource(RenameTestType1{})
+	require.Nil(t, err)
+}
+
+type IndexTestType0 struct {
+	Id    int64  `jargo:",table:index_test_types"`
+	Name  string `jargo:",index"`
+	Email string `jargo:",index:contact"`
+}
+
+type IndexTestType1 struct {
+	Id    int64  `jargo:",table:index_test_types"`
+	Name  string `jargo:",index:name:desc"`
+	Email string
+}
+
+// TestIndexes tests that indexes declared using the
+// "index" option are created and dropped when migrating.
+func TestIndexes(t *testing.T) {
+	indexes := func() []string {
+		var names []string
+		_, err := app.DB().Query(&names, `SELECT indexname FROM pg_indexes WHERE tablename = 'index_test_types' AND indexname LIKE 'jargo\_idx\_%' ORDER BY indexname`)
+		require.Nil(t, err)
+		return names
+	}
+
+	_, err := app.RegisterResource(IndexTestType0{})
+	require.Nil(t, err)
+	names := indexes()
+	require.Len(t, names, 2)
+	require.Regexp(t, `^jargo_idx_index_test_types_contact_`, names[0])
+	require.Regexp(t, `^jargo_idx_index_test_types_name_`, names[1])
+
+	// changing the index definitions recreates them
+	migrateApp := jargo.NewApplication(jargo.Options{DB: app.DB()})
+	_, err = migrateApp.RegisterResource(IndexTestType1{})
+	require.Nil(t, err)
+	changed := indexes()
+	require.Len(t, changed, 1)
+	require.Regexp(t, `^jargo_idx_index_test_types_name_`, changed[0])
+	require.NotEqual(t, names[1], changed[0])
+
+	// registering the resource again is a no-op
+	verifyApp := jargo.NewApplication(jargo.Options{DB: app.DB(), MigrationMode: jargo.MigrateVerify})
+	_, err = verifyApp.RegisterResource(IndexTestType1{})
+	require.Nil(t, err)
+}
@@ -0,0 +1,78 @@
+// +build integration
+
+package integration
+
+import (
+	"github.com/crushedpixel/jargo"
+	"github.com/go-pg/pg"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+)
+
+type pgErrorTest struct {
+	Id    int64
+	Name  *string `jargo:",notnull,default:'unnamed'"`
+	Short string  `jargo:",type:varchar(5)"`
+}
+
+// TestNotNullViolation tests the mapping of
+// NOT NULL constraint violations.
+func TestNotNullViolation(t *testing.T) {
+	resource, err := app.RegisterResource(pgErrorTest{})
+	require.Nil(t, err)
+
+	res, err := resource.InsertInstance(app.DB(), &pgErrorTest{}).Result()
+	require.Nil(t, err)
+	instance := res.(*pgErrorTest)
+
+	instance.Name = nil
+	_, err = resource.UpdateInstance(app.DB(), instance).Result()
+	require.IsType(t, &jargo.NotNullViolationError{}, err)
+
+	nnve := err.(*jargo.NotNullViolationError)
+	require.Equal(t, "name", nnve.Field)
+	require.Equal(t, "name", nnve.Column)
+	require.Equal(t, "/data/attributes/name", nnve.SourcePointer())
+
+	payload, err := nnve.Payload()
+	require.Nil(t, err)
+	require.Contains(t, payload, `"source":{"pointer":"/data/attributes/name"}`)
+}
+
+// TestValueTooLong tests the mapping of errors
+// caused by values exceeding their column's length.
+func TestValueTooLong(t *testing.T) {
+	resource, err := app.RegisterResource(pgErrorTest{})
+	require.Nil(t, err)
+
+	_, err = resource.InsertInstance(app.DB(), &pgErrorTest{Short: "too long"}).Result()
+	require.IsType(t, &jargo.ValueTooLongError{}, err)
+	require.Equal(t, http.StatusBadRequest, err.(*jargo.ValueTooLongError).Status())
+}
+
+type pgErrorMapperTest struct {
+	Id    int64
+	Short string `jargo:",type:varchar(5)"`
+}
+
+// TestPGErrorMapper tests that PGErrorMappers registered
+// for SQLSTATE codes take precedence over the default mapping.
+func TestPGErrorMapper(t *testing.T) {
+	resource, err := app.RegisterResource(pgErrorMapperTest{})
+	require.Nil(t, err)
+
+	custom := jargo.NewApiErrorWithPointer(http.StatusUnprocessableEntity, "TOO_LONG", "short is too long",
+		"/data/attributes/short")
+	jargo.RegisterPGErrorMapper("22001", func(r *jargo.Resource, err pg.Error) error {
+		// fall back to the default mapping for other resources
+		if r != resource {
+			return nil
+		}
+		return custom
+	})
+	defer jargo.RegisterPGErrorMapper("22001", nil)
+
+	_, err = resource.InsertInstance(app.DB(), &pgErrorMapperTest{Short: "too long"}).Result()
+	require.Equal(t, custom, err)
+}
@@ -0,0 +1,250 @@
+// +build integration
+
+package integration
+
+import (
+	"context"
+	"fmt"
+	"github.com/crushedpixel/jargo"
+	"github.com/crushedpixel/jargo/realtime/client"
+	"github.com/desertbit/glue"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type realtimeClientTest struct {
+	Id   int64
+	Name string
+}
+
+// TestRealtimeClient tests the realtime client
+// against an in-process Realtime instance.
+func TestRealtimeClient(t *testing.T) {
+	resource, err := app.RegisterResource(realtimeClientTest{})
+	require.Nil(t, err)
+
+	res, err := resource.InsertInstance(app.DB(), &realtimeClientTest{Name: "Marius"}).Result()
+	require.Nil(t, err)
+	instance := res.(*realtimeClientTest)
+	id := strconv.FormatInt(instance.Id, 10)
+
+	realtime := jargo.NewRealtime(app, "/realtime-client")
+	require.Nil(t, realtime.Enable(resource))
+	realtime.HandleConnection = func(socket *glue.Socket, message string) bool {
+		return message == "secret"
+	}
+
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+	go func(ctx context.Context) {
+		realtime.Run(ctx)
+	}(ctx)
+
+	time.Sleep(1 * time.Second)
+
+	mux := http.NewServeMux()
+	realtime.Bridge(mux)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + realtime.Namespace()
+
+	// connections with an invalid connection message are rejected
+	_, err = client.Dial(url, client.Options{
+		ConnectionMessage: "invalid",
+	})
+	require.Equal(t, client.ErrConnectionDisallowed, err)
+
+	c, err := client.Dial(url, client.Options{
+		Resources:         []*jargo.Resource{resource, dummyResource},
+		ConnectionMessage: "secret",
+	})
+	require.Nil(t, err)
+	defer c.Close()
+
+	// realtime is not enabled for dummy
+	require.Equal(t, client.ErrInvalidResource, c.Subscribe(dummyResource, "1"))
+
+	require.Equal(t, client.ErrInvalidId, c.Subscribe(resource, "invalid"))
+
+	// ids are normalized, so subscribing to "+01" subscribes to "1"
+	require.Nil(t, c.Subscribe(resource, "+0"+id))
+
+	instance.Name = "Peter"
+	_, err = resource.UpdateInstance(app.DB(), instance).Result()
+	require.Nil(t, err)
+
+	select {
+	case e := <-c.Events():
+		require.Equal(t, client.Updated, e.Type)
+		require.Equal(t, resource, e.Resource)
+		require.Equal(t, id, e.Id)
+		require.Equal(t, "Peter", e.Instance.(*realtimeClientTest).Name)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+	}
+
+	require.Nil(t, c.Unsubscribe(resource, id))
+	require.Equal(t, client.ErrNotSubscribed, c.Unsubscribe(resource, id))
+}
+
+// TestRealtimeClientResume tests that events are received in order
+// and without duplicates when subscribing with a sequence number
+// while the subscribed instance is written to.
+func TestRealtimeClientResume(t *testing.T) {
+	resource, err := app.RegisterResource(realtimeClientTest{})
+	require.Nil(t, err)
+
+	res, err := resource.InsertInstance(app.DB(), &realtimeClientTest{Name: "Marius"}).Result()
+	require.Nil(t, err)
+	instance := res.(*realtimeClientTest)
+	id := strconv.FormatInt(instance.Id, 10)
+
+	realtime := jargo.NewRealtime(app, "/realtime-client-resume")
+	require.Nil(t, realtime.Enable(resource))
+
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+	go func(ctx context.Context) {
+		realtime.Run(ctx)
+	}(ctx)
+
+	time.Sleep(1 * time.Second)
+
+	mux := http.NewServeMux()
+	realtime.Bridge(mux)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + realtime.Namespace()
+	options := client.Options{
+		Resources: []*jargo.Resource{resource},
+	}
+
+	c, err := client.Dial(url, options)
+	require.Nil(t, err)
+	require.Nil(t, c.Subscribe(resource, id))
+
+	instance.Name = "Peter"
+	_, err = resource.UpdateInstance(app.DB(), instance).Result()
+	require.Nil(t, err)
+
+	var last int64
+	select {
+	case e := <-c.Events():
+		last = e.Seq
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+	}
+	c.Close()
+
+	// update the instance while disconnected
+	for i := 0; i < 5; i++ {
+		instance.Name = fmt.Sprintf("Missed %d", i)
+		_, err = resource.UpdateInstance(app.DB(), instance).Result()
+		require.Nil(t, err)
+	}
+
+	c, err = client.Dial(url, options)
+	require.Nil(t, err)
+	defer c.Close()
+
+	// keep updating the instance while resuming
+	written := make(chan error, 1)
+	go func() {
+		update := *instance
+		for i := 0; i < 20; i++ {
+			update.Name = fmt.Sprintf("Concurrent %d", i)
+			if _, err := resource.UpdateInstance(app.DB(), &update).Result(); err != nil {
+				written <- err
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		update.Name = "Final"
+		_, err := resource.UpdateInstance(app.DB(), &update).Result()
+		written <- err
+	}()
+
+	require.Nil(t, c.SubscribeSince(resource, id, last))
+
+	for {
+		select {
+		case e := <-c.Events():
+			require.Equal(t, client.Updated, e.Type)
+			require.True(t, e.Seq > last, "event %d received after event %d", e.Seq, last)
+			last = e.Seq
+
+			if e.Instance.(*realtimeClientTest).Name == "Final" {
+				require.Nil(t, <-written)
+				return
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("not all events received")
+		}
+	}
+}
+
+// TestRealtimeClientEventBuffer tests that responses are received
+// while events are unread and that the client is closed
+// once its event buffer is full.
+func TestRealtimeClientEventBuffer(t *testing.T) {
+	resource, err := app.RegisterResource(realtimeClientTest{})
+	require.Nil(t, err)
+
+	res, err := resource.InsertInstance(app.DB(), &realtimeClientTest{Name: "Marius"}).Result()
+	require.Nil(t, err)
+	instance := res.(*realtimeClientTest)
+	id := strconv.FormatInt(instance.Id, 10)
+
+	realtime := jargo.NewRealtime(app, "/realtime-client-event-buffer")
+	require.Nil(t, realtime.Enable(resource))
+
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+	go func(ctx context.Context) {
+		realtime.Run(ctx)
+	}(ctx)
+
+	time.Sleep(1 * time.Second)
+
+	mux := http.NewServeMux()
+	realtime.Bridge(mux)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + realtime.Namespace()
+	c, err := client.Dial(url, client.Options{
+		Resources:       []*jargo.Resource{resource},
+		EventBufferSize: 1,
+	})
+	require.Nil(t, err)
+	defer c.Close()
+	require.Nil(t, c.Subscribe(resource, id))
+
+	// fill the event buffer
+	instance.Name = "Peter"
+	_, err = resource.UpdateInstance(app.DB(), instance).Result()
+	require.Nil(t, err)
+	time.Sleep(500 * time.Millisecond)
+
+	// responses are received although events are unread
+	require.Nil(t, c.Unsubscribe(resource, id))
+	require.Nil(t, c.Subscribe(resource, id))
+
+	instance.Name = "Paul"
+	_, err = resource.UpdateInstance(app.DB(), instance).Result()
+	require.Nil(t, err)
+
+	select {
+	case <-c.Done():
+		require.Equal(t, client.ErrEventBufferFull, c.Err())
+	case <-time.After(5 * time.Second):
+		t.Fatal("client was not closed")
+	}
+}
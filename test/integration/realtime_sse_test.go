@@ -0,0 +1,292 @@
+// +build integration
+
+package integration
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/crushedpixel/jargo"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type realtimeSSETest struct {
+	Id   int64 `jargo:",realtime"`
+	Name string
+}
+
+// TestRealtimeSSE tests the Server-Sent Events transport of Realtime.
+func TestRealtimeSSE(t *testing.T) {
+	resource, err := app.RegisterResource(realtimeSSETest{})
+	require.Nil(t, err)
+
+	res, err := resource.InsertInstance(app.DB(), &realtimeSSETest{Name: "Marius"}).Result()
+	require.Nil(t, err)
+	instance := res.(*realtimeSSETest)
+
+	realtime := jargo.NewRealtime(app, "/realtime-sse")
+
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+	go func(ctx context.Context) {
+		realtime.Run(ctx)
+	}(ctx)
+
+	time.Sleep(1 * time.Second)
+
+	server := httptest.NewServer(realtime.SSEHandler())
+	defer server.Close()
+
+	// requests without subscriptions are rejected
+	response, err := http.Get(server.URL)
+	require.Nil(t, err)
+	require.Equal(t, http.StatusBadRequest, response.StatusCode)
+	response.Body.Close()
+
+	// subscribe to the inserted instance
+	url := fmt.Sprintf("%s?subscribe=%s:%d", server.URL, resource.JSONAPIName(), instance.Id)
+	response, err = http.Get(url)
+	require.Nil(t, err)
+	defer response.Body.Close()
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, "text/event-stream", response.Header.Get("Content-Type"))
+
+	// update the instance, triggering an updated event
+	instance.Name = "Peter"
+	_, err = resource.UpdateInstance(app.DB(), instance).Result()
+	require.Nil(t, err)
+
+	id, event, data := readSSEEvent(t, bufio.NewReader(response.Body))
+	require.NotEmpty(t, id)
+	require.Equal(t, "updated", event)
+	require.Contains(t, data, "Peter")
+	response.Body.Close()
+
+	// update the instance while disconnected
+	instance.Name = "Paul"
+	_, err = resource.UpdateInstance(app.DB(), instance).Result()
+	require.Nil(t, err)
+
+	// resume using the id of the last event received
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	require.Nil(t, err)
+	request.Header.Set("Last-Event-ID", id)
+	response, err = http.DefaultClient.Do(request)
+	require.Nil(t, err)
+	defer response.Body.Close()
+	require.Equal(t, http.StatusOK, response.StatusCode)
+
+	resumedId, event, data := readSSEEvent(t, bufio.NewReader(response.Body))
+	require.NotEqual(t, id, resumedId)
+	require.Equal(t, "updated", event)
+	require.Contains(t, data, "Paul")
+}
+
+// TestRealtimeSSEMayReceive tests that subscriptions are revoked
+// if MayReceiveSSE disallows sending a message.
+func TestRealtimeSSEMayReceive(t *testing.T) {
+	resource, err := app.RegisterResource(realtimeSSETest{})
+	require.Nil(t, err)
+
+	res, err := resource.InsertInstance(app.DB(), &realtimeSSETest{Name: "Marius"}).Result()
+	require.Nil(t, err)
+	instance := res.(*realtimeSSETest)
+
+	realtime := jargo.NewRealtime(app, "/realtime-sse-may-receive")
+	realtime.MayReceiveSSE = func(req *http.Request, resource *jargo.Resource, id string, instance interface{}) bool {
+		return instance != nil && instance.(*realtimeSSETest).Name != "Secret"
+	}
+
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+	go func(ctx context.Context) {
+		realtime.Run(ctx)
+	}(ctx)
+
+	time.Sleep(1 * time.Second)
+
+	server := httptest.NewServer(realtime.SSEHandler())
+	defer server.Close()
+
+	url := fmt.Sprintf("%s?subscribe=%s:%d", server.URL, resource.JSONAPIName(), instance.Id)
+	response, err := http.Get(url)
+	require.Nil(t, err)
+	defer response.Body.Close()
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	reader := bufio.NewReader(response.Body)
+
+	instance.Name = "Peter"
+	_, err = resource.UpdateInstance(app.DB(), instance).Result()
+	require.Nil(t, err)
+
+	_, event, data := readSSEEvent(t, reader)
+	require.Equal(t, "updated", event)
+	require.Contains(t, data, "Peter")
+
+	// the update is not sent and the subscription is revoked
+	instance.Name = "Secret"
+	_, err = resource.UpdateInstance(app.DB(), instance).Result()
+	require.Nil(t, err)
+
+	_, event, data = readSSEEvent(t, reader)
+	require.Equal(t, "revoked", event)
+	require.NotContains(t, data, "Secret")
+}
+
+// TestRealtimeSSECoalesce tests that updates within
+// the coalescing window are merged into a single message.
+func TestRealtimeSSECoalesce(t *testing.T) {
+	resource, err := app.RegisterResource(realtimeSSETest{})
+	require.Nil(t, err)
+
+	res, err := resource.InsertInstance(app.DB(), &realtimeSSETest{Name: "Marius"}).Result()
+	require.Nil(t, err)
+	instance := res.(*realtimeSSETest)
+
+	realtime := jargo.NewRealtime(app, "/realtime-sse-coalesce")
+	realtime.CoalesceWindow = 500 * time.Millisecond
+
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+	go func(ctx context.Context) {
+		realtime.Run(ctx)
+	}(ctx)
+
+	time.Sleep(1 * time.Second)
+
+	server := httptest.NewServer(realtime.SSEHandler())
+	defer server.Close()
+
+	url := fmt.Sprintf("%s?subscribe=%s:%d", server.URL, resource.JSONAPIName(), instance.Id)
+	response, err := http.Get(url)
+	require.Nil(t, err)
+	defer response.Body.Close()
+	require.Equal(t, http.StatusOK, response.StatusCode)
+
+	for _, name := range []string{"Peter", "Paul", "Mary"} {
+		instance.Name = name
+		_, err = resource.UpdateInstance(app.DB(), instance).Result()
+		require.Nil(t, err)
+	}
+
+	// the first message contains the most recent state
+	_, event, data := readSSEEvent(t, bufio.NewReader(response.Body))
+	require.Equal(t, "updated", event)
+	require.Contains(t, data, "Mary")
+}
+
+// readSSEEvent reads the next event from a text/event-stream,
+// returning the event id, name and data.
+func readSSEEvent(t *testing.T, reader *bufio.Reader) (string, string, string) {
+	var id, event, data string
+	for {
+		line, err := reader.ReadString('\n')
+		require.Nil(t, err)
+		line = strings.TrimSuffix(line, "\n")
+
+		switch {
+		case line == "":
+			if event != "" {
+				return id, event, data
+			}
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data += strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+// TestRealtimeSSEResumeConcurrentWrites tests that events are received
+// in order and without duplicates when resuming using the
+// Last-Event-ID header while the subscribed instance is written to.
+func TestRealtimeSSEResumeConcurrentWrites(t *testing.T) {
+	resource, err := app.RegisterResource(realtimeSSETest{})
+	require.Nil(t, err)
+
+	res, err := resource.InsertInstance(app.DB(), &realtimeSSETest{Name: "Marius"}).Result()
+	require.Nil(t, err)
+	instance := res.(*realtimeSSETest)
+
+	realtime := jargo.NewRealtime(app, "/realtime-sse-resume")
+
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+	go func(ctx context.Context) {
+		realtime.Run(ctx)
+	}(ctx)
+
+	time.Sleep(1 * time.Second)
+
+	server := httptest.NewServer(realtime.SSEHandler())
+	defer server.Close()
+
+	url := fmt.Sprintf("%s?subscribe=%s:%d", server.URL, resource.JSONAPIName(), instance.Id)
+	response, err := http.Get(url)
+	require.Nil(t, err)
+
+	instance.Name = "Peter"
+	_, err = resource.UpdateInstance(app.DB(), instance).Result()
+	require.Nil(t, err)
+
+	id, _, _ := readSSEEvent(t, bufio.NewReader(response.Body))
+	response.Body.Close()
+
+	// update the instance while disconnected
+	for i := 0; i < 5; i++ {
+		instance.Name = fmt.Sprintf("Missed %d", i)
+		_, err = resource.UpdateInstance(app.DB(), instance).Result()
+		require.Nil(t, err)
+	}
+
+	// keep updating the instance while resuming
+	written := make(chan error, 1)
+	go func() {
+		update := *instance
+		for i := 0; i < 20; i++ {
+			update.Name = fmt.Sprintf("Concurrent %d", i)
+			if _, err := resource.UpdateInstance(app.DB(), &update).Result(); err != nil {
+				written <- err
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		update.Name = "Final"
+		_, err := resource.UpdateInstance(app.DB(), &update).Result()
+		written <- err
+	}()
+
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	require.Nil(t, err)
+	request.Header.Set("Last-Event-ID", id)
+	response, err = http.DefaultClient.Do(request)
+	require.Nil(t, err)
+	defer response.Body.Close()
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	reader := bufio.NewReader(response.Body)
+
+	last, err := strconv.ParseInt(id, 10, 64)
+	require.Nil(t, err)
+	for {
+		eventId, event, data := readSSEEvent(t, reader)
+		require.Equal(t, "updated", event)
+
+		seq, err := strconv.ParseInt(eventId, 10, 64)
+		require.Nil(t, err)
+		require.True(t, seq > last, "event %d received after event %d", seq, last)
+		last = seq
+
+		if strings.Contains(data, "Final") {
+			break
+		}
+	}
+	require.Nil(t, <-written)
+}
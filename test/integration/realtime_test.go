@@ -4,19 +4,27 @@ package integration
 
 import (
 	"context"
+	"errors"
 	"github.com/crushedpixel/jargo"
+	"github.com/crushedpixel/jargo/realtime/client"
+	"github.com/desertbit/glue"
+	"github.com/go-pg/pg"
 	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
 
 type realtimeTestA struct {
-	Id int64
+	Id int64           `jargo:",realtime"`
 	Bs []realtimeTestB `jargo:",has:A"`
 }
 
 type realtimeTestB struct {
-	Id int64
+	Id int64         `jargo:",realtime"`
 	A  realtimeTestA `jargo:",belongsTo"`
 }
 
@@ -52,3 +60,439 @@ func TestRealtime(t *testing.T) {
 
 	time.Sleep(1 * time.Second)
 }
+
+type realtimeLargeRow struct {
+	Id      int64
+	Content string
+}
+
+// TestRealtimeLargeRow tests that writing rows exceeding
+// the pg_notify payload size limit does not fail
+// while realtime triggers are installed.
+func TestRealtimeLargeRow(t *testing.T) {
+	resource, err := app.RegisterResource(realtimeLargeRow{})
+	require.Nil(t, err)
+
+	realtime := jargo.NewRealtime(app, "/realtime-large-row")
+	require.Nil(t, realtime.Enable(resource))
+
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+	go func(ctx context.Context) {
+		realtime.Run(ctx)
+	}(ctx)
+
+	time.Sleep(1 * time.Second)
+
+	content := strings.Repeat("a", 10000)
+	res, err := resource.InsertInstance(app.DB(), &realtimeLargeRow{Content: content}).Result()
+	require.Nil(t, err)
+
+	instance := res.(*realtimeLargeRow)
+	instance.Content = strings.Repeat("b", 10000)
+	_, err = resource.UpdateInstance(app.DB(), instance).Result()
+	require.Nil(t, err)
+
+	_, err = resource.DeleteById(app.DB(), instance.Id).Result()
+	require.Nil(t, err)
+}
+
+// runRealtime runs a Realtime instance and serves it using
+// an httptest.Server, returning the websocket url to dial
+// and a function stopping both.
+func runRealtime(realtime *jargo.Realtime) (string, func()) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	go func(ctx context.Context) {
+		realtime.Run(ctx)
+	}(ctx)
+
+	time.Sleep(1 * time.Second)
+
+	mux := http.NewServeMux()
+	realtime.Bridge(mux)
+	server := httptest.NewServer(mux)
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + realtime.Namespace()
+	return url, func() {
+		server.Close()
+		cancelCtx()
+	}
+}
+
+// requireEvent waits for the next event received by a client.
+func requireEvent(t *testing.T, c *client.Client) *client.Event {
+	select {
+	case e := <-c.Events():
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+		return nil
+	}
+}
+
+// requireNoEvent ensures a client receives no event within a second.
+func requireNoEvent(t *testing.T, c *client.Client) {
+	select {
+	case e := <-c.Events():
+		t.Fatalf("unexpected event received: %+v", e)
+	case <-time.After(1 * time.Second):
+	}
+}
+
+type realtimeSubscriptionTest struct {
+	Id   int64
+	Name string
+}
+
+// TestRealtimeSubscriptions tests that subscribing multiple times
+// does not duplicate messages, that unsubscribing stops delivery
+// and that the connection context values of a socket
+// are removed once it is closed.
+func TestRealtimeSubscriptions(t *testing.T) {
+	resource, err := app.RegisterResource(realtimeSubscriptionTest{})
+	require.Nil(t, err)
+
+	res, err := resource.InsertInstance(app.DB(), &realtimeSubscriptionTest{Name: "Marius"}).Result()
+	require.Nil(t, err)
+	instance := res.(*realtimeSubscriptionTest)
+	id := strconv.FormatInt(instance.Id, 10)
+
+	type key struct{}
+	sockets := make(chan *glue.Socket, 1)
+
+	realtime := jargo.NewRealtime(app, "/realtime-subscriptions")
+	require.Nil(t, realtime.Enable(resource))
+	realtime.HandleConnection = func(socket *glue.Socket, message string) bool {
+		realtime.SetValue(socket, key{}, message)
+		sockets <- socket
+		return true
+	}
+
+	url, stop := runRealtime(realtime)
+	defer stop()
+
+	c, err := client.Dial(url, client.Options{
+		Resources:         []*jargo.Resource{resource},
+		ConnectionMessage: "Marius",
+	})
+	require.Nil(t, err)
+	defer c.Close()
+	socket := <-sockets
+	require.Equal(t, "Marius", realtime.Value(socket, key{}))
+
+	// subscribing twice results in a single subscription
+	require.Nil(t, c.Subscribe(resource, id))
+	require.Nil(t, c.Subscribe(resource, id))
+
+	instance.Name = "Peter"
+	_, err = resource.UpdateInstance(app.DB(), instance).Result()
+	require.Nil(t, err)
+
+	e := requireEvent(t, c)
+	require.Equal(t, client.Updated, e.Type)
+	require.Equal(t, "Peter", e.Instance.(*realtimeSubscriptionTest).Name)
+	requireNoEvent(t, c)
+
+	// no messages are received after unsubscribing
+	require.Nil(t, c.Unsubscribe(resource, id))
+	require.Equal(t, client.ErrNotSubscribed, c.Unsubscribe(resource, id))
+
+	instance.Name = "Paul"
+	_, err = resource.UpdateInstance(app.DB(), instance).Result()
+	require.Nil(t, err)
+	requireNoEvent(t, c)
+
+	// connection context values are removed on close
+	c.Close()
+	time.Sleep(500 * time.Millisecond)
+	require.Nil(t, realtime.Value(socket, key{}))
+}
+
+// TestRealtimeReconnect tests that errors of the notification listener
+// are passed to the ErrorHandler and that the listener is re-established,
+// invoking the MissedEvents handler, which replays missed events once.
+func TestRealtimeReconnect(t *testing.T) {
+	resource, err := app.RegisterResource(realtimeSubscriptionTest{})
+	require.Nil(t, err)
+
+	res, err := resource.InsertInstance(app.DB(), &realtimeSubscriptionTest{Name: "Marius"}).Result()
+	require.Nil(t, err)
+	instance := res.(*realtimeSubscriptionTest)
+	id := strconv.FormatInt(instance.Id, 10)
+
+	errs := make(chan error, 16)
+	missed := make(chan struct{}, 16)
+
+	realtime := jargo.NewRealtime(app, "/realtime-reconnect")
+	require.Nil(t, realtime.Enable(resource))
+	realtime.ReconnectMinBackoff = 500 * time.Millisecond
+	realtime.ErrorHandler = func(err error) {
+		errs <- err
+	}
+	realtime.MissedEvents = func(r *jargo.Realtime) {
+		missed <- struct{}{}
+		jargo.ReplayMissedEvents(r)
+	}
+
+	url, stop := runRealtime(realtime)
+	defer stop()
+
+	c, err := client.Dial(url, client.Options{
+		Resources: []*jargo.Resource{resource},
+	})
+	require.Nil(t, err)
+	defer c.Close()
+	require.Nil(t, c.Subscribe(resource, id))
+
+	// terminate the connections of all notification listeners
+	disconnect := func() {
+		_, err := app.DB().Exec(`SELECT pg_terminate_backend(pid) FROM pg_stat_activity
+WHERE pid <> pg_backend_pid() AND query LIKE 'LISTEN %'`)
+		require.Nil(t, err)
+
+		select {
+		case <-errs:
+		case <-time.After(5 * time.Second):
+			t.Fatal("listener error was not passed to the ErrorHandler")
+		}
+	}
+	reconnected := func() {
+		select {
+		case <-missed:
+		case <-time.After(5 * time.Second):
+			t.Fatal("listener was not re-established")
+		}
+	}
+
+	// changes made while disconnected are replayed
+	disconnect()
+	instance.Name = "Paul"
+	_, err = resource.UpdateInstance(app.DB(), instance).Result()
+	require.Nil(t, err)
+	reconnected()
+
+	e := requireEvent(t, c)
+	require.Equal(t, client.Updated, e.Type)
+	require.Equal(t, "Paul", e.Instance.(*realtimeSubscriptionTest).Name)
+	requireNoEvent(t, c)
+
+	// replayed events are not replayed again
+	disconnect()
+	reconnected()
+	requireNoEvent(t, c)
+
+	// messages are received after reconnecting
+	instance.Name = "Peter"
+	_, err = resource.UpdateInstance(app.DB(), instance).Result()
+	require.Nil(t, err)
+
+	e = requireEvent(t, c)
+	require.Equal(t, client.Updated, e.Type)
+	require.Equal(t, "Peter", e.Instance.(*realtimeSubscriptionTest).Name)
+}
+
+// TestRealtimeBearerToken tests the connection handshake
+// using BearerTokenHandshake and the identities it stores.
+func TestRealtimeBearerToken(t *testing.T) {
+	resource, err := app.RegisterResource(realtimeSubscriptionTest{})
+	require.Nil(t, err)
+
+	realtime := jargo.NewRealtime(app, "/realtime-bearer-token")
+	require.Nil(t, realtime.Enable(resource))
+	realtime.HandleConnection = realtime.BearerTokenHandshake(func(token string) (interface{}, error) {
+		if token != "valid" {
+			return nil, errors.New("invalid token")
+		}
+		return "Marius", nil
+	})
+	realtime.MaySubscribe = func(socket *glue.Socket, resource *jargo.Resource, id string) bool {
+		return realtime.Identity(socket) == "Marius"
+	}
+
+	url, stop := runRealtime(realtime)
+	defer stop()
+
+	// connection messages without a valid token are rejected
+	for _, message := range []string{"valid", "Bearer ", "Bearer invalid"} {
+		_, err = client.Dial(url, client.Options{
+			ConnectionMessage: message,
+		})
+		require.Equal(t, client.ErrConnectionDisallowed, err)
+	}
+
+	c, err := client.Dial(url, client.Options{
+		Resources:         []*jargo.Resource{resource},
+		ConnectionMessage: "Bearer valid",
+	})
+	require.Nil(t, err)
+	defer c.Close()
+
+	// the identity is available to MaySubscribe
+	require.Nil(t, c.Subscribe(resource, "1"))
+}
+
+// TestRealtimeCheckOrigin tests that connection requests
+// are rejected if CheckOrigin disallows their origin.
+func TestRealtimeCheckOrigin(t *testing.T) {
+	realtime := jargo.NewRealtime(app, "/realtime-check-origin")
+	realtime.CheckOrigin = func(req *http.Request) bool {
+		return req.Header.Get("Origin") == "https://example.com"
+	}
+
+	url, stop := runRealtime(realtime)
+	defer stop()
+
+	_, err := client.Dial(url, client.Options{
+		Header: http.Header{"Origin": {"https://evil.com"}},
+	})
+	require.NotNil(t, err)
+
+	c, err := client.Dial(url, client.Options{
+		Header: http.Header{"Origin": {"https://example.com"}},
+	})
+	require.Nil(t, err)
+	c.Close()
+}
+
+type realtimeTriggerTest struct {
+	Id   int64
+	Name string
+}
+
+// TestRealtimeTriggers tests that disabling realtime for a resource
+// only drops the realtime triggers no other Realtime instance requires
+// and that triggers left behind are dropped by migrations.
+func TestRealtimeTriggers(t *testing.T) {
+	resource, err := app.RegisterResource(realtimeTriggerTest{})
+	require.Nil(t, err)
+
+	res, err := resource.InsertInstance(app.DB(), &realtimeTriggerTest{Name: "Marius"}).Result()
+	require.Nil(t, err)
+	instance := res.(*realtimeTriggerTest)
+	id := strconv.FormatInt(instance.Id, 10)
+
+	realtime1 := jargo.NewRealtime(app, "/realtime-triggers-1")
+	require.Nil(t, realtime1.Enable(resource))
+	realtime2 := jargo.NewRealtime(app, "/realtime-triggers-2")
+	require.Nil(t, realtime2.Enable(resource))
+
+	_, stop1 := runRealtime(realtime1)
+	url2, stop2 := runRealtime(realtime2)
+	defer stop2()
+
+	triggerExists := func() bool {
+		var exists bool
+		_, err := app.DB().QueryOne(pg.Scan(&exists), `SELECT EXISTS (SELECT 1 FROM pg_trigger
+WHERE tgrelid = to_regclass('realtime_trigger_tests') AND tgname = 'jargo_realtime_trigger_tests_notify')`)
+		require.Nil(t, err)
+		return exists
+	}
+	require.True(t, triggerExists())
+
+	c, err := client.Dial(url2, client.Options{Resources: []*jargo.Resource{resource}})
+	require.Nil(t, err)
+	defer c.Close()
+	require.Nil(t, c.Subscribe(resource, id))
+
+	// disabling realtime and stopping the first instance
+	// must not affect the second instance
+	require.Nil(t, realtime1.Disable(resource))
+	require.False(t, realtime1.Enabled(resource))
+	require.True(t, triggerExists())
+	stop1()
+	time.Sleep(500 * time.Millisecond)
+
+	instance.Name = "Peter"
+	_, err = resource.UpdateInstance(app.DB(), instance).Result()
+	require.Nil(t, err)
+
+	e := requireEvent(t, c)
+	require.Equal(t, client.Updated, e.Type)
+	require.Equal(t, "Peter", e.Instance.(*realtimeTriggerTest).Name)
+
+	// disabling realtime in the last instance drops the trigger
+	require.Nil(t, realtime2.Disable(resource))
+	require.False(t, triggerExists())
+
+	// triggers are left behind by instances that stop running
+	require.Nil(t, realtime2.Enable(resource))
+	require.True(t, triggerExists())
+	stop2()
+	time.Sleep(500 * time.Millisecond)
+
+	// the trigger isn't required by the realtime options
+	// and is therefore dropped by a planned change,
+	// which doesn't fail the verify migration mode
+	var change *jargo.SchemaChange
+	plans, err := app.PlanMigrations()
+	require.Nil(t, err)
+	for _, p := range plans {
+		if p.Resource == resource {
+			require.Len(t, p.Changes, 1)
+			change = p.Changes[0]
+		}
+	}
+	require.NotNil(t, change)
+	require.Equal(t, jargo.ChangeDropRealtimeTrigger, change.Type)
+	require.Equal(t, "jargo_realtime_trigger_tests_notify", change.Name)
+
+	_, err = jargo.NewApplication(jargo.Options{DB: app.DB(), MigrationMode: jargo.MigrateVerify}).
+		RegisterResource(realtimeTriggerTest{})
+	require.Nil(t, err)
+
+	_, err = app.DB().Exec(change.SQL)
+	require.Nil(t, err)
+
+	plans, err = app.PlanMigrations()
+	require.Nil(t, err)
+	for _, p := range plans {
+		require.NotEqual(t, resource, p.Resource)
+	}
+}
+
+type realtimeRefreshTest struct {
+	Id      int64     `jargo:",realtime"`
+	Expires time.Time `jargo:",expire,ttl:1h"`
+}
+
+// TestRealtimeRefreshExpiration tests that refreshing the expiration
+// of a record that was just refreshed doesn't update it,
+// causing no realtime event.
+func TestRealtimeRefreshExpiration(t *testing.T) {
+	resource, err := app.RegisterResource(realtimeRefreshTest{})
+	require.Nil(t, err)
+
+	res, err := resource.InsertInstance(app.DB(), &realtimeRefreshTest{
+		Expires: time.Now().Add(time.Hour),
+	}).Result()
+	require.Nil(t, err)
+	created := res.(*realtimeRefreshTest)
+	id := strconv.FormatInt(created.Id, 10)
+
+	realtime := jargo.NewRealtime(app, "/realtime-refresh")
+	url, stop := runRealtime(realtime)
+	defer stop()
+
+	c, err := client.Dial(url, client.Options{Resources: []*jargo.Resource{resource}})
+	require.Nil(t, err)
+	defer c.Close()
+	require.Nil(t, c.Subscribe(resource, id))
+
+	res, err = resource.SelectById(app.DB(), created.Id).RefreshExpiration().Result()
+	require.Nil(t, err)
+	require.True(t, res.(*realtimeRefreshTest).Expires.Equal(created.Expires))
+	requireNoEvent(t, c)
+
+	// records are refreshed once a tenth of the ttl passed
+	_, err = app.DB().Exec(`UPDATE "realtime_refresh_tests" SET expires = NOW() + INTERVAL '30 minutes' WHERE id = ?`,
+		created.Id)
+	require.Nil(t, err)
+	e := requireEvent(t, c)
+	require.Equal(t, client.Updated, e.Type)
+
+	res, err = resource.SelectById(app.DB(), created.Id).RefreshExpiration().Result()
+	require.Nil(t, err)
+	require.True(t, res.(*realtimeRefreshTest).Expires.After(created.Expires))
+	e = requireEvent(t, c)
+	require.Equal(t, client.Updated, e.Type)
+}
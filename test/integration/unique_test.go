@@ -33,3 +33,56 @@ func TestUniqueAttributes(t *testing.T) {
 	require.Equal(t, "user_name", uve.Column)
 	require.Equal(t, "user-name", uve.Field)
 }
+
+type uniqueComposite struct {
+	Id        int64  `jargo:",table:custom_unique_composites"`
+	FirstName string `jargo:",unique:full_name"`
+	LastName  string `jargo:",unique:full_name"`
+	Email     string `jargo:",unique"`
+}
+
+// TestUniqueComposite tests the behaviour of
+// composite unique constraints and unique constraints
+// of resources with custom table names.
+func TestUniqueComposite(t *testing.T) {
+	resource, err := app.RegisterResource(uniqueComposite{})
+	require.Nil(t, err)
+
+	_, err = resource.InsertInstance(app.DB(), &uniqueComposite{
+		FirstName: "Marius",
+		LastName:  "Metzger",
+		Email:     "marius@example.com",
+	}).Result()
+	require.Nil(t, err)
+
+	// a single equal column does not violate the composite constraint
+	_, err = resource.InsertInstance(app.DB(), &uniqueComposite{
+		FirstName: "Marius",
+		LastName:  "Müller",
+		Email:     "mueller@example.com",
+	}).Result()
+	require.Nil(t, err)
+
+	_, err = resource.InsertInstance(app.DB(), &uniqueComposite{
+		FirstName: "Marius",
+		LastName:  "Metzger",
+		Email:     "metzger@example.com",
+	}).Result()
+	require.IsType(t, &jargo.UniqueViolationError{}, err)
+
+	uve := err.(*jargo.UniqueViolationError)
+	require.Equal(t, []string{"first-name", "last-name"}, uve.Fields)
+	require.Equal(t, []string{"first_name", "last_name"}, uve.Columns)
+	require.Equal(t, "first-name", uve.Field)
+
+	_, err = resource.InsertInstance(app.DB(), &uniqueComposite{
+		FirstName: "Peter",
+		LastName:  "Metzger",
+		Email:     "marius@example.com",
+	}).Result()
+	require.IsType(t, &jargo.UniqueViolationError{}, err)
+
+	uve = err.(*jargo.UniqueViolationError)
+	require.Equal(t, []string{"email"}, uve.Fields)
+	require.Equal(t, "email", uve.Column)
+}
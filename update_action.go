@@ -60,9 +60,11 @@ func (a *UpdateAction) Handle(req *UpdateRequest) Response {
 		}
 	}
 
-	// create update query
+	// create update query, refreshing the expiration
+	// of resources with sliding expiration
 	q := req.Resource().UpdateInstance(req.DB(), instance).
-		Fields(req.Fields())
+		Fields(req.Fields()).
+		RefreshExpiration()
 
 	// if set, apply beforeQuery handler
 	if a.beforeQuery != nil {
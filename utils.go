@@ -10,22 +10,6 @@ func escapePGColumn(field string) string {
 	return string(b)
 }
 
-// difference returns the elements in a that aren't in b.
-// Modified from https://stackoverflow.com/a/45428032/2733724
-func difference(a, b []interface{}) []interface{} {
-	mb := make(map[interface{}]bool)
-	for _, x := range b {
-		mb[x] = true
-	}
-	var ab []interface{}
-	for _, x := range a {
-		if _, ok := mb[x]; !ok {
-			ab = append(ab, x)
-		}
-	}
-	return ab
-}
-
 // NormalizeNamespace ensures that the namespace starts and ends with a slash.
 func NormalizeNamespace(namespace string) string {
 	// prepend slash to namespace